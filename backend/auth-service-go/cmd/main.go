@@ -2,9 +2,18 @@ package main
 
 import (
 	"auth/internal/database"
+	"auth/internal/events"
+	"auth/internal/federation"
 	"auth/internal/handlers"
+	"auth/internal/mailer"
 	"auth/internal/models"
+	"auth/internal/oauth"
+	"auth/internal/scim"
+	"auth/internal/util"
+	"lms/pkg/audit"
+	"lms/pkg/jwtauth"
 	"lms/pkg/middleware"
+	"lms/pkg/permissions"
 	"log"
 	"net/http"
 	"os"
@@ -18,42 +27,164 @@ func main() {
 		log.Printf("Warning: error loading .env file: %v", err)
 	}
 
-	if os.Getenv("JWT_SECRET") == "" {
-		log.Fatal("FATAL: JWT_SECRET environment variable is not set")
+	if err := oauth.LoadSigningKeys(); err != nil {
+		log.Fatalf("FATAL: Could not load JWT signing keys: %v", err)
 	}
+	// Verify our own tokens against the in-memory key set rather than fetching our own JWKS.
+	jwtauth.SetKeyProvider(oauth.PublicKey)
 
 	db, err := database.ConnectDatabase()
 	if err != nil {
 		log.Fatalf("Could not connect to the database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.StudentProfile{}, &models.InstructorProfile{}, &models.AdminProfile{})
+	err = db.AutoMigrate(&models.User{}, &models.StudentProfile{}, &models.InstructorProfile{}, &models.AdminProfile{},
+		&models.Session{}, &models.RefreshToken{}, &models.PasswordResetToken{},
+		&models.TOTPCredential{}, &models.RecoveryCode{}, &models.LoginAttempt{}, &models.KnownDevice{},
+		&models.PasswordHistory{}, &models.RoleChange{}, &models.TAAssignment{}, &models.Permission{}, &models.Role{},
+		&models.ServiceAccount{}, &models.APIKey{},
+		&models.OIDCClient{}, &models.AuthorizationCode{}, &models.OIDCConsent{},
+		&models.FederatedIdentity{}, &models.FederatedLoginState{})
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
+	if err := database.SeedPermissions(db); err != nil {
+		log.Fatalf("Failed to seed roles and permissions: %v", err)
+	}
+
+	// Admin changes go to the audit log shared with the ERP service
+	auditLog := audit.New(db, "auth")
+	if err := auditLog.Migrate(); err != nil {
+		log.Fatalf("Failed to migrate audit log: %v", err)
+	}
+
+	// The auth service owns the sessions table, so it checks revocation directly
+	// instead of calling itself over HTTP like the other services do.
+	jwtauth.SetSessionValidator(func(sessionID, _ string) (bool, error) {
+		return database.IsSessionActive(db, sessionID)
+	})
+
+	mail := mailer.FromEnv()
+	notifier := events.FromEnv(mail)
+	// Login through the university identity provider, when FEDERATION_ISSUER is set
+	idp := federation.NewProvider(federation.ConfigFromEnv())
 
 	// --- ROUTER ---
 	router := http.NewServeMux()
 
+	// Per-IP limiter for endpoints that check secrets, on top of the per-account lockout in Login.
+	loginLimiter := middleware.NewRateLimiter(
+		float64(util.EnvInt("LOGIN_RATE_PER_MINUTE", 10)),
+		util.EnvInt("LOGIN_RATE_BURST", 5),
+	)
+
 	// --- Public Routes ---
-	router.HandleFunc("POST /login", handlers.Login(db))
+	router.Handle("POST /login", loginLimiter.Middleware(handlers.Login(db, notifier)))
+	router.Handle("POST /login/mfa", loginLimiter.Middleware(handlers.LoginMFA(db, notifier)))
+	router.Handle("POST /login/mfa/enroll", loginLimiter.Middleware(handlers.LoginEnrollTOTP(db)))
+	router.Handle("POST /login/mfa/enroll/confirm", loginLimiter.Middleware(handlers.LoginConfirmTOTP(db, notifier)))
+	router.HandleFunc("POST /token/refresh", handlers.RefreshToken(db))
+	// Needs an access token for the session asked about; see GetSessionStatus
+	router.HandleFunc("GET /sessions/{id}", handlers.GetSessionStatus(db))
+	router.HandleFunc("GET /.well-known/jwks.json", handlers.GetJWKS())
+	router.Handle("POST /password/forgot", loginLimiter.Middleware(handlers.ForgotPassword(db, mail)))
+	router.Handle("POST /password/reset", loginLimiter.Middleware(handlers.ResetPassword(db)))
+	router.HandleFunc("GET /login/federated", handlers.StartFederatedLogin(db, idp))
+	router.Handle("POST /login/federated/callback", loginLimiter.Middleware(handlers.FederatedCallback(db, idp, auditLog, notifier)))
+
+	// --- OAuth 2.0 / OpenID Connect provider ---
+	router.HandleFunc("GET /.well-known/openid-configuration", handlers.OpenIDConfiguration())
+	router.HandleFunc("GET /oauth/authorize", handlers.StartAuthorization(db))
+	router.Handle("POST /oauth/authorize", middleware.AuthMiddleware(handlers.Authorize(db)))
+	router.Handle("POST /oauth/token", loginLimiter.Middleware(handlers.Token(db)))
+	router.Handle("GET /oauth/userinfo", middleware.ClientTokenMiddleware(handlers.UserInfo(db)))
+	router.Handle("POST /oauth/userinfo", middleware.ClientTokenMiddleware(handlers.UserInfo(db)))
+
+	// --- SCIM 2.0 provisioning (HR and student information systems) ---
+	// Protected by the static bearer token in SCIM_TOKEN; without one, SCIM is off.
+	scimRoutes := http.NewServeMux()
+	scimRoutes.HandleFunc("GET /ServiceProviderConfig", handlers.SCIMServiceProviderConfig())
+	scimRoutes.HandleFunc("GET /ResourceTypes", handlers.SCIMResourceTypes())
+	scimRoutes.HandleFunc("GET /Users", handlers.SCIMListUsers(db))
+	scimRoutes.HandleFunc("POST /Users", handlers.SCIMCreateUser(db, auditLog))
+	scimRoutes.HandleFunc("GET /Users/{id}", handlers.SCIMGetUser(db))
+	scimRoutes.HandleFunc("PUT /Users/{id}", handlers.SCIMReplaceUser(db, auditLog))
+	scimRoutes.HandleFunc("PATCH /Users/{id}", handlers.SCIMPatchUser(db, auditLog))
+	scimRoutes.HandleFunc("DELETE /Users/{id}", handlers.SCIMDeleteUser(db, auditLog))
+	scimRoutes.HandleFunc("GET /Groups", handlers.SCIMListGroups(db))
+	scimRoutes.HandleFunc("POST /Groups", handlers.SCIMCreateGroup(db, auditLog))
+	scimRoutes.HandleFunc("GET /Groups/{id}", handlers.SCIMGetGroup(db))
+	scimRoutes.HandleFunc("PUT /Groups/{id}", handlers.SCIMReplaceGroup(db, auditLog))
+	scimRoutes.HandleFunc("PATCH /Groups/{id}", handlers.SCIMPatchGroup(db, auditLog))
+	scimRoutes.HandleFunc("DELETE /Groups/{id}", handlers.SCIMDeleteGroup(db, auditLog))
+	router.Handle("/scim/v2/", http.StripPrefix("/scim/v2", scim.RequireToken(os.Getenv("SCIM_TOKEN"))(scimRoutes)))
 
 	// --- Authenticated Routes (for any logged-in user) ---
 	// Create a new router for routes that require any valid token
 	authenticatedRoutes := http.NewServeMux()
 	authenticatedRoutes.HandleFunc("GET /users/me", handlers.GetCurrentUser(db))
+	authenticatedRoutes.HandleFunc("POST /users/me/password", handlers.ChangePassword(db))
+	authenticatedRoutes.HandleFunc("POST /users/me/mfa/totp", handlers.EnrollTOTP(db))
+	authenticatedRoutes.HandleFunc("POST /users/me/mfa/totp/confirm", handlers.ConfirmTOTP(db))
+	authenticatedRoutes.HandleFunc("DELETE /users/me/mfa/totp", handlers.DisableTOTP(db))
+	authenticatedRoutes.HandleFunc("POST /users/me/mfa/recovery-codes", handlers.RegenerateRecoveryCodes(db))
+	authenticatedRoutes.HandleFunc("GET /users/me/sessions", handlers.ListMySessions(db))
+	authenticatedRoutes.HandleFunc("DELETE /users/me/sessions/{sessionId}", handlers.RevokeMySession(db))
+	authenticatedRoutes.HandleFunc("GET /users/me/logins", handlers.ListMyLogins(db))
 	// Apply the general auth middleware
 	router.Handle("/users/", middleware.AuthMiddleware(authenticatedRoutes))
+	router.Handle("POST /logout", middleware.AuthMiddleware(http.HandlerFunc(handlers.Logout(db))))
+	router.Handle("POST /logout-all", middleware.AuthMiddleware(http.HandlerFunc(handlers.LogoutAll(db))))
+	// Checks per request: TA managers see everything, instructors only their own courses
+	router.Handle("GET /ta-assignments", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListTAAssignments(db))))
 
-	// --- Admin-Only Routes ---
+	// --- Admin Routes ---
+	// Each route requires a permission rather than the admin role, so staff roles such as
+	// registrar or auditor can be given a subset of them.
+	can := func(permission string, h http.HandlerFunc) http.Handler {
+		return middleware.RequirePermission(permission)(h)
+	}
 	adminRoutes := http.NewServeMux()
-	adminRoutes.HandleFunc("POST /users", handlers.CreateUser(db))
+	adminRoutes.Handle("POST /users", can(permissions.UsersManage, handlers.CreateUser(db, auditLog)))
+	adminRoutes.Handle("GET /users", can(permissions.UsersRead, handlers.ListUsers(db)))
+	adminRoutes.Handle("POST /users/import", can(permissions.UsersManage, handlers.ImportUsers(db, mail, auditLog)))
 	// Add the new GET and PUT routes for a specific user ID
-	adminRoutes.HandleFunc("GET /users/{id}", handlers.GetUserByID(db))
-	adminRoutes.HandleFunc("PUT /users/{id}", handlers.UpdateUser(db))
+	adminRoutes.Handle("GET /users/{id}", can(permissions.UsersRead, handlers.GetUserByID(db)))
+	adminRoutes.Handle("PUT /users/{id}", can(permissions.UsersManage, handlers.UpdateUser(db, auditLog)))
+	adminRoutes.Handle("PUT /users/{id}/email", can(permissions.UsersManage, handlers.ChangeUserEmail(db, mail, auditLog)))
+	adminRoutes.Handle("POST /users/{id}/role", can(permissions.UsersManage, handlers.ChangeUserRole(db, auditLog)))
+	adminRoutes.Handle("POST /users/{id}/unlock", can(permissions.UsersManage, handlers.UnlockUser(db, auditLog)))
+	adminRoutes.Handle("POST /users/{id}/deactivate", can(permissions.UsersManage, handlers.DeactivateUser(db, auditLog)))
+	adminRoutes.Handle("POST /users/{id}/reactivate", can(permissions.UsersManage, handlers.ReactivateUser(db, auditLog)))
+	adminRoutes.Handle("GET /users/{id}/identities", can(permissions.UsersRead, handlers.ListFederatedIdentities(db)))
+	adminRoutes.Handle("DELETE /users/{id}/identities/{identityId}", can(permissions.UsersManage, handlers.UnlinkFederatedIdentity(db, auditLog)))
+	adminRoutes.Handle("GET /users/{id}/sessions", can(permissions.UsersRead, handlers.ListUserSessions(db)))
+	adminRoutes.Handle("DELETE /users/{id}/sessions/{sessionId}", can(permissions.UsersManage, handlers.RevokeUserSession(db, auditLog)))
+	adminRoutes.Handle("GET /users/{id}/logins", can(permissions.UsersRead, handlers.ListUserLogins(db)))
+	adminRoutes.Handle("GET /users/{id}/export", can(permissions.PrivacyManage, handlers.ExportUserData(db, auditLog)))
+	adminRoutes.Handle("POST /users/{id}/anonymize", can(permissions.PrivacyManage, handlers.AnonymizeUser(db, auditLog)))
+	adminRoutes.Handle("POST /users/{id}/impersonate", can(permissions.Impersonate, handlers.ImpersonateUser(db, auditLog)))
+	adminRoutes.Handle("DELETE /impersonations/{sessionId}", can(permissions.Impersonate, handlers.EndImpersonation(db, auditLog)))
+	adminRoutes.Handle("POST /ta-assignments", can(permissions.TAManage, handlers.GrantTA(db, auditLog)))
+	adminRoutes.Handle("DELETE /ta-assignments/{userId}/{courseId}/{semester}", can(permissions.TAManage, handlers.RevokeTA(db, auditLog)))
+	adminRoutes.Handle("GET /roles", can(permissions.RolesManage, handlers.ListRoles(db)))
+	adminRoutes.Handle("GET /permissions", can(permissions.RolesManage, handlers.ListPermissions(db)))
+	adminRoutes.Handle("PUT /roles/{name}", can(permissions.RolesManage, handlers.SaveRole(db, auditLog)))
+	adminRoutes.Handle("DELETE /roles/{name}", can(permissions.RolesManage, handlers.DeleteRole(db, auditLog)))
+	adminRoutes.Handle("POST /service-accounts", can(permissions.ServiceAccountsManage, handlers.CreateServiceAccount(db, auditLog)))
+	adminRoutes.Handle("GET /service-accounts", can(permissions.ServiceAccountsManage, handlers.ListServiceAccounts(db)))
+	adminRoutes.Handle("PATCH /service-accounts/{id}", can(permissions.ServiceAccountsManage, handlers.UpdateServiceAccount(db, auditLog)))
+	adminRoutes.Handle("POST /service-accounts/{id}/keys", can(permissions.ServiceAccountsManage, handlers.CreateAPIKey(db, auditLog)))
+	adminRoutes.Handle("DELETE /service-accounts/{id}/keys/{keyId}", can(permissions.ServiceAccountsManage, handlers.RevokeAPIKey(db, auditLog)))
+	adminRoutes.Handle("POST /oidc/clients", can(permissions.OIDCClientsManage, handlers.CreateOIDCClient(db, auditLog)))
+	adminRoutes.Handle("GET /oidc/clients", can(permissions.OIDCClientsManage, handlers.ListOIDCClients(db)))
+	adminRoutes.Handle("PATCH /oidc/clients/{id}", can(permissions.OIDCClientsManage, handlers.UpdateOIDCClient(db, auditLog)))
+	adminRoutes.Handle("POST /oidc/clients/{id}/secret", can(permissions.OIDCClientsManage, handlers.RotateOIDCClientSecret(db, auditLog)))
+	adminRoutes.Handle("DELETE /oidc/clients/{id}", can(permissions.OIDCClientsManage, handlers.DeleteOIDCClient(db, auditLog)))
+	adminRoutes.Handle("GET /audit", can(permissions.AuditRead, handlers.ListAuditLog(db)))
 
-	// Apply the full security chain (Auth + Admin) to the admin routes
-	protectedAdminRoutes := middleware.AuthMiddleware(middleware.AdminMiddleware(adminRoutes))
+	// Every admin route needs a valid token; the permission checks above do the rest
+	protectedAdminRoutes := middleware.AuthMiddleware(adminRoutes)
 	router.Handle("/admin/", http.StripPrefix("/admin", protectedAdminRoutes))
 
 	// this is for adding admin/troubleshooting via non-protected route.
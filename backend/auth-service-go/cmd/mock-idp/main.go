@@ -0,0 +1,286 @@
+// Command mock-idp is a minimal OpenID Connect provider for trying out federated login
+// locally. It asks no password: the login page lets you type in whatever claims the
+// ID token should carry. Never expose it outside a development machine.
+//
+//	go run ./cmd/mock-idp
+//
+// Then start the auth service with
+//
+//	FEDERATION_ISSUER=http://localhost:9090
+//	FEDERATION_CLIENT_ID=lms
+//	FEDERATION_CLIENT_SECRET=lms-secret
+package main
+
+import (
+	"auth/internal/util"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "mock-1"
+
+// grant is an issued authorization code and the claims it will be redeemed for.
+type grant struct {
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	claims        jwt.MapClaims
+	expiresAt     time.Time
+}
+
+type mockIdP struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]*grant
+}
+
+func main() {
+	idp, err := newMockIdP(
+		envOr("MOCK_IDP_ISSUER", "http://localhost:9090"),
+		envOr("MOCK_IDP_CLIENT_ID", "lms"),
+		envOr("MOCK_IDP_CLIENT_SECRET", "lms-secret"),
+	)
+	if err != nil {
+		log.Fatalf("Could not generate signing key: %v", err)
+	}
+
+	addr := envOr("MOCK_IDP_ADDR", ":9090")
+	log.Printf("Mock identity provider %s listening on %s (client %q)", idp.issuer, addr, idp.clientID)
+	if err := http.ListenAndServe(addr, idp.routes()); err != nil {
+		log.Fatalf("Could not start server: %s\n", err)
+	}
+}
+
+func newMockIdP(issuer, clientID, clientSecret string) (*mockIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &mockIdP{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		key:          key,
+		grants:       map[string]*grant{},
+	}, nil
+}
+
+func (idp *mockIdP) routes() http.Handler {
+	router := http.NewServeMux()
+	router.HandleFunc("GET /.well-known/openid-configuration", idp.discovery)
+	router.HandleFunc("GET /jwks", idp.jwks)
+	router.HandleFunc("GET /authorize", idp.loginPage)
+	router.HandleFunc("POST /authorize", idp.authorize)
+	router.HandleFunc("POST /token", idp.token)
+	return router
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	util.WriteJSON(w, http.StatusOK, util.H{
+		"issuer":                                idp.issuer,
+		"authorization_endpoint":                idp.issuer + "/authorize",
+		"token_endpoint":                        idp.issuer + "/token",
+		"jwks_uri":                              idp.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (idp *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := idp.key.PublicKey
+	util.WriteJSON(w, http.StatusOK, util.H{"keys": []util.H{{
+		"kid": keyID,
+		"kty": "RSA",
+		"alg": "RS256",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!doctype html>
+<title>Mock identity provider</title>
+<h1>Mock identity provider</h1>
+<p>Log in to <b>{{.ClientID}}</b> as:</p>
+<form method="post" action="/authorize?{{.Query}}">
+  <p><label>Subject <input name="sub" value="user-1" required></label></p>
+  <p><label>Email <input name="email" type="email" value="student@example.edu"></label>
+     <label><input name="email_verified" type="checkbox" value="true" checked> verified</label></p>
+  <p><label>Name <input name="name" value="Test Student"></label></p>
+  <p><label>Roll number <input name="roll_no"></label></p>
+  <p><label>Employee ID <input name="employee_id"></label></p>
+  <p><button name="action" value="login">Log in</button>
+     <button name="action" value="deny">Deny</button></p>
+</form>
+`))
+
+// loginPage shows a form for choosing the identity to log in as.
+func (idp *mockIdP) loginPage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != idp.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if query.Get("redirect_uri") == "" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "redirect_uri and an S256 code_challenge are required", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginTemplate.Execute(w, struct {
+		ClientID string
+		Query    template.URL
+	}{idp.clientID, template.URL(r.URL.RawQuery)})
+}
+
+// authorize issues a code for the submitted claims and sends the browser back to the client.
+func (idp *mockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != idp.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := redirect.Query()
+	params.Set("state", query.Get("state"))
+
+	if r.PostForm.Get("action") == "deny" {
+		params.Set("error", "access_denied")
+		params.Set("error_description", "The user denied the login")
+	} else {
+		claims := jwt.MapClaims{
+			"sub":            r.PostForm.Get("sub"),
+			"email_verified": r.PostForm.Get("email_verified") == "true",
+		}
+		for _, name := range []string{"email", "name", "roll_no", "employee_id"} {
+			if value := r.PostForm.Get(name); value != "" {
+				claims[name] = value
+			}
+		}
+		code := randomString()
+		idp.mu.Lock()
+		idp.grants[code] = &grant{
+			redirectURI:   query.Get("redirect_uri"),
+			codeChallenge: query.Get("code_challenge"),
+			nonce:         query.Get("nonce"),
+			claims:        claims,
+			expiresAt:     time.Now().Add(2 * time.Minute),
+		}
+		idp.mu.Unlock()
+		params.Set("code", code)
+	}
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token redeems a code for a signed ID token.
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != idp.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(idp.clientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	idp.mu.Lock()
+	g := idp.grants[code]
+	delete(idp.grants, code)
+	idp.mu.Unlock()
+	if g == nil || time.Now().After(g.expiresAt) || g.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": idp.issuer,
+		"aud": idp.clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	for name, value := range g.claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		log.Printf("ERROR: Could not sign ID token: %v", err)
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	util.WriteJSON(w, http.StatusOK, util.H{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	util.WriteJSON(w, status, util.H{"error": code})
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Could not read random bytes: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"auth/internal/federation"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// startMockIdP serves a mock identity provider and returns a federation provider
+// configured as its client.
+func startMockIdP(t *testing.T) *federation.Provider {
+	t.Helper()
+	idp, err := newMockIdP("", "lms", "lms-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(idp.routes())
+	t.Cleanup(server.Close)
+	idp.issuer = server.URL
+
+	return federation.NewProvider(&federation.Config{
+		Issuer:       server.URL,
+		ClientID:     "lms",
+		ClientSecret: "lms-secret",
+		RedirectURI:  "http://localhost:3000/login/federated/callback",
+	})
+}
+
+// logIn walks the browser side of a login at the mock provider: it loads the login page,
+// submits the form and returns the query the provider redirects back with.
+func logIn(t *testing.T, authURL string, form url.Values) url.Values {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	page, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Body.Close()
+	if page.StatusCode != http.StatusOK {
+		t.Fatalf("login page returned %s", page.Status)
+	}
+
+	resp, err := client.PostForm(authURL, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login returned %s, want a redirect", resp.Status)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), "http://localhost:3000/login/federated/callback?") {
+		t.Fatalf("redirected to %s, want the configured redirect URI", location)
+	}
+	return location.Query()
+}
+
+func TestFederatedLoginRoundTrip(t *testing.T) {
+	provider := startMockIdP(t)
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1", strings.Repeat("v", 43))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback := logIn(t, authURL, url.Values{
+		"action":         {"login"},
+		"sub":            {"user-1"},
+		"email":          {"student@example.edu"},
+		"email_verified": {"true"},
+		"roll_no":        {"2024001"},
+	})
+	if got := callback.Get("state"); got != "state-1" {
+		t.Fatalf("state = %q, want state-1", got)
+	}
+
+	claims, err := provider.Exchange(callback.Get("code"), strings.Repeat("v", 43), "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.String("sub") != "user-1" || claims.String("email") != "student@example.edu" || !claims.EmailVerified() {
+		t.Errorf("unexpected claims %v", claims)
+	}
+	if claims.String("roll_no") != "2024001" {
+		t.Errorf("roll_no = %q, want 2024001", claims.String("roll_no"))
+	}
+
+	// Codes are single-use
+	if _, err := provider.Exchange(callback.Get("code"), strings.Repeat("v", 43), "nonce-1"); err == nil {
+		t.Error("a redeemed code was accepted again")
+	}
+}
+
+func TestFederatedLoginRejectsMismatches(t *testing.T) {
+	provider := startMockIdP(t)
+	verifier := strings.Repeat("v", 43)
+	form := url.Values{"action": {"login"}, "sub": {"user-1"}}
+
+	for name, exchange := range map[string]func(code string) error{
+		"wrong verifier": func(code string) error {
+			_, err := provider.Exchange(code, strings.Repeat("w", 43), "nonce-1")
+			return err
+		},
+		"wrong nonce": func(code string) error {
+			_, err := provider.Exchange(code, verifier, "nonce-2")
+			return err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			authURL, err := provider.AuthCodeURL("state-1", "nonce-1", verifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			callback := logIn(t, authURL, form)
+			if err := exchange(callback.Get("code")); err == nil {
+				t.Error("Exchange succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestFederatedLoginDenied(t *testing.T) {
+	provider := startMockIdP(t)
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1", strings.Repeat("v", 43))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback := logIn(t, authURL, url.Values{"action": {"deny"}, "sub": {"user-1"}})
+	if callback.Get("error") != "access_denied" || callback.Get("code") != "" {
+		t.Errorf("callback = %v, want access_denied and no code", callback)
+	}
+	if callback.Get("state") != "state-1" {
+		t.Errorf("state = %q, want state-1", callback.Get("state"))
+	}
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"auth/internal/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidAccountStatus = errors.New("status must be active, suspended or deactivated")
+
+// SetUserStatus changes a user's account status and records why. Moving a user out of
+// the active status revokes all of their sessions in the same transaction, so existing
+// access and refresh tokens stop working immediately.
+func SetUserStatus(db *gorm.DB, userID uuid.UUID, status, reason string) (*models.User, error) {
+	switch status {
+	case models.UserActive, models.UserSuspended, models.UserDeactivated:
+	default:
+		return nil, ErrInvalidAccountStatus
+	}
+
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{
+			"status":            status,
+			"status_reason":     reason,
+			"status_changed_at": now,
+		}
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update account status: %w", err)
+		}
+		if status != models.UserActive {
+			return RevokeUserSessions(tx, userID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// IsUserActive reports whether the user exists and may log in.
+func IsUserActive(db *gorm.DB, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&models.User{}).Where("id = ? AND status = ?", userID, models.UserActive).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to look up account status: %w", err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"auth/internal/password"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidFederatedState is returned when a callback's state is unknown, expired or already used.
+	ErrInvalidFederatedState = errors.New("invalid or expired login state")
+	// ErrIdentityLinkedElsewhere is returned when the matched user is already linked to a
+	// different account at the same provider.
+	ErrIdentityLinkedElsewhere = errors.New("this user is already linked to a different account at the identity provider")
+	ErrIdentityNotFound        = errors.New("linked identity not found")
+)
+
+// FederatedLoginTTL bounds how long a user has to finish logging in at the provider.
+const FederatedLoginTTL = 10 * time.Minute
+
+// StartFederatedLogin records a new upstream login and returns its state, nonce and PKCE
+// verifier. Only the state's hash is stored, since the state travels through the browser.
+func StartFederatedLogin(db *gorm.DB) (state, nonce, codeVerifier string, err error) {
+	state, stateHash, err := oauth.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	if nonce, _, err = oauth.GenerateOpaqueToken(); err != nil {
+		return "", "", "", err
+	}
+	if codeVerifier, _, err = oauth.GenerateOpaqueToken(); err != nil {
+		return "", "", "", err
+	}
+
+	login := models.FederatedLoginState{
+		StateHash:    stateHash,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(FederatedLoginTTL),
+	}
+	if err := db.Create(&login).Error; err != nil {
+		return "", "", "", fmt.Errorf("failed to record federated login: %w", err)
+	}
+	// Expired attempts are of no use to anyone; clear them out as we go.
+	db.Where("expires_at < ?", time.Now()).Delete(&models.FederatedLoginState{})
+	return state, nonce, codeVerifier, nil
+}
+
+// ConsumeFederatedLogin looks up and deletes the login a callback's state belongs to.
+func ConsumeFederatedLogin(db *gorm.DB, state string) (*models.FederatedLoginState, error) {
+	var login models.FederatedLoginState
+	result := db.Clauses(clause.Returning{}).
+		Where("state_hash = ?", oauth.HashOpaqueToken(state)).
+		Delete(&login)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to consume federated login: %w", result.Error)
+	}
+	if result.RowsAffected == 0 || time.Now().After(login.ExpiresAt) {
+		return nil, ErrInvalidFederatedState
+	}
+	return &login, nil
+}
+
+// FindFederatedUser returns the user linked to a provider subject, or gorm.ErrRecordNotFound.
+func FindFederatedUser(db *gorm.DB, issuer, subject string) (*models.User, error) {
+	var identity models.FederatedIdentity
+	if err := db.First(&identity, "issuer = ? AND subject = ?", issuer, subject).Error; err != nil {
+		return nil, err
+	}
+	var user models.User
+	if err := db.First(&user, "id = ?", identity.UserID).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	db.Model(&identity).Update("last_login_at", now)
+	return &user, nil
+}
+
+// MatchUser finds the user an unlinked login belongs to by email, roll number or
+// employee ID, or returns gorm.ErrRecordNotFound.
+func MatchUser(db *gorm.DB, field, value string) (*models.User, error) {
+	if value == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var user models.User
+	var err error
+	switch field {
+	case "email":
+		err = db.Where("LOWER(email) = LOWER(?)", value).First(&user).Error
+	case "roll_no":
+		err = db.Where("id = (?)", db.Model(&models.StudentProfile{}).Select("user_id").Where("roll_no = ?", value)).
+			First(&user).Error
+	case "employee_id":
+		err = db.Where("id IN (?) OR id IN (?)",
+			db.Model(&models.InstructorProfile{}).Select("user_id").Where("employee_id = ?", value),
+			db.Model(&models.AdminProfile{}).Select("user_id").Where("employee_id = ?", value)).
+			First(&user).Error
+	default:
+		return nil, fmt.Errorf("unsupported federation match field %q", field)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// LinkFederatedIdentity links a user to a provider subject. A user can be linked to at
+// most one subject per provider.
+func LinkFederatedIdentity(db *gorm.DB, userID uuid.UUID, issuer, subject, email string) error {
+	var existing models.FederatedIdentity
+	err := db.First(&existing, "user_id = ? AND issuer = ?", userID, issuer).Error
+	if err == nil {
+		if existing.Subject == subject {
+			return nil
+		}
+		return ErrIdentityLinkedElsewhere
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to look up linked identities: %w", err)
+	}
+
+	now := time.Now()
+	identity := models.FederatedIdentity{UserID: userID, Issuer: issuer, Subject: subject, Email: email, LastLoginAt: &now}
+	if err := db.Create(&identity).Error; err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	return nil
+}
+
+// ProvisionFederatedUser creates a user just in time for their first federated login and
+// links them. They get a random password nobody knows, so they can only log in through
+// the provider unless they later reset it.
+func ProvisionFederatedUser(db *gorm.DB, email, role string, profile interface{}, issuer, subject string) (*models.User, error) {
+	if err := ValidateUserProfile(role, profile); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+	secret, _, err := oauth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := password.Hash(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.User{Email: email, PasswordHash: hash, Role: role}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := CreateUserWithProfile(tx, &user, profile); err != nil {
+			return err
+		}
+		return LinkFederatedIdentity(tx, user.ID, issuer, subject, email)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListFederatedIdentities returns the provider accounts linked to a user.
+func ListFederatedIdentities(db *gorm.DB, userID uuid.UUID) ([]models.FederatedIdentity, error) {
+	var identities []models.FederatedIdentity
+	if err := db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+		return nil, fmt.Errorf("failed to list linked identities: %w", err)
+	}
+	return identities, nil
+}
+
+// UnlinkFederatedIdentity removes a link, e.g. when the provider account was matched to
+// the wrong user. The next federated login is matched afresh.
+func UnlinkFederatedIdentity(db *gorm.DB, userID, identityID uuid.UUID) (*models.FederatedIdentity, error) {
+	var identity models.FederatedIdentity
+	result := db.Clauses(clause.Returning{}).Where("id = ? AND user_id = ?", identityID, userID).Delete(&identity)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to unlink identity: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrIdentityNotFound
+	}
+	return &identity, nil
+}
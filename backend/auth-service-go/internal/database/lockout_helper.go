@@ -0,0 +1,111 @@
+package database
+
+import (
+	"auth/internal/models"
+	"auth/internal/util"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LockoutPolicy controls progressive account lockout. After Threshold consecutive
+// failures the account is locked for BaseDuration, and each further failure doubles
+// the lock, up to MaxDuration.
+type LockoutPolicy struct {
+	Threshold    int
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+}
+
+// LockoutPolicyFromEnv reads LOGIN_LOCKOUT_THRESHOLD, LOGIN_LOCKOUT_BASE and LOGIN_LOCKOUT_MAX.
+func LockoutPolicyFromEnv() LockoutPolicy {
+	return LockoutPolicy{
+		Threshold:    util.EnvInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+		BaseDuration: util.EnvDuration("LOGIN_LOCKOUT_BASE", time.Minute),
+		MaxDuration:  util.EnvDuration("LOGIN_LOCKOUT_MAX", time.Hour),
+	}
+}
+
+// lockDuration returns how long to lock an account after the given number of consecutive failures.
+func (p LockoutPolicy) lockDuration(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	d := p.BaseDuration
+	for i := p.Threshold; i < failures && d < p.MaxDuration; i++ {
+		d *= 2
+	}
+	if d > p.MaxDuration {
+		d = p.MaxDuration
+	}
+	return d
+}
+
+// RecordLoginFailure counts a wrong password against the user and locks the account
+// when the policy says so. It returns the lock expiry, or nil if the account is not locked.
+func RecordLoginFailure(db *gorm.DB, userID uuid.UUID, policy LockoutPolicy) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "failed_login_count").First(&user, "id = ?", userID).Error; err != nil {
+			return fmt.Errorf("failed to look up user for lockout: %w", err)
+		}
+
+		failures := user.FailedLoginCount + 1
+		updates := map[string]interface{}{"failed_login_count": failures}
+		if d := policy.lockDuration(failures); d > 0 {
+			until := time.Now().Add(d)
+			lockedUntil = &until
+			updates["locked_until"] = until
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).Updates(updates).Error
+	})
+	return lockedUntil, err
+}
+
+// ResetLoginFailures clears the failure counter after a successful password check.
+func ResetLoginFailures(db *gorm.DB, userID uuid.UUID) error {
+	err := db.Model(&models.User{}).Where("id = ? AND failed_login_count > 0", userID).
+		Updates(map[string]interface{}{"failed_login_count": 0, "locked_until": nil}).Error
+	if err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+	return nil
+}
+
+// UnlockUser lifts a lockout immediately, for use by admins.
+func UnlockUser(db *gorm.DB, userID uuid.UUID) error {
+	result := db.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"failed_login_count": 0, "locked_until": nil})
+	if result.Error != nil {
+		return fmt.Errorf("failed to unlock user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RecordLoginAttempt stores a login attempt. Failures to record are logged by the caller
+// but never block the login itself.
+func RecordLoginAttempt(db *gorm.DB, attempt *models.LoginAttempt) error {
+	if err := db.Create(attempt).Error; err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+	return nil
+}
+
+// ListLoginAttempts returns up to limit of a user's login attempts made before the given
+// time, newest first.
+func ListLoginAttempts(db *gorm.DB, userID uuid.UUID, before time.Time, limit int) ([]models.LoginAttempt, error) {
+	var attempts []models.LoginAttempt
+	err := db.Where("user_id = ? AND created_at < ?", userID, before).
+		Order("created_at DESC").Limit(limit).Find(&attempts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list login attempts: %w", err)
+	}
+	return attempts, nil
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"auth/internal/mfa"
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrMFAAlreadyEnabled is returned when starting enrollment for a user who already has a confirmed authenticator.
+	ErrMFAAlreadyEnabled = errors.New("MFA is already enabled")
+	// ErrMFANotEnrolled is returned when there is no authenticator to confirm or check.
+	ErrMFANotEnrolled = errors.New("MFA enrollment has not been started")
+	// ErrInvalidMFACode is returned when a TOTP or recovery code does not match.
+	ErrInvalidMFACode = errors.New("invalid MFA code")
+)
+
+// IsMFAEnabled reports whether the user has a confirmed TOTP authenticator.
+func IsMFAEnabled(db *gorm.DB, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&models.TOTPCredential{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL", userID).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check MFA status: %w", err)
+	}
+	return count > 0, nil
+}
+
+// BeginTOTPEnrollment creates (or replaces) an unconfirmed TOTP secret for the user.
+func BeginTOTPEnrollment(db *gorm.DB, userID uuid.UUID) (string, error) {
+	var secret string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing models.TOTPCredential
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existing, "user_id = ?", userID).Error
+		if err == nil && existing.ConfirmedAt != nil {
+			return ErrMFAAlreadyEnabled
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to look up TOTP credential: %w", err)
+		}
+
+		secret, err = mfa.GenerateSecret()
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TOTPCredential{}).Error; err != nil {
+			return fmt.Errorf("failed to clear pending TOTP credential: %w", err)
+		}
+		credential := models.TOTPCredential{UserID: userID, Secret: secret}
+		if err := tx.Create(&credential).Error; err != nil {
+			return fmt.Errorf("failed to store TOTP credential: %w", err)
+		}
+		return nil
+	})
+	return secret, err
+}
+
+// ConfirmTOTPEnrollment enables MFA once the user proves their authenticator works,
+// and returns a fresh set of recovery codes.
+func ConfirmTOTPEnrollment(db *gorm.DB, userID uuid.UUID, code string) ([]string, error) {
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var credential models.TOTPCredential
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&credential, "user_id = ?", userID).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrMFANotEnrolled
+			}
+			return fmt.Errorf("failed to look up TOTP credential: %w", err)
+		}
+		if credential.ConfirmedAt != nil {
+			return ErrMFAAlreadyEnabled
+		}
+
+		step, ok := mfa.Validate(credential.Secret, code, time.Now(), credential.LastUsedStep)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		now := time.Now()
+		if err := tx.Model(&credential).Updates(map[string]interface{}{"confirmed_at": now, "last_used_step": step}).Error; err != nil {
+			return fmt.Errorf("failed to confirm TOTP credential: %w", err)
+		}
+
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// DisableMFA removes the user's authenticator and recovery codes.
+func DisableMFA(db *gorm.DB, userID uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TOTPCredential{}).Error; err != nil {
+			return fmt.Errorf("failed to delete TOTP credential: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		return nil
+	})
+}
+
+// RegenerateRecoveryCodes invalidates all existing recovery codes and returns new ones.
+func RegenerateRecoveryCodes(db *gorm.DB, userID uuid.UUID) ([]string, error) {
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// VerifySecondFactor checks either a TOTP code or a recovery code for the user.
+// A recovery code is consumed on success; a TOTP time step can never be reused.
+func VerifySecondFactor(db *gorm.DB, userID uuid.UUID, code, recoveryCode string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if recoveryCode != "" {
+			hash := oauth.HashOpaqueToken(mfa.NormalizeRecoveryCode(recoveryCode))
+			result := tx.Model(&models.RecoveryCode{}).
+				Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+				Update("used_at", time.Now())
+			if result.Error != nil {
+				return fmt.Errorf("failed to use recovery code: %w", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return ErrInvalidMFACode
+			}
+			return nil
+		}
+
+		var credential models.TOTPCredential
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND confirmed_at IS NOT NULL", userID).
+			First(&credential).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrMFANotEnrolled
+			}
+			return fmt.Errorf("failed to look up TOTP credential: %w", err)
+		}
+		step, ok := mfa.Validate(credential.Secret, code, time.Now(), credential.LastUsedStep)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		if err := tx.Model(&credential).Update("last_used_step", step).Error; err != nil {
+			return fmt.Errorf("failed to record TOTP use: %w", err)
+		}
+		return nil
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	codes, err := mfa.GenerateRecoveryCodes(mfa.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]models.RecoveryCode, len(codes))
+	for i, code := range codes {
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: oauth.HashOpaqueToken(code)}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to store recovery codes: %w", err)
+	}
+	return codes, nil
+}
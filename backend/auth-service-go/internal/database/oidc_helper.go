@@ -0,0 +1,292 @@
+package database
+
+import (
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidClientName  = errors.New("client name is required")
+	ErrInvalidRedirectURI = errors.New("redirect URIs must be absolute https URLs without a fragment (http is only allowed for localhost)")
+	// ErrInvalidAuthorizationCode covers unknown, expired and already-used codes, and codes
+	// presented by the wrong client, with the wrong redirect URI or the wrong PKCE verifier.
+	ErrInvalidAuthorizationCode = errors.New("invalid authorization code")
+)
+
+// validateRedirectURIs checks every URI is one a client may register and returns them de-duplicated.
+func validateRedirectURIs(uris []string) ([]string, error) {
+	valid := make([]string, 0, len(uris))
+	for _, raw := range uris {
+		raw = strings.TrimSpace(raw)
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" || u.Fragment != "" || strings.ContainsAny(raw, " \t\n") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRedirectURI, raw)
+		}
+		local := u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1"
+		if u.Scheme != "https" && !(u.Scheme == "http" && local) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRedirectURI, raw)
+		}
+		if !slices.Contains(valid, raw) {
+			valid = append(valid, raw)
+		}
+	}
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("%w: at least one is required", ErrInvalidRedirectURI)
+	}
+	return valid, nil
+}
+
+// CreateOIDCClient registers an application for single sign-on. Confidential clients get
+// a secret, returned here once and never stored in clear; public clients get "".
+func CreateOIDCClient(db *gorm.DB, name string, redirectURIs []string, firstParty, confidential bool, createdBy uuid.UUID) (*models.OIDCClient, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, "", ErrInvalidClientName
+	}
+	uris, err := validateRedirectURIs(redirectURIs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client := models.OIDCClient{
+		ClientID:     uuid.NewString(),
+		Name:         strings.TrimSpace(name),
+		RedirectURIs: strings.Join(uris, " "),
+		FirstParty:   firstParty,
+		CreatedBy:    createdBy,
+	}
+	var secret string
+	if confidential {
+		if secret, client.SecretHash, err = oauth.GenerateOpaqueToken(); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := db.Create(&client).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create OIDC client: %w", err)
+	}
+	return &client, secret, nil
+}
+
+// UpdateOIDCClient changes a client's name, redirect URIs, first-party or disabled flag;
+// nil arguments are left alone.
+func UpdateOIDCClient(db *gorm.DB, id uuid.UUID, name *string, redirectURIs []string, firstParty, disabled *bool) (*models.OIDCClient, error) {
+	var client models.OIDCClient
+	if err := db.First(&client, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if name != nil {
+		if strings.TrimSpace(*name) == "" {
+			return nil, ErrInvalidClientName
+		}
+		updates["name"] = strings.TrimSpace(*name)
+	}
+	if redirectURIs != nil {
+		uris, err := validateRedirectURIs(redirectURIs)
+		if err != nil {
+			return nil, err
+		}
+		updates["redirect_uris"] = strings.Join(uris, " ")
+	}
+	if firstParty != nil {
+		updates["first_party"] = *firstParty
+	}
+	if disabled != nil {
+		updates["disabled"] = *disabled
+	}
+	if len(updates) > 0 {
+		if err := db.Model(&client).Updates(updates).Error; err != nil {
+			return nil, fmt.Errorf("failed to update OIDC client: %w", err)
+		}
+	}
+	return &client, nil
+}
+
+// RotateOIDCClientSecret replaces a client's secret, which also makes a public client confidential.
+func RotateOIDCClientSecret(db *gorm.DB, id uuid.UUID) (*models.OIDCClient, string, error) {
+	var client models.OIDCClient
+	if err := db.First(&client, "id = ?", id).Error; err != nil {
+		return nil, "", err
+	}
+	secret, hash, err := oauth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := db.Model(&client).Update("secret_hash", hash).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to rotate OIDC client secret: %w", err)
+	}
+	return &client, secret, nil
+}
+
+// DeleteOIDCClient removes a client along with its pending codes and users' consents.
+// Tokens it already holds stay valid until they expire or the user logs out.
+func DeleteOIDCClient(db *gorm.DB, id uuid.UUID) (*models.OIDCClient, error) {
+	var client models.OIDCClient
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&client, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", client.ClientID).Delete(&models.AuthorizationCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete authorization codes: %w", err)
+		}
+		if err := tx.Where("client_id = ?", client.ClientID).Delete(&models.OIDCConsent{}).Error; err != nil {
+			return fmt.Errorf("failed to delete consents: %w", err)
+		}
+		if err := tx.Delete(&client).Error; err != nil {
+			return fmt.Errorf("failed to delete OIDC client: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// ListOIDCClients returns every registered client.
+func ListOIDCClients(db *gorm.DB) ([]models.OIDCClient, error) {
+	var clients []models.OIDCClient
+	if err := db.Order("name").Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to list OIDC clients: %w", err)
+	}
+	return clients, nil
+}
+
+// FindOIDCClient returns an enabled client by its client_id.
+func FindOIDCClient(db *gorm.DB, clientID string) (*models.OIDCClient, error) {
+	var client models.OIDCClient
+	if err := db.First(&client, "client_id = ?", clientID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidClientCredentials
+		}
+		return nil, fmt.Errorf("failed to look up OIDC client: %w", err)
+	}
+	if client.Disabled {
+		return nil, ErrInvalidClientCredentials
+	}
+	return &client, nil
+}
+
+// AuthenticateOIDCClient checks a client at the token endpoint. Confidential clients must
+// present their secret; public clients must not present one.
+func AuthenticateOIDCClient(db *gorm.DB, clientID, secret string) (*models.OIDCClient, error) {
+	client, err := FindOIDCClient(db, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Confidential() {
+		if secret != "" {
+			return nil, ErrInvalidClientCredentials
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(oauth.HashOpaqueToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, ErrInvalidClientCredentials
+	}
+	return client, nil
+}
+
+// HasConsent reports whether a user has already allowed a client every one of the scopes.
+func HasConsent(db *gorm.DB, userID uuid.UUID, clientID string, scopes []string) (bool, error) {
+	var consent models.OIDCConsent
+	err := db.First(&consent, "user_id = ? AND client_id = ?", userID, clientID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up consent: %w", err)
+	}
+	granted := strings.Fields(consent.Scope)
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// SaveConsent records that a user allowed a client the given scopes, on top of any
+// they allowed before.
+func SaveConsent(db *gorm.DB, userID uuid.UUID, clientID string, scopes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var consent models.OIDCConsent
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&consent, "user_id = ? AND client_id = ?", userID, clientID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to look up consent: %w", err)
+		}
+		granted := strings.Fields(consent.Scope)
+		for _, scope := range scopes {
+			if !slices.Contains(granted, scope) {
+				granted = append(granted, scope)
+			}
+		}
+		consent.UserID, consent.ClientID, consent.Scope = userID, clientID, strings.Join(granted, " ")
+		if err := tx.Save(&consent).Error; err != nil {
+			return fmt.Errorf("failed to save consent: %w", err)
+		}
+		return nil
+	})
+}
+
+// CreateAuthorizationCode stores a new code and returns it. Only its hash is kept.
+func CreateAuthorizationCode(db *gorm.DB, code *models.AuthorizationCode) (string, error) {
+	raw, hash, err := oauth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	code.CodeHash = hash
+	code.ExpiresAt = time.Now().Add(oauth.AuthorizationCodeTTL)
+	if err := db.Create(code).Error; err != nil {
+		return "", fmt.Errorf("failed to create authorization code: %w", err)
+	}
+	return raw, nil
+}
+
+// RedeemAuthorizationCode consumes a code for the client that it was issued to. The
+// redirect URI must match the one used at the authorization endpoint and the PKCE
+// verifier must match its challenge. The user and their session must still be active.
+func RedeemAuthorizationCode(db *gorm.DB, rawCode, clientID, redirectURI, verifier string) (*models.AuthorizationCode, error) {
+	var code models.AuthorizationCode
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&code, "code_hash = ?", oauth.HashOpaqueToken(rawCode)).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidAuthorizationCode
+			}
+			return fmt.Errorf("failed to look up authorization code: %w", err)
+		}
+		if code.UsedAt != nil || time.Now().After(code.ExpiresAt) ||
+			code.ClientID != clientID || code.RedirectURI != redirectURI ||
+			!oauth.VerifyPKCE(verifier, code.CodeChallenge) {
+			return ErrInvalidAuthorizationCode
+		}
+
+		if active, err := IsSessionActive(tx, code.SessionID.String()); err != nil {
+			return err
+		} else if !active {
+			return ErrInvalidAuthorizationCode
+		}
+
+		if err := tx.Model(&code).Update("used_at", time.Now()).Error; err != nil {
+			return fmt.Errorf("failed to consume authorization code: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"auth/internal/password"
+	"auth/internal/util"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultPasswordResetTTL is used when PASSWORD_RESET_TTL is unset or invalid.
+const defaultPasswordResetTTL = time.Hour
+
+// ErrInvalidResetToken is returned when a reset token is unknown, expired or already used.
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// CreatePasswordResetToken stores a new single-use reset token for a user and returns the raw token.
+// Any earlier unused tokens for the user are invalidated so only the latest email works.
+func CreatePasswordResetToken(tx *gorm.DB, userID uuid.UUID) (string, error) {
+	now := time.Now()
+	if err := tx.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", now).Error; err != nil {
+		return "", fmt.Errorf("failed to invalidate old reset tokens: %w", err)
+	}
+
+	rawToken, hash, err := oauth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	resetToken := models.PasswordResetToken{
+		UserID:    userID,
+		TokenHash: hash,
+		ExpiresAt: now.Add(util.EnvDuration("PASSWORD_RESET_TTL", defaultPasswordResetTTL)),
+	}
+	if err := tx.Create(&resetToken).Error; err != nil {
+		return "", fmt.Errorf("failed to store reset token: %w", err)
+	}
+	return rawToken, nil
+}
+
+// ConsumePasswordResetToken marks a reset token used and returns the user it belongs to.
+// It must run inside the same transaction that changes the password.
+func ConsumePasswordResetToken(tx *gorm.DB, rawToken string) (uuid.UUID, error) {
+	var resetToken models.PasswordResetToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", oauth.HashOpaqueToken(rawToken)).
+		First(&resetToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return uuid.Nil, ErrInvalidResetToken
+		}
+		return uuid.Nil, fmt.Errorf("failed to look up reset token: %w", err)
+	}
+	if resetToken.UsedAt != nil || time.Now().After(resetToken.ExpiresAt) {
+		return uuid.Nil, ErrInvalidResetToken
+	}
+
+	if err := tx.Model(&resetToken).Update("used_at", time.Now()).Error; err != nil {
+		return uuid.Nil, fmt.Errorf("failed to consume reset token: %w", err)
+	}
+	return resetToken.UserID, nil
+}
+
+// UpdatePasswordHash replaces a user's stored password hash for a new password. The
+// old hash moves into their password history, which is trimmed to the historySize-1
+// most recent entries (the current password makes up the rest).
+func UpdatePasswordHash(tx *gorm.DB, userID uuid.UUID, passwordHash string, historySize int) error {
+	var user models.User
+	if err := tx.Select("id", "password_hash").First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+	if user.PasswordHash != "" && historySize > 1 {
+		entry := models.PasswordHistory{UserID: userID, PasswordHash: user.PasswordHash}
+		if err := tx.Create(&entry).Error; err != nil {
+			return fmt.Errorf("failed to record password history: %w", err)
+		}
+	}
+	err := tx.Where("user_id = ? AND id NOT IN (?)", userID,
+		tx.Model(&models.PasswordHistory{}).Select("id").Where("user_id = ?", userID).
+			Order("created_at DESC").Limit(max(historySize-1, 0))).
+		Delete(&models.PasswordHistory{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to trim password history: %w", err)
+	}
+
+	if err := tx.Model(&user).Update("password_hash", passwordHash).Error; err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	return nil
+}
+
+// RehashPassword swaps a user's hash for a stronger hash of the same password, unless
+// the password was changed in the meantime. The history is left alone.
+func RehashPassword(db *gorm.DB, userID uuid.UUID, oldHash, newHash string) error {
+	err := db.Model(&models.User{}).Where("id = ? AND password_hash = ?", userID, oldHash).
+		Update("password_hash", newHash).Error
+	if err != nil {
+		return fmt.Errorf("failed to rehash password: %w", err)
+	}
+	return nil
+}
+
+// CheckPasswordReuse returns password.ErrPasswordReused if candidate is the user's
+// current password or one of their historySize-1 previous ones.
+func CheckPasswordReuse(db *gorm.DB, userID uuid.UUID, candidate string, historySize int) error {
+	var user models.User
+	if err := db.Select("password_hash").First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+	hashes := []string{user.PasswordHash}
+	if historySize > 1 {
+		var previous []string
+		err := db.Model(&models.PasswordHistory{}).Where("user_id = ?", userID).
+			Order("created_at DESC").Limit(historySize-1).Pluck("password_hash", &previous).Error
+		if err != nil {
+			return fmt.Errorf("failed to load password history: %w", err)
+		}
+		hashes = append(hashes, previous...)
+	}
+
+	for _, hash := range hashes {
+		// Hashes in a format no longer understood cannot match, so they are skipped
+		if ok, _, _ := password.Verify(hash, candidate); ok {
+			return password.ErrPasswordReused
+		}
+	}
+	return nil
+}
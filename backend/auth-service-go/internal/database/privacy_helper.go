@@ -0,0 +1,137 @@
+package database
+
+import (
+	"auth/internal/models"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AnonymizedReason is the status reason of an anonymized account.
+const AnonymizedReason = "anonymized"
+
+// AnonymizedName replaces the full name on an anonymized profile.
+const AnonymizedName = "Anonymized user"
+
+// UserDataExport is everything the auth service holds about a user.
+type UserDataExport struct {
+	User                *models.User               `json:"user"`
+	TAAssignments       []models.TAAssignment      `json:"taAssignments"`
+	RoleChanges         []models.RoleChange        `json:"roleChanges"`
+	FederatedIdentities []models.FederatedIdentity `json:"federatedIdentities"`
+	KnownDevices        []models.KnownDevice       `json:"knownDevices"`
+	Sessions            []models.Session           `json:"sessions"`
+	LoginHistory        []models.LoginAttempt      `json:"loginHistory"`
+	OIDCConsents        []models.OIDCConsent       `json:"oidcConsents"`
+}
+
+// ExportUserData gathers a user's data for a data-subject access request.
+func ExportUserData(db *gorm.DB, userID uuid.UUID) (*UserDataExport, error) {
+	export := UserDataExport{User: &models.User{}}
+	if err := preloadProfiles(db).First(export.User, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+
+	lists := []struct {
+		name string
+		dest interface{}
+	}{
+		{"TA assignments", &export.TAAssignments},
+		{"role changes", &export.RoleChanges},
+		{"linked identities", &export.FederatedIdentities},
+		{"known devices", &export.KnownDevices},
+		{"sessions", &export.Sessions},
+		{"login history", &export.LoginHistory},
+		{"OIDC consents", &export.OIDCConsents},
+	}
+	for _, list := range lists {
+		if err := db.Where("user_id = ?", userID).Find(list.dest).Error; err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", list.name, err)
+		}
+	}
+	return &export, nil
+}
+
+// AnonymizeUser removes a user's personal data for an erasure request while keeping the
+// account row, its ID and the attributes reports aggregate on (role, branch, year of
+// admission, department, title), so registrations, grades and standings held by other
+// services stay statistically intact.
+//
+// Names, contact details, identifiers such as roll numbers, linked identities, devices,
+// MFA secrets and client details in session and login history are scrubbed, and the
+// account is deactivated. Audit log entries are immutable and are not touched; they
+// record personal data only as digests.
+// Anonymizing an already anonymized user is harmless.
+func AnonymizeUser(db *gorm.DB, userID uuid.UUID) (*models.User, error) {
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+
+		// Stand-in identifiers stay unique because they contain the user ID
+		placeholder := "anon-" + userID.String()
+		now := time.Now()
+		err := tx.Model(&user).Updates(map[string]interface{}{
+			"email":              placeholder + "@anonymized.invalid",
+			"password_hash":      "",
+			"external_id":        nil,
+			"status":             models.UserDeactivated,
+			"status_reason":      AnonymizedReason,
+			"status_changed_at":  now,
+			"failed_login_count": 0,
+			"locked_until":       nil,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to anonymize user: %w", err)
+		}
+
+		profiles := []struct {
+			model   interface{}
+			updates map[string]interface{}
+		}{
+			{&models.StudentProfile{}, map[string]interface{}{
+				"full_name": AnonymizedName, "roll_no": placeholder, "date_of_birth": nil,
+				"address": "", "contact_number": "", "father_name": "", "mother_name": "",
+			}},
+			{&models.InstructorProfile{}, map[string]interface{}{"full_name": AnonymizedName, "employee_id": placeholder}},
+			{&models.AdminProfile{}, map[string]interface{}{"full_name": AnonymizedName, "employee_id": placeholder}},
+		}
+		for _, profile := range profiles {
+			if err := tx.Model(profile.model).Where("user_id = ?", userID).Updates(profile.updates).Error; err != nil {
+				return fmt.Errorf("failed to anonymize profile: %w", err)
+			}
+		}
+
+		for _, model := range []interface{}{
+			&models.FederatedIdentity{}, &models.KnownDevice{}, &models.TOTPCredential{},
+			&models.RecoveryCode{}, &models.PasswordResetToken{}, &models.OIDCConsent{},
+			&models.AuthorizationCode{},
+		} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return fmt.Errorf("failed to delete personal data: %w", err)
+			}
+		}
+
+		err = tx.Model(&models.LoginAttempt{}).Where("user_id = ?", userID).
+			Updates(map[string]interface{}{"email": "", "ip_address": "", "user_agent": ""}).Error
+		if err != nil {
+			return fmt.Errorf("failed to scrub login history: %w", err)
+		}
+		err = tx.Model(&models.Session{}).Where("user_id = ?", userID).
+			Updates(map[string]interface{}{"ip_address": "", "user_agent": "", "device_hash": ""}).Error
+		if err != nil {
+			return fmt.Errorf("failed to scrub sessions: %w", err)
+		}
+		return RevokeUserSessions(tx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := preloadProfiles(db).First(&user, "id = ?", userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to reload anonymized user: %w", err)
+	}
+	return &user, nil
+}
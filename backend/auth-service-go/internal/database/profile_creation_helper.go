@@ -4,16 +4,21 @@ import (
 	"auth/internal/models"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
-// CreateUserProfile is a helper function to create the correct profile based on role.
-// It now accepts the specific profile struct as an interface{}.
-func CreateUserProfile(tx *gorm.DB, userID uuid.UUID, role string, profileData interface{}) error {
-	switch role {
-	case "student", "ta":
+// ValidateUserProfile checks that the profile data matches the role and has its required
+// fields, without touching the database. CreateUserProfile runs the same checks; whether
+// the role itself exists is checked by RoleExists.
+func ValidateUserProfile(role string, profileData interface{}) error {
+	if role == "" {
+		return errors.New("role is required")
+	}
+	switch models.ProfileKind(role) {
+	case "student":
 		profile, ok := profileData.(*models.StudentProfile)
 		if !ok || profile == nil {
 			return errors.New("student profile data is required")
@@ -21,14 +26,6 @@ func CreateUserProfile(tx *gorm.DB, userID uuid.UUID, role string, profileData i
 		if profile.RollNo == "" {
 			return errors.New("roll number is required for students")
 		}
-		profile.UserID = userID
-		if err := tx.Create(profile).Error; err != nil {
-			if errors.Is(err, gorm.ErrDuplicatedKey) {
-				// Return a specific, structured error
-				return fmt.Errorf("duplicate key error: roll number '%s' already exists", profile.RollNo)
-			}
-			return fmt.Errorf("failed to create student profile: %w", err)
-		}
 	case "instructor":
 		profile, ok := profileData.(*models.InstructorProfile)
 		if !ok || profile == nil {
@@ -37,13 +34,6 @@ func CreateUserProfile(tx *gorm.DB, userID uuid.UUID, role string, profileData i
 		if profile.EmployeeID == "" {
 			return errors.New("employee ID is required for instructors")
 		}
-		profile.UserID = userID
-		if err := tx.Create(profile).Error; err != nil {
-			if errors.Is(err, gorm.ErrDuplicatedKey) {
-				return fmt.Errorf("duplicate key error: employee ID '%s' already exists", profile.EmployeeID)
-			}
-			return fmt.Errorf("failed to create instructor profile: %w", err)
-		}
 	case "admin":
 		profile, ok := profileData.(*models.AdminProfile)
 		if !ok || profile == nil {
@@ -52,6 +42,38 @@ func CreateUserProfile(tx *gorm.DB, userID uuid.UUID, role string, profileData i
 		if profile.EmployeeID == "" {
 			return errors.New("employee ID is required for admins")
 		}
+	}
+	return nil
+}
+
+// CreateUserProfile is a helper function to create the correct profile based on role.
+// It now accepts the specific profile struct as an interface{}. A student profile's IsTA
+// is ignored: only syncTAStatus sets it, from the user's TA assignments.
+func CreateUserProfile(tx *gorm.DB, userID uuid.UUID, role string, profileData interface{}) error {
+	if err := ValidateUserProfile(role, profileData); err != nil {
+		return err
+	}
+
+	switch profile := profileData.(type) {
+	case *models.StudentProfile:
+		profile.UserID = userID
+		profile.IsTA = false
+		if err := tx.Create(profile).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				// Return a specific, structured error
+				return fmt.Errorf("duplicate key error: roll number '%s' already exists", profile.RollNo)
+			}
+			return fmt.Errorf("failed to create student profile: %w", err)
+		}
+	case *models.InstructorProfile:
+		profile.UserID = userID
+		if err := tx.Create(profile).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return fmt.Errorf("duplicate key error: employee ID '%s' already exists", profile.EmployeeID)
+			}
+			return fmt.Errorf("failed to create instructor profile: %w", err)
+		}
+	case *models.AdminProfile:
 		profile.UserID = userID
 		if err := tx.Create(profile).Error; err != nil {
 			if errors.Is(err, gorm.ErrDuplicatedKey) {
@@ -59,8 +81,31 @@ func CreateUserProfile(tx *gorm.DB, userID uuid.UUID, role string, profileData i
 			}
 			return fmt.Errorf("failed to create admin profile: %w", err)
 		}
-	default:
-		return fmt.Errorf("invalid or unsupported role: %s", role)
+	}
+	return nil
+}
+
+// CreateUserWithProfile inserts a user and their role's profile inside tx, after checking
+// that the email (case-insensitively), roll number or employee ID is not already taken.
+func CreateUserWithProfile(tx *gorm.DB, user *models.User, profile interface{}) error {
+	if err := ValidateUserProfile(user.Role, profile); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+	var count int64
+	if err := tx.Model(&models.User{}).Where("LOWER(email) = ?", strings.ToLower(user.Email)).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check email: %w", err)
+	}
+	if count > 0 {
+		return ErrEmailTaken
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	if err := checkNewProfileUnique(tx, profile, user.ID); err != nil {
+		return err
+	}
+	if err := CreateUserProfile(tx, user.ID, user.Role, profile); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProfile, err)
 	}
 	return nil
 }
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"auth/internal/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrEmailTaken      = errors.New("email already exists")
+	ErrRollNoTaken     = errors.New("roll number already exists")
+	ErrEmployeeIDTaken = errors.New("employee ID already exists")
+	ErrSameRole        = errors.New("user already has this role")
+	ErrInvalidProfile  = errors.New("invalid profile")
+)
+
+// profileTable returns the profile model used by a role.
+func profileTable(role string) interface{} {
+	switch models.ProfileKind(role) {
+	case "student":
+		return &models.StudentProfile{}
+	case "instructor":
+		return &models.InstructorProfile{}
+	}
+	return &models.AdminProfile{}
+}
+
+// UpdateUserProfile writes already-validated profile columns for the user's role,
+// first checking that a new roll number or employee ID is not used by someone else.
+func UpdateUserProfile(tx *gorm.DB, userID uuid.UUID, role string, columns map[string]interface{}) error {
+	table := profileTable(role)
+	if rollNo, ok := columns["roll_no"]; ok {
+		if err := checkUnique(tx, &models.StudentProfile{}, "roll_no", rollNo, userID, ErrRollNoTaken); err != nil {
+			return err
+		}
+	}
+	if employeeID, ok := columns["employee_id"]; ok {
+		if err := checkUnique(tx, table, "employee_id", employeeID, userID, ErrEmployeeIDTaken); err != nil {
+			return err
+		}
+	}
+
+	result := tx.Model(table).Where("user_id = ?", userID).Updates(columns)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update profile: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func checkUnique(tx *gorm.DB, table interface{}, column string, value interface{}, userID uuid.UUID, taken error) error {
+	var count int64
+	if err := tx.Model(table).Where(column+" = ? AND user_id <> ?", value, userID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check %s: %w", column, err)
+	}
+	if count > 0 {
+		return taken
+	}
+	return nil
+}
+
+// ChangeUserEmail sets a new login email, which must not belong to another account
+// (compared case-insensitively). Unused password reset links are invalidated because
+// they were sent to the old address. It returns the old email.
+func ChangeUserEmail(db *gorm.DB, userID uuid.UUID, email string) (string, error) {
+	var oldEmail string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		oldEmail = user.Email
+
+		var count int64
+		if err := tx.Model(&models.User{}).Where("LOWER(email) = ? AND id <> ?", strings.ToLower(email), userID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check email: %w", err)
+		}
+		if count > 0 {
+			return ErrEmailTaken
+		}
+
+		if err := tx.Model(&user).Update("email", email).Error; err != nil {
+			return fmt.Errorf("failed to update email: %w", err)
+		}
+		if err := tx.Where("user_id = ? AND used_at IS NULL", userID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return fmt.Errorf("failed to invalidate password reset tokens: %w", err)
+		}
+		return nil
+	})
+	return oldEmail, err
+}
+
+// ChangeUserRole moves a user to a new role and records the change. The old profile is
+// replaced with newProfile, which must match the new role. The "ta" role cannot be set
+// here because it follows from TA assignments; moving a TA to another role drops their
+// assignments. All of the user's sessions are revoked, since their access tokens carry
+// the old role.
+func ChangeUserRole(db *gorm.DB, userID uuid.UUID, newRole string, newProfile interface{}, changedBy uuid.UUID, reason string) (*models.RoleChange, error) {
+	var change *models.RoleChange
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		if newRole == "ta" {
+			return ErrUseTAAssignments
+		}
+		if exists, err := RoleExists(tx, newRole); err != nil {
+			return err
+		} else if !exists {
+			return ErrUnknownRole
+		}
+		if user.Role == newRole {
+			return ErrSameRole
+		}
+
+		if user.Role == "ta" {
+			if err := tx.Where("user_id = ?", userID).Delete(&models.TAAssignment{}).Error; err != nil {
+				return fmt.Errorf("failed to remove TA assignments: %w", err)
+			}
+		}
+
+		if models.ProfileKind(user.Role) == models.ProfileKind(newRole) {
+			// Same profile table (e.g. ta to student, or admin to a custom staff role)
+			if isStudentRole(user.Role) {
+				if err := tx.Model(&models.StudentProfile{}).Where("user_id = ?", userID).Update("is_ta", false).Error; err != nil {
+					return fmt.Errorf("failed to update TA flag: %w", err)
+				}
+			}
+		} else {
+			if err := ValidateUserProfile(newRole, newProfile); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+			}
+			if err := tx.Where("user_id = ?", userID).Delete(profileTable(user.Role)).Error; err != nil {
+				return fmt.Errorf("failed to remove old profile: %w", err)
+			}
+			if err := checkNewProfileUnique(tx, newProfile, userID); err != nil {
+				return err
+			}
+			if err := CreateUserProfile(tx, userID, newRole, newProfile); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&user).Update("role", newRole).Error; err != nil {
+			return fmt.Errorf("failed to update role: %w", err)
+		}
+		change = &models.RoleChange{
+			UserID:    userID,
+			OldRole:   user.Role,
+			NewRole:   newRole,
+			ChangedBy: changedBy,
+			Reason:    reason,
+			CreatedAt: time.Now(),
+		}
+		if err := tx.Create(change).Error; err != nil {
+			return fmt.Errorf("failed to record role change: %w", err)
+		}
+		return RevokeUserSessions(tx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+func checkNewProfileUnique(tx *gorm.DB, profile interface{}, userID uuid.UUID) error {
+	switch p := profile.(type) {
+	case *models.StudentProfile:
+		return checkUnique(tx, &models.StudentProfile{}, "roll_no", p.RollNo, userID, ErrRollNoTaken)
+	case *models.InstructorProfile:
+		return checkUnique(tx, &models.InstructorProfile{}, "employee_id", p.EmployeeID, userID, ErrEmployeeIDTaken)
+	case *models.AdminProfile:
+		return checkUnique(tx, &models.AdminProfile{}, "employee_id", p.EmployeeID, userID, ErrEmployeeIDTaken)
+	}
+	return nil
+}
+
+func isStudentRole(role string) bool {
+	return role == "student" || role == "ta"
+}
+
+// LoadUserProfile returns the profile row for the user's role, e.g. to audit a change.
+func LoadUserProfile(db *gorm.DB, userID uuid.UUID, role string) (interface{}, error) {
+	profile := profileTable(role)
+	if err := db.Where("user_id = ?", userID).First(profile).Error; err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
@@ -0,0 +1,229 @@
+package database
+
+import (
+	"auth/internal/models"
+	"errors"
+	"fmt"
+	"lms/pkg/permissions"
+	"regexp"
+	"slices"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownRole       = errors.New("role does not exist")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrInvalidRoleName   = errors.New("role names must be 2-50 lowercase letters, digits, '_' or '-'")
+	ErrBuiltinRole       = errors.New("built-in roles cannot be deleted")
+	ErrRoleInUse         = errors.New("role is still assigned to users")
+	ErrAdminRoleLocked   = errors.New("the admin role must keep the \"*\" permission")
+	ErrLastRoleManager   = errors.New("at least one role must keep the roles:manage permission")
+	ErrPrivilegedRole    = errors.New("roles that can manage roles are not managed through provisioning")
+	ErrPermissionNotHeld = errors.New("you cannot manage a user with permissions you do not have")
+)
+
+// adminRole is the built-in role that always holds every permission.
+const adminRole = "admin"
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+// SeedPermissions makes sure every registered permission and every built-in role exists.
+// Existing built-in roles keep whatever permissions admins have given them since.
+func SeedPermissions(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, definition := range permissions.Registry {
+			permission := models.Permission{Name: definition.Name, Description: definition.Description}
+			if err := tx.Save(&permission).Error; err != nil {
+				return fmt.Errorf("failed to seed permission %s: %w", definition.Name, err)
+			}
+		}
+
+		for name, granted := range permissions.BuiltinRoles {
+			var count int64
+			if err := tx.Model(&models.Role{}).Where("name = ?", name).Count(&count).Error; err != nil {
+				return fmt.Errorf("failed to look up role %s: %w", name, err)
+			}
+			if count > 0 {
+				continue
+			}
+			role := models.Role{Name: name, Builtin: true, Description: "Built-in " + name + " role"}
+			for _, p := range granted {
+				role.Permissions = append(role.Permissions, models.Permission{Name: p})
+			}
+			if err := tx.Omit("Permissions.*").Create(&role).Error; err != nil {
+				return fmt.Errorf("failed to seed role %s: %w", name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// PermissionsForRole returns the permission names granted to a role, sorted.
+// An unknown role has no permissions.
+func PermissionsForRole(db *gorm.DB, role string) ([]string, error) {
+	var names []string
+	err := db.Table("role_permissions").Where("role_name = ?", role).Order("permission_name").
+		Pluck("permission_name", &names).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load permissions for role %s: %w", role, err)
+	}
+	return names, nil
+}
+
+// RoleExists reports whether a role has been defined.
+func RoleExists(db *gorm.DB, role string) (bool, error) {
+	var count int64
+	if err := db.Model(&models.Role{}).Where("name = ?", role).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to look up role: %w", err)
+	}
+	return count > 0, nil
+}
+
+// IsPrivilegedRole reports whether a role can manage roles, which includes holding "*".
+// Holders of such a role can grant themselves anything, so automated provisioning must
+// neither hand it out nor change the credentials of those who have it.
+func IsPrivilegedRole(db *gorm.DB, role string) (bool, error) {
+	granted, err := PermissionsForRole(db, role)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Grants(granted, permissions.RolesManage), nil
+}
+
+// ListRoles returns every role with its permissions.
+func ListRoles(db *gorm.DB) ([]models.Role, error) {
+	var roles []models.Role
+	if err := db.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	return roles, nil
+}
+
+// ListPermissions returns the permission registry.
+func ListPermissions(db *gorm.DB) ([]models.Permission, error) {
+	var list []models.Permission
+	if err := db.Order("name").Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("failed to list permissions: %w", err)
+	}
+	return list, nil
+}
+
+// SaveRole creates a role or replaces its description and permissions. The admin role
+// cannot lose "*", and no edit may leave every role without roles:manage, since either
+// would leave nobody able to fix the roles again.
+func SaveRole(db *gorm.DB, name, description string, granted []string) (*models.Role, error) {
+	if !roleNamePattern.MatchString(name) {
+		return nil, ErrInvalidRoleName
+	}
+	granted = dedupe(granted)
+	for _, p := range granted {
+		if !permissions.Known(p) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPermission, p)
+		}
+	}
+	if name == adminRole && !slices.Contains(granted, permissions.All) {
+		return nil, ErrAdminRoleLocked
+	}
+
+	var role models.Role
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Serialize role edits so two of them cannot each remove the other's roles:manage
+		if err := tx.Exec("LOCK TABLE role_permissions IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+			return fmt.Errorf("failed to lock role permissions: %w", err)
+		}
+		err := tx.First(&role, "name = ?", name).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			role = models.Role{Name: name, Description: description}
+			if err := tx.Create(&role).Error; err != nil {
+				return fmt.Errorf("failed to create role: %w", err)
+			}
+		case err != nil:
+			return fmt.Errorf("failed to look up role: %w", err)
+		default:
+			if err := tx.Model(&role).Update("description", description).Error; err != nil {
+				return fmt.Errorf("failed to update role: %w", err)
+			}
+		}
+
+		list := make([]models.Permission, 0, len(granted))
+		for _, p := range granted {
+			list = append(list, models.Permission{Name: p})
+		}
+		if err := tx.Model(&role).Omit("Permissions.*").Association("Permissions").Replace(list); err != nil {
+			return fmt.Errorf("failed to set role permissions: %w", err)
+		}
+		role.Permissions = list
+		return checkRoleManagerRemains(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// DeleteRole removes a custom role that no user has.
+func DeleteRole(db *gorm.DB, name string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var role models.Role
+		if err := tx.First(&role, "name = ?", name).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUnknownRole
+			}
+			return fmt.Errorf("failed to look up role: %w", err)
+		}
+		if role.Builtin {
+			return ErrBuiltinRole
+		}
+
+		var users int64
+		if err := tx.Model(&models.User{}).Where("role = ?", name).Count(&users).Error; err != nil {
+			return fmt.Errorf("failed to count users with role: %w", err)
+		}
+		if users > 0 {
+			return ErrRoleInUse
+		}
+
+		if err := tx.Model(&role).Association("Permissions").Clear(); err != nil {
+			return fmt.Errorf("failed to clear role permissions: %w", err)
+		}
+		return tx.Delete(&role).Error
+	})
+}
+
+// checkRoleManagerRemains returns ErrLastRoleManager unless some role still grants
+// roles:manage.
+func checkRoleManagerRemains(tx *gorm.DB) error {
+	var rows []struct {
+		RoleName       string
+		PermissionName string
+	}
+	if err := tx.Table("role_permissions").Select("role_name", "permission_name").Find(&rows).Error; err != nil {
+		return fmt.Errorf("failed to load role permissions: %w", err)
+	}
+	granted := map[string][]string{}
+	for _, row := range rows {
+		granted[row.RoleName] = append(granted[row.RoleName], row.PermissionName)
+	}
+	for _, list := range granted {
+		if permissions.Grants(list, permissions.RolesManage) {
+			return nil
+		}
+	}
+	return ErrLastRoleManager
+}
+
+func dedupe(values []string) []string {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"auth/internal/models"
+	"auth/internal/scim"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrExternalIDTaken is returned when another user already has the provisioning system's ID.
+var ErrExternalIDTaken = errors.New("externalId already belongs to another user")
+
+// fullNameExpr and friends pick a user's field from whichever profile table they have.
+const (
+	fullNameExpr   = "COALESCE(sp.full_name, ip.full_name, ap.full_name)"
+	employeeNoExpr = "COALESCE(sp.roll_no, ip.employee_id, ap.employee_id)"
+)
+
+// scimUserColumns are the SCIM User attributes that can be filtered on.
+var scimUserColumns = map[string]scim.Column{
+	"id":                 {Expr: "users.id::text", CaseExact: true},
+	"externalid":         {Expr: "users.external_id", CaseExact: true},
+	"username":           {Expr: "users.email"},
+	"emails.value":       {Expr: "users.email"},
+	"emails.type":        {Expr: "'work'"},
+	"emails.primary":     {Expr: "TRUE", Kind: scim.BoolColumn},
+	"usertype":           {Expr: "users.role"},
+	"groups.value":       {Expr: "users.role"},
+	"groups.display":     {Expr: "users.role"},
+	"active":             {Expr: "(users.status = 'active')", Kind: scim.BoolColumn},
+	"displayname":        {Expr: fullNameExpr},
+	"name.formatted":     {Expr: fullNameExpr},
+	"title":              {Expr: "COALESCE(ip.title, ap.job_title)"},
+	"phonenumbers.value": {Expr: "sp.contact_number"},
+	"meta.created":       {Expr: "users.created_at", Kind: scim.TimeColumn},
+	"meta.lastmodified":  {Expr: "users.updated_at", Kind: scim.TimeColumn},
+	strings.ToLower(scim.EnterpriseUserSchema) + ":employeenumber": {Expr: employeeNoExpr},
+	strings.ToLower(scim.EnterpriseUserSchema) + ":department":     {Expr: "COALESCE(sp.branch, ip.department)"},
+}
+
+// scimGroupColumns are the SCIM Group attributes that can be filtered on.
+var scimGroupColumns = map[string]scim.Column{
+	"id":                {Expr: "roles.name"},
+	"displayname":       {Expr: "roles.name"},
+	"meta.created":      {Expr: "roles.created_at", Kind: scim.TimeColumn},
+	"meta.lastmodified": {Expr: "roles.updated_at", Kind: scim.TimeColumn},
+}
+
+func preloadProfiles(db *gorm.DB) *gorm.DB {
+	return db.Preload("StudentProfile").Preload("InstructorProfile").Preload("AdminProfile")
+}
+
+// ListSCIMUsers returns one page of users matching a SCIM filter (nil for all), with
+// their profiles, and the total number of matches.
+func ListSCIMUsers(db *gorm.DB, filter scim.Filter, page scim.Page) ([]models.User, int64, error) {
+	query := db.Model(&models.User{}).
+		Joins("LEFT JOIN student_profiles sp ON sp.user_id = users.id").
+		Joins("LEFT JOIN instructor_profiles ip ON ip.user_id = users.id").
+		Joins("LEFT JOIN admin_profiles ap ON ap.user_id = users.id")
+	if filter != nil {
+		where, args, err := scim.ToSQL(filter, scimUserColumns)
+		if err != nil {
+			return nil, 0, err
+		}
+		query = query.Where(where, args...)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	var users []models.User
+	if page.Count > 0 {
+		err := preloadProfiles(query.Select("users.*")).Order("users.created_at, users.id").
+			Offset(page.Offset()).Limit(page.Count).Find(&users).Error
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to list users: %w", err)
+		}
+	}
+	return users, total, nil
+}
+
+// FindSCIMUser returns a user with their profile.
+func FindSCIMUser(db *gorm.DB, userID uuid.UUID) (*models.User, error) {
+	var user models.User
+	if err := preloadProfiles(db).First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetExternalID stores the provisioning system's ID for a user; nil clears it.
+func SetExternalID(tx *gorm.DB, userID uuid.UUID, externalID *string) error {
+	if externalID != nil {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("external_id = ? AND id <> ?", *externalID, userID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check externalId: %w", err)
+		}
+		if count > 0 {
+			return ErrExternalIDTaken
+		}
+	}
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("external_id", externalID).Error; err != nil {
+		return fmt.Errorf("failed to update externalId: %w", err)
+	}
+	return nil
+}
+
+// ListSCIMGroups returns one page of roles matching a SCIM filter (nil for all) and the
+// total number of matches.
+func ListSCIMGroups(db *gorm.DB, filter scim.Filter, page scim.Page) ([]models.Role, int64, error) {
+	query := db.Model(&models.Role{})
+	if filter != nil {
+		where, args, err := scim.ToSQL(filter, scimGroupColumns)
+		if err != nil {
+			return nil, 0, err
+		}
+		query = query.Where(where, args...)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count roles: %w", err)
+	}
+	var roles []models.Role
+	if page.Count > 0 {
+		if err := query.Order("name").Offset(page.Offset()).Limit(page.Count).Find(&roles).Error; err != nil {
+			return nil, 0, fmt.Errorf("failed to list roles: %w", err)
+		}
+	}
+	return roles, total, nil
+}
+
+// RoleMembers returns the users holding each of the given roles, without profiles.
+func RoleMembers(db *gorm.DB, roles []string) (map[string][]models.User, error) {
+	var users []models.User
+	if err := db.Select("id", "email", "role", "updated_at").Where("role IN ?", roles).Order("email").Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to list role members: %w", err)
+	}
+	members := map[string][]models.User{}
+	for _, user := range users {
+		members[user.Role] = append(members[user.Role], user)
+	}
+	return members, nil
+}
+
+// FindRole returns a role by name, or ErrUnknownRole.
+func FindRole(db *gorm.DB, name string) (*models.Role, error) {
+	var role models.Role
+	if err := db.First(&role, "name = ?", name).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownRole
+		}
+		return nil, fmt.Errorf("failed to look up role: %w", err)
+	}
+	return &role, nil
+}
@@ -0,0 +1,240 @@
+package database
+
+import (
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"errors"
+	"fmt"
+	"lms/pkg/permissions"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidServiceAccountName = errors.New("service account names must be 2-100 lowercase letters, digits, '_', '-' or '.'")
+	ErrServiceAccountNameTaken   = errors.New("a service account with this name already exists")
+	ErrUnknownScope              = errors.New("unknown scope")
+	ErrScopeNotGranted           = errors.New("scope is not granted to this service account")
+	ErrScopeNotHeld              = errors.New("you cannot grant a scope you do not hold")
+	ErrAPIKeyNotFound            = errors.New("API key not found")
+	// ErrInvalidClientCredentials covers unknown, revoked and expired keys as well as
+	// disabled accounts, so a caller cannot probe which one it was.
+	ErrInvalidClientCredentials = errors.New("invalid client credentials")
+)
+
+var serviceAccountNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_.-]{1,99}$`)
+
+// apiKeyPrefix marks LMS API keys so they are easy to spot in logs and secret scanners.
+const apiKeyPrefix = "lms_"
+
+// apiKeyPrefixLength is how much of a key is kept in clear to identify it.
+const apiKeyPrefixLength = len(apiKeyPrefix) + 8
+
+// normalizeScopes checks every scope is one a service account can hold and that the
+// granter may hand it out, and returns them de-duplicated and sorted. Service-only
+// scopes can be granted by anyone managing service accounts; any other scope must be
+// covered by the granter's own permissions, so an account is never more powerful than
+// the admin who set it up.
+func normalizeScopes(scopes, granterPermissions []string) ([]string, error) {
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !permissions.KnownScope(scope) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownScope, scope)
+		}
+		if !permissions.IsServiceScope(scope) && !permissions.Grants(granterPermissions, scope) {
+			return nil, fmt.Errorf("%w: %q", ErrScopeNotHeld, scope)
+		}
+		if !slices.Contains(normalized, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	slices.Sort(normalized)
+	return normalized, nil
+}
+
+// CreateServiceAccount registers a new machine client with the given scopes, each of
+// which must be one granterPermissions allows handing out.
+func CreateServiceAccount(db *gorm.DB, name, description string, scopes []string, createdBy uuid.UUID, granterPermissions []string) (*models.ServiceAccount, error) {
+	if !serviceAccountNamePattern.MatchString(name) {
+		return nil, ErrInvalidServiceAccountName
+	}
+	scopes, err := normalizeScopes(scopes, granterPermissions)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := db.Model(&models.ServiceAccount{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to look up service account: %w", err)
+	}
+	if count > 0 {
+		return nil, ErrServiceAccountNameTaken
+	}
+
+	account := models.ServiceAccount{
+		Name:        name,
+		Description: description,
+		Scopes:      strings.Join(scopes, " "),
+		CreatedBy:   createdBy,
+	}
+	if err := db.Create(&account).Error; err != nil {
+		// Another request can take the name between the check and the insert
+		if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "duplicate key") {
+			return nil, ErrServiceAccountNameTaken
+		}
+		return nil, fmt.Errorf("failed to create service account: %w", err)
+	}
+	return &account, nil
+}
+
+// UpdateServiceAccount changes an account's description, scopes or disabled flag; nil
+// arguments are left alone. New scopes are checked against granterPermissions as on
+// creation. Narrowing the scopes applies to every key at its next token request, and
+// tokens already issued run out within their short lifetime.
+func UpdateServiceAccount(db *gorm.DB, id uuid.UUID, description *string, scopes []string, disabled *bool, granterPermissions []string) (*models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	if err := db.First(&account, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if description != nil {
+		updates["description"] = *description
+	}
+	if scopes != nil {
+		normalized, err := normalizeScopes(scopes, granterPermissions)
+		if err != nil {
+			return nil, err
+		}
+		updates["scopes"] = strings.Join(normalized, " ")
+	}
+	if disabled != nil {
+		updates["disabled"] = *disabled
+	}
+	if len(updates) > 0 {
+		if err := db.Model(&account).Updates(updates).Error; err != nil {
+			return nil, fmt.Errorf("failed to update service account: %w", err)
+		}
+	}
+	return &account, nil
+}
+
+// ListServiceAccounts returns every service account with its keys, newest keys first.
+func ListServiceAccounts(db *gorm.DB) ([]models.ServiceAccount, error) {
+	var accounts []models.ServiceAccount
+	err := db.Preload("APIKeys", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at DESC") }).
+		Order("name").Find(&accounts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service accounts: %w", err)
+	}
+	return accounts, nil
+}
+
+// CreateAPIKey issues a new key for a service account and returns it with the raw key,
+// which is never stored and cannot be shown again. Empty scopes mean the key gets
+// whatever its account is granted.
+func CreateAPIKey(db *gorm.DB, accountID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (*models.APIKey, string, error) {
+	var account models.ServiceAccount
+	if err := db.First(&account, "id = ?", accountID).Error; err != nil {
+		return nil, "", err
+	}
+	// The loop below holds keys to the account's own scopes
+	scopes, err := normalizeScopes(scopes, account.ScopeList())
+	if err != nil {
+		return nil, "", err
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(account.ScopeList(), scope) {
+			return nil, "", fmt.Errorf("%w: %q", ErrScopeNotGranted, scope)
+		}
+	}
+
+	secret, _, err := oauth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	rawKey := apiKeyPrefix + secret
+	key := models.APIKey{
+		ServiceAccountID: account.ID,
+		Name:             name,
+		Prefix:           rawKey[:apiKeyPrefixLength],
+		KeyHash:          oauth.HashOpaqueToken(rawKey),
+		Scopes:           strings.Join(scopes, " "),
+		ExpiresAt:        expiresAt,
+	}
+	if err := db.Create(&key).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+	return &key, rawKey, nil
+}
+
+// RevokeAPIKey stops a key from being exchanged for new tokens.
+func RevokeAPIKey(db *gorm.DB, accountID, keyID uuid.UUID) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := db.First(&key, "id = ? AND service_account_id = ?", keyID, accountID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	if key.RevokedAt != nil {
+		return &key, nil
+	}
+	now := time.Now()
+	if err := db.Model(&key).Update("revoked_at", now).Error; err != nil {
+		return nil, fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	return &key, nil
+}
+
+// AuthenticateClient checks a client-credentials request and returns the service account
+// with the scopes its token should carry. clientID may be empty, since the key alone
+// identifies the account. requested narrows the scopes; when empty, the token gets every
+// scope the key allows. The key's last use is recorded.
+func AuthenticateClient(db *gorm.DB, clientID, rawKey string, requested []string) (*models.ServiceAccount, []string, error) {
+	var key models.APIKey
+	if err := db.First(&key, "key_hash = ?", oauth.HashOpaqueToken(rawKey)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidClientCredentials
+		}
+		return nil, nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	if !key.IsActive() || (clientID != "" && clientID != key.ServiceAccountID.String()) {
+		return nil, nil, ErrInvalidClientCredentials
+	}
+
+	var account models.ServiceAccount
+	if err := db.First(&account, "id = ?", key.ServiceAccountID).Error; err != nil {
+		return nil, nil, ErrInvalidClientCredentials
+	}
+	if account.Disabled {
+		return nil, nil, ErrInvalidClientCredentials
+	}
+
+	// The account's current scopes always bound the key's, so narrowing an account
+	// takes effect for keys issued before the change.
+	allowed := account.ScopeList()
+	if keyScopes := strings.Fields(key.Scopes); len(keyScopes) > 0 {
+		allowed = slices.DeleteFunc(keyScopes, func(s string) bool { return !slices.Contains(allowed, s) })
+	}
+	granted := allowed
+	if len(requested) > 0 {
+		for _, scope := range requested {
+			if !slices.Contains(allowed, scope) {
+				return nil, nil, fmt.Errorf("%w: %q", ErrScopeNotGranted, scope)
+			}
+		}
+		granted = requested
+	}
+
+	if err := db.Model(&key).Update("last_used_at", time.Now()).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to record API key use: %w", err)
+	}
+	return &account, granted, nil
+}
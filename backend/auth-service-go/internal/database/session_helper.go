@@ -0,0 +1,274 @@
+package database
+
+import (
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned when a refresh token is unknown, expired or its session has ended.
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when an already-rotated refresh token is presented again.
+	// This usually means the token was stolen, so the whole session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	// ErrSessionNotFound is returned when a session does not exist, belongs to someone
+	// else or has already ended.
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// ClientInfo describes the client a login came from.
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+	DeviceID  string // the client's own stable device identifier, if it sends one
+}
+
+// DeviceHash identifies the device: the hash of its DeviceID, or of its user agent when
+// it has none.
+func (client ClientInfo) DeviceHash() string {
+	id := "ua:" + client.UserAgent
+	if client.DeviceID != "" {
+		id = "id:" + client.DeviceID
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession starts a new server-side session for a user and returns it with its first refresh token.
+func CreateSession(tx *gorm.DB, userID uuid.UUID, client ClientInfo) (*models.Session, string, error) {
+	now := time.Now()
+	session := models.Session{
+		UserID:     userID,
+		IPAddress:  client.IPAddress,
+		UserAgent:  client.UserAgent,
+		DeviceHash: client.DeviceHash(),
+		ExpiresAt:  now.Add(oauth.RefreshTokenTTL()),
+		LastUsedAt: now,
+	}
+	if err := tx.Create(&session).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create session: %w", err)
+	}
+
+	refreshToken, err := issueRefreshToken(tx, &session)
+	if err != nil {
+		return nil, "", err
+	}
+	return &session, refreshToken, nil
+}
+
+// CreateImpersonationSession starts a short session in which actorID acts as userID.
+// It has no refresh token, so it simply ends when it expires or is revoked.
+func CreateImpersonationSession(tx *gorm.DB, userID, actorID uuid.UUID, ttl time.Duration) (*models.Session, error) {
+	now := time.Now()
+	session := models.Session{
+		UserID:         userID,
+		ImpersonatorID: &actorID,
+		ExpiresAt:      now.Add(ttl),
+		LastUsedAt:     now,
+	}
+	if err := tx.Create(&session).Error; err != nil {
+		return nil, fmt.Errorf("failed to create impersonation session: %w", err)
+	}
+	return &session, nil
+}
+
+// RotateRefreshToken consumes a refresh token and issues its replacement in the same session.
+func RotateRefreshToken(db *gorm.DB, rawToken string) (*models.Session, string, error) {
+	var session models.Session
+	var newToken string
+	reused := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var stored models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", oauth.HashOpaqueToken(rawToken)).
+			First(&stored).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return fmt.Errorf("failed to look up refresh token: %w", err)
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, "id = ?", stored.SessionID).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+		if !session.IsActive() {
+			return ErrInvalidRefreshToken
+		}
+		if active, err := IsUserActive(tx, session.UserID); err != nil {
+			return err
+		} else if !active {
+			return ErrInvalidRefreshToken
+		}
+
+		if stored.UsedAt != nil {
+			reused = true
+			return ErrRefreshTokenReused
+		}
+		if time.Now().After(stored.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		now := time.Now()
+		if err := tx.Model(&stored).Update("used_at", now).Error; err != nil {
+			return fmt.Errorf("failed to consume refresh token: %w", err)
+		}
+		if err := tx.Model(&session).Update("last_used_at", now).Error; err != nil {
+			return fmt.Errorf("failed to touch session: %w", err)
+		}
+
+		newToken, err = issueRefreshToken(tx, &session)
+		return err
+	})
+
+	if reused {
+		// Revoke outside the rolled-back transaction so the revocation sticks.
+		if err := RevokeSession(db, session.ID); err != nil {
+			return nil, "", err
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &session, newToken, nil
+}
+
+// RevokeSession ends a single session. Revoking an already-revoked session is a no-op.
+func RevokeSession(db *gorm.DB, sessionID uuid.UUID) error {
+	err := db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserSessions ends every active session belonging to a user.
+func RevokeUserSessions(db *gorm.DB, userID uuid.UUID) error {
+	err := db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserSession ends one of a user's sessions, or returns ErrSessionNotFound if
+// they have no such active session.
+func RevokeUserSession(db *gorm.DB, userID, sessionID uuid.UUID) error {
+	result := db.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, userID, time.Now()).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke session: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// ListActiveSessions returns a user's sessions that have neither expired nor been
+// revoked, most recently used first.
+func ListActiveSessions(db *gorm.DB, userID uuid.UUID) ([]models.Session, error) {
+	var sessions []models.Session
+	err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").Find(&sessions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// RememberDevice records that a user logged in from a device and reports whether the
+// device is new to them. A user's very first device is not reported as new: there is
+// nothing to warn them about on their first login.
+func RememberDevice(db *gorm.DB, userID uuid.UUID, client ClientInfo) (bool, error) {
+	now := time.Now()
+	hash := client.DeviceHash()
+	isNew := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var known int64
+		if err := tx.Model(&models.KnownDevice{}).Where("user_id = ?", userID).Count(&known).Error; err != nil {
+			return fmt.Errorf("failed to count known devices: %w", err)
+		}
+
+		device := models.KnownDevice{
+			UserID:        userID,
+			DeviceHash:    hash,
+			UserAgent:     client.UserAgent,
+			LastIPAddress: client.IPAddress,
+			FirstSeenAt:   now,
+			LastSeenAt:    now,
+		}
+		// Insert, or touch the existing row, in one statement so concurrent logins from
+		// the same device cannot both see it as new
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "device_hash"}},
+			DoNothing: true,
+		}).Create(&device)
+		if result.Error != nil {
+			return fmt.Errorf("failed to record device: %w", result.Error)
+		}
+		if result.RowsAffected == 1 {
+			isNew = known > 0
+			return nil
+		}
+		err := tx.Model(&models.KnownDevice{}).Where("user_id = ? AND device_hash = ?", userID, hash).
+			Updates(map[string]interface{}{"user_agent": client.UserAgent, "last_ip_address": client.IPAddress, "last_seen_at": now}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update device: %w", err)
+		}
+		return nil
+	})
+	return isNew, err
+}
+
+// IsSessionActive reports whether a session exists, has neither expired nor been revoked,
+// and belongs to a user whose account is still active.
+func IsSessionActive(db *gorm.DB, sessionID string) (bool, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return false, nil
+	}
+	var session models.Session
+	if err := db.First(&session, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to look up session: %w", err)
+	}
+	if !session.IsActive() {
+		return false, nil
+	}
+	return IsUserActive(db, session.UserID)
+}
+
+func issueRefreshToken(tx *gorm.DB, session *models.Session) (string, error) {
+	rawToken, hash, err := oauth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	refreshToken := models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: hash,
+		ExpiresAt: session.ExpiresAt,
+	}
+	if err := tx.Create(&refreshToken).Error; err != nil {
+		return "", fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return rawToken, nil
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"auth/internal/models"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotAStudent          = errors.New("only students can be teaching assistants")
+	ErrTAAlreadyAssigned    = errors.New("student is already a TA for this course and semester")
+	ErrUseTAAssignments     = errors.New("TA status is granted per course; use the TA assignment endpoints")
+	ErrTAAssignmentNotFound = errors.New("TA assignment not found")
+)
+
+// GrantTA makes a student a TA for one course offering and keeps the user's role and
+// IsTA flag in step. Promoting a student revokes their sessions, because their access
+// tokens still carry the "student" role.
+func GrantTA(db *gorm.DB, userID, courseID uuid.UUID, semester string, grantedBy uuid.UUID) (*models.TAAssignment, error) {
+	assignment := &models.TAAssignment{UserID: userID, CourseID: courseID, Semester: semester, GrantedBy: grantedBy}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		if !isStudentRole(user.Role) {
+			return ErrNotAStudent
+		}
+
+		var count int64
+		if err := tx.Model(&models.TAAssignment{}).
+			Where("user_id = ? AND course_id = ? AND semester = ?", userID, courseID, semester).
+			Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check TA assignments: %w", err)
+		}
+		if count > 0 {
+			return ErrTAAlreadyAssigned
+		}
+		if err := tx.Create(assignment).Error; err != nil {
+			return fmt.Errorf("failed to create TA assignment: %w", err)
+		}
+		return syncTAStatus(tx, &user, true)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return assignment, nil
+}
+
+// RevokeTA removes one TA assignment. When it was the student's last one, the user goes
+// back to the "student" role and their sessions are revoked.
+func RevokeTA(db *gorm.DB, userID, courseID uuid.UUID, semester string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("user_id = ? AND course_id = ? AND semester = ?", userID, courseID, semester).
+			Delete(&models.TAAssignment{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete TA assignment: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrTAAssignmentNotFound
+		}
+
+		var remaining int64
+		if err := tx.Model(&models.TAAssignment{}).Where("user_id = ?", userID).Count(&remaining).Error; err != nil {
+			return fmt.Errorf("failed to count TA assignments: %w", err)
+		}
+		return syncTAStatus(tx, &user, remaining > 0)
+	})
+}
+
+// ListTAAssignments returns assignments filtered by any of the non-empty arguments.
+func ListTAAssignments(db *gorm.DB, userID, courseID *uuid.UUID, semester string) ([]models.TAAssignment, error) {
+	query := db.Model(&models.TAAssignment{})
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+	if courseID != nil {
+		query = query.Where("course_id = ?", *courseID)
+	}
+	if semester != "" {
+		query = query.Where("semester = ?", semester)
+	}
+
+	var assignments []models.TAAssignment
+	if err := query.Order("created_at").Find(&assignments).Error; err != nil {
+		return nil, fmt.Errorf("failed to list TA assignments: %w", err)
+	}
+	return assignments, nil
+}
+
+// syncTAStatus sets the user's role and StudentProfile.IsTA to match whether they hold
+// any TA assignment, revoking sessions if the role changed.
+func syncTAStatus(tx *gorm.DB, user *models.User, isTA bool) error {
+	role := "student"
+	if isTA {
+		role = "ta"
+	}
+	if err := tx.Model(&models.StudentProfile{}).Where("user_id = ?", user.ID).Update("is_ta", isTA).Error; err != nil {
+		return fmt.Errorf("failed to update TA flag: %w", err)
+	}
+	if user.Role == role {
+		return nil
+	}
+	if err := tx.Model(user).Update("role", role).Error; err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	return RevokeUserSessions(tx, user.ID)
+}
@@ -0,0 +1,95 @@
+// Package erp is a small client for the ERP service, used where account changes
+// depend on academic data (e.g. courses an instructor still teaches).
+package erp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+// Course is the subset of an ERP course the auth service cares about.
+type Course struct {
+	ID              string     `json:"ID"`
+	CourseCode      string     `json:"CourseCode"`
+	Name            string     `json:"Name"`
+	SemesterOffered string     `json:"SemesterOffered"`
+	InstructorID    string     `json:"InstructorID"`
+	ArchivedAt      *time.Time `json:"ArchivedAt"`
+}
+
+// ErrCourseNotFound is returned when the ERP service has no course with the given ID.
+var ErrCourseNotFound = errors.New("course not found")
+
+// baseURL reads ERP_SERVICE_URL, defaulting to the local ERP service.
+func baseURL() string {
+	if u := os.Getenv("ERP_SERVICE_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:8082"
+}
+
+// InstructorCourses lists the courses whose InstructorID is the given user. authHeader
+// is the caller's "Bearer ..." header and must belong to an admin.
+func InstructorCourses(authHeader, instructorID string) ([]Course, error) {
+	req, err := http.NewRequest("GET", baseURL()+"/admin/erp/instructors/"+url.PathEscape(instructorID)+"/courses", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call erp service for instructor courses: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("erp service returned an error for instructor courses: %s - %s", resp.Status, string(body))
+	}
+
+	var courses []Course
+	if err := json.NewDecoder(resp.Body).Decode(&courses); err != nil {
+		return nil, fmt.Errorf("failed to decode instructor courses response: %w", err)
+	}
+	return courses, nil
+}
+
+// GetCourse fetches one course by ID. authHeader is the caller's "Bearer ..." header;
+// any logged-in user may read a course.
+func GetCourse(authHeader, courseID string) (*Course, error) {
+	req, err := http.NewRequest("GET", baseURL()+"/courses/"+url.PathEscape(courseID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call erp service for course: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrCourseNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("erp service returned an error for course: %s - %s", resp.Status, string(body))
+	}
+
+	var course Course
+	if err := json.NewDecoder(resp.Body).Decode(&course); err != nil {
+		return nil, fmt.Errorf("failed to decode course response: %w", err)
+	}
+	return &course, nil
+}
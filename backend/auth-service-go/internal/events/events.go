@@ -0,0 +1,168 @@
+// Package events hands security events, such as a login from a device the user has not
+// used before, to whatever should act on them: an email to the user, a webhook into a
+// notification service, or the service log during development.
+package events
+
+import (
+	"auth/internal/mailer"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Event types.
+const (
+	NewDeviceLogin = "login.new_device"
+)
+
+// Event is one security event about a user.
+type Event struct {
+	Type      string    `json:"type"`
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	SessionID string    `json:"sessionId,omitempty"`
+	IPAddress string    `json:"ipAddress,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Notifier consumes events. Handlers depend on this interface, like they do on
+// mailer.Mailer, so notifications can be routed anywhere without touching them.
+type Notifier interface {
+	Notify(ev Event) error
+}
+
+// FromEnv builds the notifiers listed in NOTIFIERS (comma-separated, default "log"):
+//   - log: writes events to the service log
+//   - mail: emails the user through the configured mailer
+//   - webhook: POSTs events as JSON to NOTIFIER_WEBHOOK_URL, signed with
+//     NOTIFIER_WEBHOOK_SECRET when it is set
+func FromEnv(mail mailer.Mailer) Notifier {
+	names := os.Getenv("NOTIFIERS")
+	if names == "" {
+		names = "log"
+	}
+	var all Multi
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "log":
+			all = append(all, LogNotifier{})
+		case "mail":
+			all = append(all, &MailNotifier{Mailer: mail})
+		case "webhook":
+			all = append(all, &WebhookNotifier{
+				URL:    os.Getenv("NOTIFIER_WEBHOOK_URL"),
+				Secret: os.Getenv("NOTIFIER_WEBHOOK_SECRET"),
+				Client: &http.Client{Timeout: 10 * time.Second},
+			})
+		case "":
+		default:
+			log.Printf("Warning: unknown notifier %q in NOTIFIERS", name)
+		}
+	}
+	return all
+}
+
+// Publish delivers an event in the background so a slow notifier never delays a login.
+// A nil notifier drops the event.
+func Publish(n Notifier, ev Event) {
+	if n == nil {
+		return
+	}
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	go func() {
+		if err := n.Notify(ev); err != nil {
+			log.Printf("ERROR: Failed to deliver %s event for user %s: %v", ev.Type, ev.UserID, err)
+		}
+	}()
+}
+
+// Multi fans an event out to several notifiers, returning every failure.
+type Multi []Notifier
+
+func (m Multi) Notify(ev Event) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(ev); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LogNotifier writes events to the service log.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ev Event) error {
+	log.Printf("EVENT: %s user=%s ip=%s agent=%q", ev.Type, ev.UserID, ev.IPAddress, ev.UserAgent)
+	return nil
+}
+
+// MailNotifier tells the user about the event by email.
+type MailNotifier struct {
+	Mailer mailer.Mailer
+}
+
+func (m *MailNotifier) Notify(ev Event) error {
+	if ev.Type != NewDeviceLogin || ev.Email == "" {
+		return nil
+	}
+	return m.Mailer.Send(mailer.Message{
+		To:      ev.Email,
+		Subject: "New sign-in to your LMS account",
+		Body: fmt.Sprintf("Your LMS account was just signed in to from a new device.\n\n"+
+			"Time: %s\nIP address: %s\nBrowser: %s\n\n"+
+			"If this was you, there is nothing to do. If not, change your password and sign out "+
+			"of that session from your account's session list.",
+			ev.At.Format(time.RFC1123), ev.IPAddress, ev.UserAgent),
+	})
+}
+
+// WebhookNotifier POSTs each event as JSON. With a Secret, the X-LMS-Signature header
+// carries the hex HMAC-SHA256 of the body so the receiver can check where it came from.
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(ev Event) error {
+	if n.URL == "" {
+		return errors.New("NOTIFIER_WEBHOOK_URL is not set")
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	req, err := http.NewRequest("POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(body)
+		req.Header.Set("X-LMS-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+// Package federation lets users log in through the university's central OpenID Connect
+// identity provider instead of an LMS password. The auth service acts as an OIDC client
+// (relying party) of that provider.
+package federation
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lms/pkg/jwtauth"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// Policies for a federated login that matches no LMS user.
+const (
+	ProvisionReject = "reject" // refuse the login; an admin has to create the user first
+	ProvisionJIT    = "jit"    // create the user on the spot from the ID token's claims
+)
+
+// LMS fields a federated login can be matched on.
+const (
+	MatchEmail      = "email"
+	MatchRollNo     = "roll_no"
+	MatchEmployeeID = "employee_id"
+)
+
+// ErrNotConfigured is returned when FEDERATION_ISSUER is not set.
+var ErrNotConfigured = errors.New("federated login is not configured")
+
+// Config describes the upstream identity provider and how its users map onto ours.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string // the LMS frontend page the provider sends the browser back to
+
+	// MatchField is the LMS field an unlinked login is matched on, and MatchClaim the ID
+	// token claim carrying its value. Email matches also require email_verified.
+	MatchField string
+	MatchClaim string
+
+	// Provisioning is ProvisionReject or ProvisionJIT. Just-in-time users get JITRole and
+	// a profile filled from NameClaim and RollNoClaim or EmployeeIDClaim.
+	Provisioning    string
+	JITRole         string
+	NameClaim       string
+	RollNoClaim     string
+	EmployeeIDClaim string
+}
+
+// ConfigFromEnv reads the FEDERATION_* variables. It returns nil when FEDERATION_ISSUER
+// is unset, which turns federated login off.
+func ConfigFromEnv() *Config {
+	issuer := os.Getenv("FEDERATION_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	cfg := &Config{
+		Issuer:          strings.TrimSuffix(issuer, "/"),
+		ClientID:        os.Getenv("FEDERATION_CLIENT_ID"),
+		ClientSecret:    os.Getenv("FEDERATION_CLIENT_SECRET"),
+		RedirectURI:     envOr("FEDERATION_REDIRECT_URI", "http://localhost:3000/login/federated/callback"),
+		MatchField:      envOr("FEDERATION_MATCH", MatchEmail),
+		Provisioning:    envOr("FEDERATION_PROVISIONING", ProvisionReject),
+		JITRole:         envOr("FEDERATION_JIT_ROLE", "student"),
+		NameClaim:       envOr("FEDERATION_NAME_CLAIM", "name"),
+		RollNoClaim:     envOr("FEDERATION_ROLL_NO_CLAIM", "roll_no"),
+		EmployeeIDClaim: envOr("FEDERATION_EMPLOYEE_ID_CLAIM", "employee_id"),
+	}
+	cfg.MatchClaim = envOr("FEDERATION_MATCH_CLAIM", cfg.MatchField)
+	return cfg
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Claims are the verified claims of an upstream ID token.
+type Claims map[string]interface{}
+
+// String returns a claim as a string, or "" if it is missing or not a string or number.
+func (c Claims) String(name string) string {
+	switch value := c[name].(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case float64:
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// EmailVerified reports whether the provider vouches for the email claim.
+func (c Claims) EmailVerified() bool {
+	switch verified := c["email_verified"].(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true" // some providers send it as a string
+	}
+	return false
+}
+
+// Provider talks to the upstream identity provider. Its discovery document and signing
+// keys are fetched on first use and cached.
+type Provider struct {
+	Config *Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discoveryDocument
+	keys      *jwtauth.JWKSCache
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider returns a provider for cfg; a nil cfg gives a provider that always
+// returns ErrNotConfigured.
+func NewProvider(cfg *Config) *Provider {
+	return &Provider{Config: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Enabled reports whether federated login is configured.
+func (p *Provider) Enabled() bool {
+	return p.Config != nil
+}
+
+func (p *Provider) discover() (*discoveryDocument, error) {
+	if p.Config == nil {
+		return nil, ErrNotConfigured
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	resp, err := p.client.Get(p.Config.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch identity provider discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identity provider discovery returned an error: %s", resp.Status)
+	}
+	var doc discoveryDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode identity provider discovery document: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.Config.Issuer {
+		return nil, fmt.Errorf("identity provider reports issuer %q, expected %q", doc.Issuer, p.Config.Issuer)
+	}
+	p.discovery = &doc
+	p.keys = jwtauth.NewJWKSCache(doc.JWKSURI)
+	return p.discovery, nil
+}
+
+// AuthCodeURL returns where to send the browser to log in at the provider.
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) (string, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURI},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return doc.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims of the ID token.
+// The token must be signed by the provider, issued by it to us, unexpired, and carry the
+// nonce we sent.
+func (p *Provider) Exchange(code, codeVerifier, nonce string) (Claims, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequest("POST", doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call identity provider token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("identity provider token endpoint returned an error: %s - %s", resp.Status, string(bodyBytes))
+	}
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode identity provider token response: %w", err)
+	}
+	if body.IDToken == "" {
+		return nil, errors.New("identity provider returned no ID token")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(body.IDToken, claims, p.verificationKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(doc.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("invalid ID token: nonce does not match")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("invalid ID token: no subject")
+	}
+	return Claims(claims), nil
+}
+
+func (p *Provider) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	p.mu.Lock()
+	keys := p.keys
+	p.mu.Unlock()
+	return keys.Key(kid)
+}
+
+// pkceChallenge derives the RFC 7636 S256 code challenge for a verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
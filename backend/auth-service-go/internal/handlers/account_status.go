@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/erp"
+	"auth/internal/models"
+	"auth/internal/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lms/pkg/audit"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AccountStatusRequest is the optional body for deactivating or reactivating a user.
+type AccountStatusRequest struct {
+	// Status is "deactivated" (the default) or "suspended"; ignored on reactivation.
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// DeactivateUser suspends or deactivates an account and revokes all of its sessions.
+// Deactivating an instructor who is still the instructor of record for ERP courses
+// succeeds, but the response lists those courses under "warnings" so they can be reassigned.
+func DeactivateUser(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, req, ok := parseAccountStatusRequest(w, r)
+		if !ok {
+			return
+		}
+		if req.Status == "" {
+			req.Status = models.UserDeactivated
+		}
+		if req.Status == models.UserActive {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Use /reactivate to reactivate a user"})
+			return
+		}
+		if callerID, _ := r.Context().Value(middleware.UserIDContextKey).(string); callerID == userID.String() {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "You cannot deactivate your own account"})
+			return
+		}
+
+		user, ok := setAccountStatus(w, r, db, auditLog, userID, req.Status, req.Reason)
+		if !ok {
+			return
+		}
+
+		resp := util.H{"message": "User " + user.Status, "user": user}
+		if user.Role == "instructor" {
+			courses, err := erp.InstructorCourses(r.Header.Get("Authorization"), user.ID.String())
+			if err != nil {
+				log.Printf("ERROR: Could not check courses for deactivated instructor %s: %v", user.ID, err)
+				resp["warnings"] = []string{"Could not check the ERP service for courses this instructor still teaches"}
+			} else if len(courses) > 0 {
+				warnings := make([]string, 0, len(courses))
+				for _, course := range courses {
+					warnings = append(warnings, fmt.Sprintf("%s (%s) still lists this user as its instructor", course.CourseCode, course.Name))
+				}
+				resp["warnings"] = warnings
+				resp["coursesToReassign"] = courses
+			}
+		}
+		util.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+// ReactivateUser returns a suspended or deactivated account to active. The user has to
+// log in again; sessions revoked at deactivation are not restored.
+func ReactivateUser(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, req, ok := parseAccountStatusRequest(w, r)
+		if !ok {
+			return
+		}
+		user, ok := setAccountStatus(w, r, db, auditLog, userID, models.UserActive, req.Reason)
+		if !ok {
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "User reactivated", "user": user})
+	}
+}
+
+func parseAccountStatusRequest(w http.ResponseWriter, r *http.Request) (uuid.UUID, AccountStatusRequest, bool) {
+	var req AccountStatusRequest
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+		return uuid.Nil, req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+		return uuid.Nil, req, false
+	}
+	return userID, req, true
+}
+
+// accountStatus is what the audit log records for a status change.
+type accountStatus struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+func setAccountStatus(w http.ResponseWriter, r *http.Request, db *gorm.DB, auditLog *audit.Logger, userID uuid.UUID, status, reason string) (*models.User, bool) {
+	action := "user.deactivate"
+	if status == models.UserActive {
+		action = "user.reactivate"
+	}
+
+	var user *models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var before models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "status", "status_reason").First(&before, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		var err error
+		if user, err = database.SetUserStatus(tx, userID, status, reason); err != nil {
+			return err
+		}
+		return recordAudit(tx, auditLog, r, action, "user", userID.String(),
+			accountStatus{before.Status, before.StatusReason}, accountStatus{user.Status, user.StatusReason})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+		case errors.Is(err, database.ErrInvalidAccountStatus):
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": err.Error()})
+		default:
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to update account status"})
+		}
+		return nil, false
+	}
+	return user, true
+}
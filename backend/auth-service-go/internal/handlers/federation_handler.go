@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/events"
+	"auth/internal/federation"
+	"auth/internal/models"
+	"auth/internal/util"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lms/pkg/audit"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// FederatedCallbackRequest is what the identity provider sent back to the LMS frontend's
+// callback page, which posts it on to us.
+type FederatedCallbackRequest struct {
+	Code             string `json:"code"`
+	State            string `json:"state"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// federatedStateCookie holds the state of the federated login this browser started, so
+// the callback only completes logins begun in the same browser. Without it, an attacker
+// could have a victim's browser finish a login started (and authorized) by the attacker.
+const federatedStateCookie = "lms_federated_state"
+
+// StartFederatedLogin sends the browser to the university identity provider to log in.
+// The provider returns it to FEDERATION_REDIRECT_URI on the LMS frontend.
+func StartFederatedLogin(db *gorm.DB, provider *federation.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !provider.Enabled() {
+			util.WriteJSON(w, http.StatusNotFound, util.H{"error": "Federated login is not configured"})
+			return
+		}
+		state, nonce, verifier, err := database.StartFederatedLogin(db)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to start federated login"})
+			return
+		}
+		authURL, err := provider.AuthCodeURL(state, nonce, verifier)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusBadGateway, util.H{"error": "Identity provider is unavailable"})
+			return
+		}
+		setFederatedStateCookie(w, state, int(database.FederatedLoginTTL.Seconds()))
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// FederatedCallback completes a login at the identity provider. The user is found by an
+// existing link, or else matched on the configured field (email, roll number or employee
+// ID) and linked. Users who match no one are created or refused according to
+// FEDERATION_PROVISIONING. The response is the same as POST /login, so local MFA rules
+// still apply. The frontend must post with credentials, since the state has to match the
+// cookie StartFederatedLogin set in this browser.
+func FederatedCallback(db *gorm.DB, provider *federation.Provider, auditLog *audit.Logger, notifier events.Notifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !provider.Enabled() {
+			util.WriteJSON(w, http.StatusNotFound, util.H{"error": "Federated login is not configured"})
+			return
+		}
+		var req FederatedCallbackRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.State == "" {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		cookie, err := r.Cookie(federatedStateCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(req.State)) != 1 {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Login was not started in this browser; please start again"})
+			return
+		}
+		setFederatedStateCookie(w, "", -1)
+
+		login, err := database.ConsumeFederatedLogin(db, req.State)
+		if err != nil {
+			if errors.Is(err, database.ErrInvalidFederatedState) {
+				util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Login expired or was already used; please start again"})
+				return
+			}
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to login"})
+			return
+		}
+		if req.Error != "" {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Identity provider refused the login", "reason": req.Error, "description": req.ErrorDescription})
+			return
+		}
+
+		claims, err := provider.Exchange(req.Code, login.CodeVerifier, login.Nonce)
+		if err != nil {
+			log.Printf("WARN: Federated login failed: %v", err)
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Could not verify the identity provider's response"})
+			return
+		}
+		cfg := provider.Config
+		subject, email := claims.String("sub"), claims.String("email")
+
+		recordAttempt := func(userID *uuid.UUID, outcome string) {
+			recordLoginAttempt(db, r, userID, email, outcome)
+		}
+
+		user, err := database.FindFederatedUser(db, cfg.Issuer, subject)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			user, err = matchFederatedUser(db, cfg, claims)
+			if err == nil {
+				err = db.Transaction(func(tx *gorm.DB) error {
+					if err := database.LinkFederatedIdentity(tx, user.ID, cfg.Issuer, subject, email); err != nil {
+						return err
+					}
+					return recordAudit(tx, auditLog, r, "user.link_identity", "user", user.ID.String(), nil,
+						util.H{"issuer": cfg.Issuer, "subject": subject, "matchedOn": cfg.MatchField})
+				})
+			} else if errors.Is(err, gorm.ErrRecordNotFound) && cfg.Provisioning == federation.ProvisionJIT {
+				err = db.Transaction(func(tx *gorm.DB) error {
+					var err error
+					if user, err = provisionFederatedUser(tx, cfg, claims); err != nil {
+						return err
+					}
+					return recordAudit(tx, auditLog, r, "user.provision", "user", user.ID.String(), nil,
+						util.H{"email": personalDigest(user.Email), "role": user.Role, "issuer": cfg.Issuer, "subject": subject})
+				})
+			}
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				recordAttempt(nil, models.LoginNoAccount)
+				util.WriteJSON(w, http.StatusForbidden, util.H{"error": "No LMS account matches your university login; ask an administrator to create one"})
+			case errors.Is(err, database.ErrIdentityLinkedElsewhere):
+				recordAttempt(nil, models.LoginNoAccount)
+				util.WriteJSON(w, http.StatusConflict, util.H{"error": err.Error()})
+			case errors.Is(err, database.ErrInvalidProfile), errors.Is(err, database.ErrEmailTaken),
+				errors.Is(err, database.ErrRollNoTaken), errors.Is(err, database.ErrEmployeeIDTaken):
+				recordAttempt(nil, models.LoginNoAccount)
+				util.WriteJSON(w, http.StatusForbidden, util.H{"error": "Could not create your LMS account automatically: " + err.Error()})
+			default:
+				log.Printf("ERROR: %v", err)
+				util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to login"})
+			}
+			return
+		}
+
+		if !user.IsActive() {
+			recordAttempt(&user.ID, models.LoginAccountInactive)
+			writeInactiveAccount(w, user)
+			return
+		}
+
+		resp, err := beginLogin(db, notifier, r, user)
+		if err != nil {
+			log.Printf("ERROR: Could not complete federated login: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to generate token"})
+			return
+		}
+		if _, challenged := resp["mfaToken"]; challenged {
+			recordAttempt(&user.ID, models.LoginMFAChallenged)
+		} else {
+			recordAttempt(&user.ID, models.LoginSucceeded)
+		}
+		util.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+// setFederatedStateCookie sets or, with a negative maxAge, clears the state cookie. It is
+// only sent to the federated login routes, and SameSite keeps other sites from posting it.
+func setFederatedStateCookie(w http.ResponseWriter, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     federatedStateCookie,
+		Value:    state,
+		Path:     "/login/federated",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// matchFederatedUser finds the LMS user for an unlinked provider account. Emails are only
+// trusted when the provider says it verified them.
+func matchFederatedUser(db *gorm.DB, cfg *federation.Config, claims federation.Claims) (*models.User, error) {
+	if cfg.MatchField == federation.MatchEmail && !claims.EmailVerified() {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return database.MatchUser(db, cfg.MatchField, claims.String(cfg.MatchClaim))
+}
+
+// provisionFederatedUser creates a user with FEDERATION_JIT_ROLE from the ID token's claims.
+func provisionFederatedUser(db *gorm.DB, cfg *federation.Config, claims federation.Claims) (*models.User, error) {
+	email := claims.String("email")
+	if email == "" || !claims.EmailVerified() {
+		return nil, fmt.Errorf("%w: the identity provider did not supply a verified email", database.ErrInvalidProfile)
+	}
+	if cfg.JITRole == "ta" {
+		return nil, database.ErrUseTAAssignments
+	}
+	if exists, err := database.RoleExists(db, cfg.JITRole); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("%w: role %q does not exist", database.ErrInvalidProfile, cfg.JITRole)
+	}
+
+	name := claims.String(cfg.NameClaim)
+	if name == "" {
+		name = email
+	}
+	var profile interface{}
+	switch models.ProfileKind(cfg.JITRole) {
+	case "student":
+		profile = &models.StudentProfile{FullName: name, RollNo: claims.String(cfg.RollNoClaim)}
+	case "instructor":
+		profile = &models.InstructorProfile{FullName: name, EmployeeID: claims.String(cfg.EmployeeIDClaim)}
+	default:
+		profile = &models.AdminProfile{FullName: name, EmployeeID: claims.String(cfg.EmployeeIDClaim)}
+	}
+	return database.ProvisionFederatedUser(db, email, cfg.JITRole, profile, cfg.Issuer, claims.String("sub"))
+}
+
+// ListFederatedIdentities returns the identity provider accounts linked to a user.
+func ListFederatedIdentities(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+		identities, err := database.ListFederatedIdentities(db, userID)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to list linked identities"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"identities": identities})
+	}
+}
+
+// UnlinkFederatedIdentity removes a link made by mistake. The next federated login with
+// that provider account is matched again from scratch.
+func UnlinkFederatedIdentity(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+		identityID, err := uuid.Parse(r.PathValue("identityId"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid identity ID format"})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			identity, err := database.UnlinkFederatedIdentity(tx, userID, identityID)
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "user.unlink_identity", "user", userID.String(),
+				util.H{"issuer": identity.Issuer, "subject": identity.Subject}, nil)
+		})
+		if err != nil {
+			if errors.Is(err, database.ErrIdentityNotFound) {
+				util.WriteJSON(w, http.StatusNotFound, util.H{"error": "Linked identity not found"})
+				return
+			}
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to unlink identity"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Identity unlinked"})
+	}
+}
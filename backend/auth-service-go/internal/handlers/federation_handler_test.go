@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"auth/internal/federation"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The state cookie is checked before the database is touched, so these run without one.
+func TestFederatedCallbackRequiresStateCookie(t *testing.T) {
+	provider := federation.NewProvider(&federation.Config{Issuer: "http://idp.invalid"})
+	callback := FederatedCallback(nil, provider, nil, nil)
+
+	for name, cookie := range map[string]*http.Cookie{
+		"no cookie":      nil,
+		"other state":    {Name: federatedStateCookie, Value: "state-2"},
+		"empty cookie":   {Name: federatedStateCookie, Value: ""},
+		"unrelated name": {Name: "state", Value: "state-1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/login/federated/callback",
+				strings.NewReader(`{"code":"code-1","state":"state-1"}`))
+			if cookie != nil {
+				req.AddCookie(cookie)
+			}
+			rec := httptest.NewRecorder()
+			callback(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			if !strings.Contains(rec.Body.String(), "not started in this browser") {
+				t.Errorf("body = %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestSetFederatedStateCookie(t *testing.T) {
+	rec := httptest.NewRecorder()
+	setFederatedStateCookie(rec, "state-1", 600)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	c := cookies[0]
+	if c.Name != federatedStateCookie || c.Value != "state-1" || c.MaxAge != 600 {
+		t.Errorf("cookie = %+v", c)
+	}
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.Path != "/login/federated" {
+		t.Errorf("cookie attributes = %+v, want HttpOnly, Secure, SameSite=Lax on /login/federated", c)
+	}
+}
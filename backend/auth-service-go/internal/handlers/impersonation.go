@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"auth/internal/util"
+	"errors"
+	"fmt"
+	"lms/pkg/audit"
+	"lms/pkg/middleware"
+	"lms/pkg/permissions"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ImpersonateUser issues a short-lived, read-only access token that lets an admin see
+// the system as another user. The token's "act" claim names the admin, every service
+// refuses writes made with it, and starting and ending it are audited. The lifetime is
+// IMPERSONATION_TTL (default 10 minutes).
+//
+// Users who can manage roles or impersonate others cannot themselves be impersonated, and
+// every permission of the target must also be granted to the admin.
+func ImpersonateUser(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		targetID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+		actorID, err := uuid.Parse(fmt.Sprint(r.Context().Value(middleware.UserIDContextKey)))
+		if err != nil {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Could not identify user from context"})
+			return
+		}
+		if actorID == targetID {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "You cannot impersonate yourself"})
+			return
+		}
+
+		var target models.User
+		if err := db.First(&target, "id = ?", targetID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+				return
+			}
+			log.Printf("ERROR: Failed to fetch user: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to start impersonation"})
+			return
+		}
+		if !target.IsActive() {
+			writeInactiveAccount(w, &target)
+			return
+		}
+
+		granted, err := database.PermissionsForRole(db, target.Role)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to start impersonation"})
+			return
+		}
+		if permissions.Grants(granted, permissions.RolesManage) || permissions.Grants(granted, permissions.Impersonate) {
+			util.WriteJSON(w, http.StatusForbidden, util.H{"error": "Administrators cannot be impersonated"})
+			return
+		}
+		// Impersonating must never reach further than the actor's own permissions
+		actorGrants, _ := r.Context().Value(middleware.PermissionsContextKey).([]string)
+		for _, permission := range granted {
+			if !permissions.Grants(actorGrants, permission) {
+				util.WriteJSON(w, http.StatusForbidden, util.H{"error": "You cannot impersonate a user with permissions you do not have: " + permission})
+				return
+			}
+		}
+
+		ttl := util.EnvDuration("IMPERSONATION_TTL", 10*time.Minute)
+		var session *models.Session
+		var token string
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if session, err = database.CreateImpersonationSession(tx, target.ID, actorID, ttl); err != nil {
+				return err
+			}
+			token, err = oauth.GenerateImpersonationToken(target.ID.String(), target.Role, session.ID.String(), granted, actorID.String(), ttl)
+			if err != nil {
+				return fmt.Errorf("could not generate impersonation token: %w", err)
+			}
+			return recordAudit(tx, auditLog, r, "user.impersonate.start", "user", target.ID.String(), nil,
+				util.H{"sessionId": session.ID, "expiresAt": session.ExpiresAt})
+		})
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to start impersonation"})
+			return
+		}
+
+		util.WriteJSON(w, http.StatusOK, util.H{
+			"token":     token,
+			"expiresIn": int(ttl.Seconds()),
+			"sessionId": session.ID,
+			"readOnly":  true,
+			"user":      util.H{"id": target.ID, "email": target.Email, "role": target.Role},
+		})
+	}
+}
+
+// EndImpersonation revokes an impersonation session before it expires. It is called
+// with the admin's own token, since impersonation tokens cannot make changes.
+func EndImpersonation(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionID, err := uuid.Parse(r.PathValue("sessionId"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid session ID format"})
+			return
+		}
+
+		var session models.Session
+		if err := db.First(&session, "id = ? AND impersonator_id IS NOT NULL", sessionID).Error; err != nil {
+			util.WriteJSON(w, http.StatusNotFound, util.H{"error": "Impersonation session not found"})
+			return
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := database.RevokeSession(tx, session.ID); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "user.impersonate.end", "user", session.UserID.String(), nil, util.H{"sessionId": session.ID})
+		})
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to end impersonation"})
+			return
+		}
+
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Impersonation ended"})
+	}
+}
@@ -1,15 +1,20 @@
 package handlers
 
 import (
+	"auth/internal/database"
+	"auth/internal/events"
 	"auth/internal/models"
-	"auth/internal/oauth"
+	"auth/internal/password"
 	"auth/internal/util"
 	"encoding/json"
 	"errors"
+	"lms/pkg/audit"
+	"lms/pkg/middleware"
 	"log" // <-- Make sure log is imported
 	"net/http"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -19,8 +24,15 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
-// Login handles user authentication and issues a JWT upon success.
-func Login(db *gorm.DB) http.HandlerFunc {
+// Login handles user authentication and issues an access token and refresh token upon success.
+// Users with MFA enabled (or required by their role) get an MFA challenge token instead.
+// Consecutive wrong passwords progressively lock the account, suspended and deactivated
+// accounts are refused, and every attempt is recorded. Logins from a device the user has
+// not used before are published to notifier. A password hash weaker than the current
+// hashing policy is replaced on a successful login.
+func Login(db *gorm.DB, notifier events.Notifier) http.HandlerFunc {
+	policy := database.LockoutPolicyFromEnv()
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req LoginRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -28,9 +40,14 @@ func Login(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		recordAttempt := func(userID *uuid.UUID, outcome string) {
+			recordLoginAttempt(db, r, userID, req.Email, outcome)
+		}
+
 		var user models.User
 		if err := db.Where("email = ?", req.Email).First(&user).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
+				recordAttempt(nil, models.LoginBadCredentials)
 				util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Invalid credentials"})
 				return
 			}
@@ -38,22 +55,115 @@ func Login(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
-		if err != nil {
+		if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+			recordAttempt(&user.ID, models.LoginAccountLocked)
+			middleware.WriteTooManyRequests(w, time.Until(*user.LockedUntil))
+			return
+		}
+
+		ok, rehash, err := password.Verify(user.PasswordHash, req.Password)
+		if err != nil && !errors.Is(err, password.ErrUnknownHash) {
+			log.Printf("ERROR: Could not verify password: %v", err)
+		}
+		if !ok {
+			recordAttempt(&user.ID, models.LoginBadCredentials)
+			lockedUntil, err := database.RecordLoginFailure(db, user.ID, policy)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+			}
+			if lockedUntil != nil {
+				middleware.WriteTooManyRequests(w, time.Until(*lockedUntil))
+				return
+			}
 			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Invalid credentials"})
 			return
 		}
 
-		// If login is successful, generate a JWT
-		token, err := oauth.GenerateToken(user.ID.String(), user.Role)
+		if rehash {
+			// The plaintext is only available now, so this is the chance to upgrade
+			if newHash, err := password.Hash(req.Password); err != nil {
+				log.Printf("ERROR: %v", err)
+			} else if err := database.RehashPassword(db, user.ID, user.PasswordHash, newHash); err != nil {
+				log.Printf("ERROR: %v", err)
+			}
+		}
+
+		// Only tell the caller the account is inactive once they have proven they own it
+		if !user.IsActive() {
+			recordAttempt(&user.ID, models.LoginAccountInactive)
+			writeInactiveAccount(w, &user)
+			return
+		}
+
+		// If the password is right, either start a session or ask for the second factor
+		resp, err := beginLogin(db, notifier, r, &user)
 		if err != nil {
 			// --- THIS IS THE NEW LOGGING ---
 			// Print the specific, detailed error to the server console for debugging.
-			log.Printf("ERROR: Could not generate JWT: %v", err)
+			log.Printf("ERROR: Could not complete login: %v", err)
 			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to generate token"})
 			return
 		}
 
-		util.WriteJSON(w, http.StatusOK, util.H{"message": "Login successful", "token": token})
+		// Failures are only cleared once the second factor is also right, so alternating
+		// correct passwords with guessed codes cannot dodge the lockout
+		if _, verifying := resp["mfaRequired"]; !verifying {
+			if err := database.ResetLoginFailures(db, user.ID); err != nil {
+				log.Printf("ERROR: %v", err)
+			}
+		}
+		if _, challenged := resp["mfaToken"]; challenged {
+			recordAttempt(&user.ID, models.LoginMFAChallenged)
+		} else {
+			recordAttempt(&user.ID, models.LoginSucceeded)
+		}
+		util.WriteJSON(w, http.StatusOK, resp)
 	}
 }
+
+// UnlockUser lets an admin lift an account lockout before it expires.
+func UnlockUser(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := database.UnlockUser(tx, userID); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "user.unlock", "user", userID.String(), nil, nil)
+		})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+				return
+			}
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to unlock user"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "User unlocked successfully"})
+	}
+}
+
+// recordLoginAttempt stores one login attempt from the request's client. Failures are
+// logged; they never block the login itself.
+func recordLoginAttempt(db *gorm.DB, r *http.Request, userID *uuid.UUID, email, outcome string) {
+	attempt := models.LoginAttempt{
+		UserID:    userID,
+		Email:     email,
+		IPAddress: middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Outcome:   outcome,
+	}
+	if err := database.RecordLoginAttempt(db, &attempt); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+}
+
+func writeInactiveAccount(w http.ResponseWriter, user *models.User) {
+	util.WriteJSON(w, http.StatusForbidden, util.H{"error": "Account is " + user.Status, "status": user.Status})
+}
@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/events"
+	"auth/internal/mfa"
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"auth/internal/util"
+	"encoding/json"
+	"errors"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MFACodeRequest carries a TOTP code or, instead, a recovery code.
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode,omitempty"`
+}
+
+// MFALoginRequest is the second login step: the challenge token from POST /login plus a code.
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfaToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode,omitempty"`
+}
+
+// beginLogin decides what a user gets after a correct password: a session, a challenge
+// for their second factor, or a challenge to enroll one first when their role requires it.
+func beginLogin(db *gorm.DB, notifier events.Notifier, r *http.Request, user *models.User) (util.H, error) {
+	enabled, err := database.IsMFAEnabled(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		challenge, err := oauth.GenerateMFAChallenge(user.ID.String(), oauth.ChallengeVerifyMFA)
+		if err != nil {
+			return nil, err
+		}
+		return util.H{"message": "MFA code required", "mfaRequired": true, "mfaToken": challenge}, nil
+	}
+
+	if mfa.RequiredForRole(user.Role) {
+		challenge, err := oauth.GenerateMFAChallenge(user.ID.String(), oauth.ChallengeEnrollMFA)
+		if err != nil {
+			return nil, err
+		}
+		return util.H{"message": "MFA enrollment required", "mfaEnrollmentRequired": true, "mfaToken": challenge}, nil
+	}
+
+	resp, err := startSession(db, notifier, r, user)
+	if err != nil {
+		return nil, err
+	}
+	resp["message"] = "Login successful"
+	return resp, nil
+}
+
+// LoginMFA completes a login for a user with MFA enabled. Wrong codes count towards the
+// same account lockout as wrong passwords, and a locked account cannot use its challenge.
+func LoginMFA(db *gorm.DB, notifier events.Notifier) http.HandlerFunc {
+	policy := database.LockoutPolicyFromEnv()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MFALoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+
+		user, ok := userFromChallenge(w, db, req.MFAToken, oauth.ChallengeVerifyMFA)
+		if !ok || refuseLockedAccount(w, db, r, user) {
+			return
+		}
+
+		if err := database.VerifySecondFactor(db, user.ID, req.Code, req.RecoveryCode); err != nil {
+			writeMFALoginError(w, db, r, user, policy, err)
+			return
+		}
+		if err := database.ResetLoginFailures(db, user.ID); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+
+		resp, err := startSession(db, notifier, r, user)
+		if err != nil {
+			log.Printf("ERROR: Could not start session: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to generate token"})
+			return
+		}
+		recordLoginAttempt(db, r, &user.ID, user.Email, models.LoginSucceeded)
+		resp["message"] = "Login successful"
+		util.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+// refuseLockedAccount answers 429 and returns true if the account behind an MFA challenge
+// is locked, so a challenge issued before the lockout cannot finish the login.
+func refuseLockedAccount(w http.ResponseWriter, db *gorm.DB, r *http.Request, user *models.User) bool {
+	if user.LockedUntil == nil || !time.Now().Before(*user.LockedUntil) {
+		return false
+	}
+	recordLoginAttempt(db, r, &user.ID, user.Email, models.LoginAccountLocked)
+	middleware.WriteTooManyRequests(w, time.Until(*user.LockedUntil))
+	return true
+}
+
+// writeMFALoginError answers a failed second login step. A wrong code is recorded and
+// counted towards the lockout like a wrong password.
+func writeMFALoginError(w http.ResponseWriter, db *gorm.DB, r *http.Request, user *models.User, policy database.LockoutPolicy, err error) {
+	if errors.Is(err, database.ErrInvalidMFACode) {
+		recordLoginAttempt(db, r, &user.ID, user.Email, models.LoginMFAFailed)
+		lockedUntil, err := database.RecordLoginFailure(db, user.ID, policy)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+		if lockedUntil != nil {
+			middleware.WriteTooManyRequests(w, time.Until(*lockedUntil))
+			return
+		}
+	}
+	writeMFAError(w, err)
+}
+
+// LoginEnrollTOTP starts enrollment for a user whose role requires MFA but who has none yet.
+func LoginEnrollTOTP(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MFALoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+
+		user, ok := userFromChallenge(w, db, req.MFAToken, oauth.ChallengeEnrollMFA)
+		if !ok || refuseLockedAccount(w, db, r, user) {
+			return
+		}
+		writeTOTPEnrollment(w, db, user)
+	}
+}
+
+// LoginConfirmTOTP confirms enrollment started by LoginEnrollTOTP and finishes the login.
+// Wrong codes count towards the account lockout, as in LoginMFA.
+func LoginConfirmTOTP(db *gorm.DB, notifier events.Notifier) http.HandlerFunc {
+	policy := database.LockoutPolicyFromEnv()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MFALoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+
+		user, ok := userFromChallenge(w, db, req.MFAToken, oauth.ChallengeEnrollMFA)
+		if !ok || refuseLockedAccount(w, db, r, user) {
+			return
+		}
+
+		codes, err := database.ConfirmTOTPEnrollment(db, user.ID, req.Code)
+		if err != nil {
+			writeMFALoginError(w, db, r, user, policy, err)
+			return
+		}
+		if err := database.ResetLoginFailures(db, user.ID); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+
+		resp, err := startSession(db, notifier, r, user)
+		if err != nil {
+			log.Printf("ERROR: Could not start session: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to generate token"})
+			return
+		}
+		recordLoginAttempt(db, r, &user.ID, user.Email, models.LoginSucceeded)
+		resp["message"] = "MFA enabled and login successful"
+		resp["recoveryCodes"] = codes
+		util.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+// EnrollTOTP starts TOTP enrollment for the logged-in user.
+func EnrollTOTP(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r, db)
+		if !ok {
+			return
+		}
+		writeTOTPEnrollment(w, db, user)
+	}
+}
+
+// ConfirmTOTP enables TOTP for the logged-in user and returns their recovery codes.
+func ConfirmTOTP(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r, db)
+		if !ok {
+			return
+		}
+
+		var req MFACodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+
+		codes, err := database.ConfirmTOTPEnrollment(db, user.ID, req.Code)
+		if err != nil {
+			writeMFAError(w, err)
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "MFA enabled", "recoveryCodes": codes})
+	}
+}
+
+// DisableTOTP turns MFA off for the logged-in user. It needs a current code, and is
+// refused for roles where MFA is mandatory.
+func DisableTOTP(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r, db)
+		if !ok {
+			return
+		}
+		if mfa.RequiredForRole(user.Role) {
+			util.WriteJSON(w, http.StatusForbidden, util.H{"error": "MFA is mandatory for your role"})
+			return
+		}
+
+		var req MFACodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		if err := database.VerifySecondFactor(db, user.ID, req.Code, req.RecoveryCode); err != nil {
+			writeMFAError(w, err)
+			return
+		}
+
+		if err := database.DisableMFA(db, user.ID); err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to disable MFA"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "MFA disabled"})
+	}
+}
+
+// RegenerateRecoveryCodes replaces the logged-in user's recovery codes after checking a current code.
+func RegenerateRecoveryCodes(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r, db)
+		if !ok {
+			return
+		}
+
+		var req MFACodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		if err := database.VerifySecondFactor(db, user.ID, req.Code, ""); err != nil {
+			writeMFAError(w, err)
+			return
+		}
+
+		codes, err := database.RegenerateRecoveryCodes(db, user.ID)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to regenerate recovery codes"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"recoveryCodes": codes})
+	}
+}
+
+func writeTOTPEnrollment(w http.ResponseWriter, db *gorm.DB, user *models.User) {
+	secret, err := database.BeginTOTPEnrollment(db, user.ID)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "LMS"
+	}
+	util.WriteJSON(w, http.StatusOK, util.H{
+		"secret":     secret,
+		"otpauthUri": mfa.ProvisioningURI(issuer, user.Email, secret),
+		"message":    "Scan the code with your authenticator app, then confirm with a generated code",
+	})
+}
+
+// userFromChallenge validates an MFA challenge token and loads its user, writing the error response on failure.
+func userFromChallenge(w http.ResponseWriter, db *gorm.DB, challenge, purpose string) (*models.User, bool) {
+	userID, err := oauth.ParseMFAChallenge(challenge, purpose)
+	if err != nil {
+		util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Invalid or expired MFA token"})
+		return nil, false
+	}
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Invalid or expired MFA token"})
+		return nil, false
+	}
+	if !user.IsActive() {
+		writeInactiveAccount(w, &user)
+		return nil, false
+	}
+	return &user, true
+}
+
+// currentUser loads the user identified by the access token, writing the error response on failure.
+func currentUser(w http.ResponseWriter, r *http.Request, db *gorm.DB) (*models.User, bool) {
+	userIDStr, _ := r.Context().Value(middleware.UserIDContextKey).(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Could not identify user from context"})
+		return nil, false
+	}
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
+
+func writeMFAError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrInvalidMFACode):
+		util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Invalid MFA code"})
+	case errors.Is(err, database.ErrMFANotEnrolled):
+		util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "MFA enrollment has not been started"})
+	case errors.Is(err, database.ErrMFAAlreadyEnabled):
+		util.WriteJSON(w, http.StatusConflict, util.H{"error": "MFA is already enabled"})
+	default:
+		log.Printf("ERROR: MFA operation failed: %v", err)
+		util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to process MFA request"})
+	}
+}
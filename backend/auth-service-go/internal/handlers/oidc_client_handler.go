@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/models"
+	"auth/internal/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lms/pkg/audit"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OIDCClientRequest is the body for registering a single sign-on client. Confidential
+// clients (server-side apps) get a secret; public clients (SPAs, native apps) do not.
+type OIDCClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	FirstParty   bool     `json:"firstParty"`
+	Confidential bool     `json:"confidential"`
+}
+
+// OIDCClientUpdate is the body for PATCH /admin/oidc/clients/{id}; omitted fields are unchanged.
+type OIDCClientUpdate struct {
+	Name         *string  `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	FirstParty   *bool    `json:"firstParty"`
+	Disabled     *bool    `json:"disabled"`
+}
+
+// oidcClientRecord is what the audit log records for a client.
+type oidcClientRecord struct {
+	Name         string `json:"name"`
+	RedirectURIs string `json:"redirectUris"`
+	FirstParty   bool   `json:"firstParty"`
+	Disabled     bool   `json:"disabled"`
+	Confidential bool   `json:"confidential"`
+}
+
+func oidcClientAuditRecord(client *models.OIDCClient) oidcClientRecord {
+	return oidcClientRecord{client.Name, client.RedirectURIs, client.FirstParty, client.Disabled, client.Confidential()}
+}
+
+// CreateOIDCClient registers an application for single sign-on. A confidential client's
+// secret is only ever shown in this response.
+func CreateOIDCClient(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req OIDCClientRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		createdBy, err := uuid.Parse(fmt.Sprint(r.Context().Value(middleware.UserIDContextKey)))
+		if err != nil {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Could not identify user from context"})
+			return
+		}
+
+		var client *models.OIDCClient
+		var secret string
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if client, secret, err = database.CreateOIDCClient(tx, req.Name, req.RedirectURIs, req.FirstParty, req.Confidential, createdBy); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "oidc_client.create", "oidc_client", client.ClientID, nil, oidcClientAuditRecord(client))
+		})
+		if err != nil {
+			writeOIDCClientError(w, err)
+			return
+		}
+
+		resp := util.H{"message": "Client registered", "client": client}
+		if secret != "" {
+			resp["clientSecret"] = secret
+			resp["message"] = "Client registered. Store the secret now; it cannot be shown again."
+		}
+		util.WriteJSON(w, http.StatusCreated, resp)
+	}
+}
+
+// ListOIDCClients returns every registered client. Secrets are never included.
+func ListOIDCClients(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clients, err := database.ListOIDCClients(db)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to list clients"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"clients": clients})
+	}
+}
+
+// UpdateOIDCClient changes a client's name, redirect URIs or first-party status, or disables it.
+func UpdateOIDCClient(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := parseOIDCClientID(w, r)
+		if !ok {
+			return
+		}
+		var req OIDCClientUpdate
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+
+		var client *models.OIDCClient
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var before models.OIDCClient
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&before, "id = ?", id).Error; err != nil {
+				return err
+			}
+			var err error
+			if client, err = database.UpdateOIDCClient(tx, id, req.Name, req.RedirectURIs, req.FirstParty, req.Disabled); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "oidc_client.update", "oidc_client", client.ClientID,
+				oidcClientAuditRecord(&before), oidcClientAuditRecord(client))
+		})
+		if err != nil {
+			writeOIDCClientError(w, err)
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Client updated", "client": client})
+	}
+}
+
+// RotateOIDCClientSecret issues a new secret; the old one stops working immediately.
+func RotateOIDCClientSecret(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := parseOIDCClientID(w, r)
+		if !ok {
+			return
+		}
+		var client *models.OIDCClient
+		var secret string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if client, secret, err = database.RotateOIDCClientSecret(tx, id); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "oidc_client.rotate_secret", "oidc_client", client.ClientID, nil, nil)
+		})
+		if err != nil {
+			writeOIDCClientError(w, err)
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{
+			"message":      "Secret rotated. Store it now; it cannot be shown again.",
+			"clientId":     client.ClientID,
+			"clientSecret": secret,
+		})
+	}
+}
+
+// DeleteOIDCClient removes a client along with users' consents to it.
+func DeleteOIDCClient(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := parseOIDCClientID(w, r)
+		if !ok {
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			client, err := database.DeleteOIDCClient(tx, id)
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "oidc_client.delete", "oidc_client", client.ClientID, oidcClientAuditRecord(client), nil)
+		})
+		if err != nil {
+			writeOIDCClientError(w, err)
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Client deleted"})
+	}
+}
+
+func parseOIDCClientID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid client ID format"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func writeOIDCClientError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		util.WriteJSON(w, http.StatusNotFound, util.H{"error": "Client not found"})
+	case errors.Is(err, database.ErrInvalidClientName), errors.Is(err, database.ErrInvalidRedirectURI):
+		util.WriteJSON(w, http.StatusBadRequest, util.H{"error": err.Error()})
+	default:
+		log.Printf("ERROR: %v", err)
+		util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to update client"})
+	}
+}
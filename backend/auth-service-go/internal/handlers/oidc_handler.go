@@ -0,0 +1,420 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"auth/internal/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AuthorizeRequest is an OpenID Connect authentication request. The browser arrives
+// with these as query parameters at GET /oauth/authorize; once the user is logged in,
+// the LMS frontend posts them, with Consent filled in if the user was asked.
+type AuthorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	// Consent is "approve" or "deny" after the user has been shown the consent screen.
+	Consent string `json:"consent"`
+}
+
+// authorizeError is an error that goes back to the client on its redirect URI.
+type authorizeError struct {
+	code        string
+	description string
+}
+
+// OpenIDConfiguration publishes the OpenID Connect discovery document.
+func OpenIDConfiguration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ks, err := oauth.CurrentKeySet()
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Signing keys unavailable"})
+			return
+		}
+		issuer := oauth.Issuer()
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		util.WriteJSON(w, http.StatusOK, util.H{
+			"issuer":                                issuer,
+			"authorization_endpoint":                issuer + "/oauth/authorize",
+			"token_endpoint":                        issuer + "/oauth/token",
+			"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+			"jwks_uri":                              issuer + "/.well-known/jwks.json",
+			"response_types_supported":              []string{"code"},
+			"grant_types_supported":                 []string{"authorization_code", "client_credentials"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{ks.Active.Method.Alg()},
+			"scopes_supported":                      oauth.SupportedOIDCScopes,
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+			"code_challenge_methods_supported":      []string{"S256"},
+			"claims_supported": []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "sid",
+				"role", "name", "email", "email_verified", "roll_no", "branch", "year_of_admission", "is_ta",
+				"employee_id", "department", "title", "job_title"},
+		})
+	}
+}
+
+// StartAuthorization is where a client sends the user's browser. The auth service has no
+// login page of its own, so after checking the client it redirects to the LMS frontend's
+// login page (OIDC_LOGIN_URL), passing the original request along as "authorize". The
+// frontend logs the user in and then calls Authorize with it.
+func StartAuthorization(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		req := AuthorizeRequest{
+			ResponseType:        query.Get("response_type"),
+			ClientID:            query.Get("client_id"),
+			RedirectURI:         query.Get("redirect_uri"),
+			Scope:               query.Get("scope"),
+			State:               query.Get("state"),
+			Nonce:               query.Get("nonce"),
+			CodeChallenge:       query.Get("code_challenge"),
+			CodeChallengeMethod: query.Get("code_challenge_method"),
+		}
+		client, ok := authorizeClient(db, w, &req)
+		if !ok {
+			return
+		}
+		if _, authErr := validateAuthorizeRequest(&req); authErr != nil {
+			http.Redirect(w, r, authorizeRedirect(&req, url.Values{"error": {authErr.code}, "error_description": {authErr.description}}), http.StatusFound)
+			return
+		}
+
+		loginURL := os.Getenv("OIDC_LOGIN_URL")
+		if loginURL == "" {
+			loginURL = "http://localhost:3000/login"
+		}
+		next := url.Values{"authorize": {r.URL.RawQuery}, "client_name": {client.Name}}
+		http.Redirect(w, r, loginURL+"?"+next.Encode(), http.StatusFound)
+	}
+}
+
+// Authorize completes an authentication request for the logged-in caller. It answers
+// with "redirectTo", the client's redirect URI carrying either a code or an error, or
+// with "consentRequired" when a third-party client needs the user's approval first.
+// First-party clients never ask for consent.
+func Authorize(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if actorID, _ := r.Context().Value(middleware.ActorIDContextKey).(string); actorID != "" {
+			util.WriteJSON(w, http.StatusForbidden, util.H{"error": "Single sign-on is not available while impersonating"})
+			return
+		}
+		if scopes, _ := r.Context().Value(middleware.ScopesContextKey).([]string); len(scopes) > 0 {
+			util.WriteJSON(w, http.StatusForbidden, util.H{"error": "Sign in to the LMS directly to authorize an application"})
+			return
+		}
+		userID, err := uuid.Parse(fmt.Sprint(r.Context().Value(middleware.UserIDContextKey)))
+		if err != nil {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Could not identify user from context"})
+			return
+		}
+		sessionID, err := uuid.Parse(fmt.Sprint(r.Context().Value(middleware.SessionIDContextKey)))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Token is not bound to a session"})
+			return
+		}
+
+		var req AuthorizeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		client, ok := authorizeClient(db, w, &req)
+		if !ok {
+			return
+		}
+		scopes, authErr := validateAuthorizeRequest(&req)
+		if authErr != nil {
+			util.WriteJSON(w, http.StatusOK, util.H{"redirectTo": authorizeRedirect(&req,
+				url.Values{"error": {authErr.code}, "error_description": {authErr.description}})})
+			return
+		}
+
+		if !client.FirstParty {
+			switch req.Consent {
+			case "deny":
+				util.WriteJSON(w, http.StatusOK, util.H{"redirectTo": authorizeRedirect(&req,
+					url.Values{"error": {"access_denied"}, "error_description": {"The user denied the request"}})})
+				return
+			case "approve":
+				if err := database.SaveConsent(db, userID, client.ClientID, scopes); err != nil {
+					log.Printf("ERROR: %v", err)
+					util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to authorize application"})
+					return
+				}
+			default:
+				consented, err := database.HasConsent(db, userID, client.ClientID, scopes)
+				if err != nil {
+					log.Printf("ERROR: %v", err)
+					util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to authorize application"})
+					return
+				}
+				if !consented {
+					util.WriteJSON(w, http.StatusOK, util.H{
+						"consentRequired": true,
+						"client":          util.H{"clientId": client.ClientID, "name": client.Name},
+						"scopes":          scopes,
+					})
+					return
+				}
+			}
+		}
+
+		var session models.Session
+		if err := db.First(&session, "id = ?", sessionID).Error; err != nil {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Session not found"})
+			return
+		}
+		code, err := database.CreateAuthorizationCode(db, &models.AuthorizationCode{
+			ClientID:      client.ClientID,
+			UserID:        userID,
+			SessionID:     sessionID,
+			RedirectURI:   req.RedirectURI,
+			Scope:         strings.Join(scopes, " "),
+			Nonce:         req.Nonce,
+			CodeChallenge: req.CodeChallenge,
+			AuthTime:      session.CreatedAt,
+		})
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to authorize application"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"redirectTo": authorizeRedirect(&req, url.Values{"code": {code}})})
+	}
+}
+
+// authorizeClient checks the client and redirect URI. These errors must not be sent to the
+// redirect URI, since it cannot be trusted until it matches a registered one.
+func authorizeClient(db *gorm.DB, w http.ResponseWriter, req *AuthorizeRequest) (*models.OIDCClient, bool) {
+	client, err := database.FindOIDCClient(db, req.ClientID)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidClientCredentials) {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Unknown client"})
+			return nil, false
+		}
+		log.Printf("ERROR: %v", err)
+		util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to look up client"})
+		return nil, false
+	}
+	if !client.AllowsRedirect(req.RedirectURI) {
+		util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "redirect_uri is not registered for this client"})
+		return nil, false
+	}
+	return client, true
+}
+
+// validateAuthorizeRequest checks everything but the client and returns the requested
+// scopes we support. PKCE with S256 is required of every client.
+func validateAuthorizeRequest(req *AuthorizeRequest) ([]string, *authorizeError) {
+	if req.ResponseType != "code" {
+		return nil, &authorizeError{"unsupported_response_type", "Only the authorization code flow is supported"}
+	}
+	requested := strings.Fields(req.Scope)
+	if !slices.Contains(requested, oauth.ScopeOpenID) {
+		return nil, &authorizeError{"invalid_scope", "The openid scope is required"}
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return nil, &authorizeError{"invalid_request", "PKCE with code_challenge_method=S256 is required"}
+	}
+	// Unknown scopes are ignored rather than rejected, as OpenID Connect allows.
+	var scopes []string
+	for _, scope := range requested {
+		if slices.Contains(oauth.SupportedOIDCScopes, scope) && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// authorizeRedirect builds the client's redirect URI with the response parameters and state.
+func authorizeRedirect(req *AuthorizeRequest, params url.Values) string {
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	separator := "?"
+	if strings.Contains(req.RedirectURI, "?") {
+		separator = "&"
+	}
+	return req.RedirectURI + separator + params.Encode()
+}
+
+// Token is the OAuth 2.0 token endpoint. It serves the authorization code grant for
+// OpenID Connect clients and the client-credentials grant for service accounts. Errors
+// use the RFC 6749 error codes.
+func Token(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if err := r.ParseForm(); err != nil {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Could not parse form body")
+			return
+		}
+		switch r.PostForm.Get("grant_type") {
+		case "authorization_code":
+			authorizationCodeGrant(db, w, r)
+		case "client_credentials":
+			clientCredentialsGrant(db, w, r)
+		default:
+			writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Supported grant types are authorization_code and client_credentials")
+		}
+	}
+}
+
+// clientCredentials reads the client's ID and secret from HTTP Basic auth or, failing
+// that, from the client_id and client_secret form fields.
+func clientCredentials(r *http.Request) (string, string) {
+	if clientID, secret, ok := r.BasicAuth(); ok {
+		return clientID, secret
+	}
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+}
+
+// authorizationCodeGrant redeems a code for an ID token and an access token.
+func authorizationCodeGrant(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	clientID, secret := clientCredentials(r)
+	client, err := database.AuthenticateOIDCClient(db, clientID, secret)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidClientCredentials) {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+			return
+		}
+		log.Printf("ERROR: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to issue token")
+		return
+	}
+
+	code, err := database.RedeemAuthorizationCode(db, r.PostForm.Get("code"), client.ClientID,
+		r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidAuthorizationCode) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid, expired or already used authorization code")
+			return
+		}
+		log.Printf("ERROR: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to issue token")
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, "id = ?", code.UserID).Error; err != nil || !user.IsActive() {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "The user can no longer sign in")
+		return
+	}
+	scopes := strings.Fields(code.Scope)
+	claims, err := oidcUserClaims(db, &user, scopes)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to issue token")
+		return
+	}
+
+	idToken, err := oauth.GenerateIDToken(client.ClientID, user.ID.String(), code.SessionID.String(), code.Nonce, code.AuthTime, claims)
+	if err != nil {
+		log.Printf("ERROR: Could not generate ID token: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to issue token")
+		return
+	}
+	accessToken, err := oauth.GenerateClientAccessToken(user.ID.String(), user.Role, code.SessionID.String(), client.ClientID, code.Scope)
+	if err != nil {
+		log.Printf("ERROR: Could not generate access token: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to issue token")
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, util.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(oauth.AccessTokenTTL().Seconds()),
+		"id_token":     idToken,
+		"scope":        code.Scope,
+	})
+}
+
+// UserInfo returns the claims about the caller that their access token's scopes allow.
+// It only accepts tokens issued to an OpenID Connect client with the openid scope.
+func UserInfo(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scopes, _ := r.Context().Value(middleware.ScopesContextKey).([]string)
+		serviceID, _ := r.Context().Value(middleware.ServiceAccountContextKey).(string)
+		if serviceID != "" || !slices.Contains(scopes, oauth.ScopeOpenID) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			util.WriteJSON(w, http.StatusForbidden, util.H{"error": "insufficient_scope"})
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, "id = ?", r.Context().Value(middleware.UserIDContextKey)).Error; err != nil {
+			util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+			return
+		}
+		claims, err := oidcUserClaims(db, &user, scopes)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to load user info"})
+			return
+		}
+		claims["sub"] = user.ID.String()
+		util.WriteJSON(w, http.StatusOK, util.H(claims))
+	}
+}
+
+// oidcUserClaims builds the claims about a user that the scopes allow. The role is always
+// included; "profile" adds the name and the fields of the user's profile, "email" the address.
+func oidcUserClaims(db *gorm.DB, user *models.User, scopes []string) (map[string]interface{}, error) {
+	claims := map[string]interface{}{"role": user.Role}
+	if slices.Contains(scopes, oauth.ScopeEmail) {
+		claims["email"] = user.Email
+		// Accounts are provisioned by admins with institutional addresses.
+		claims["email_verified"] = true
+	}
+	if !slices.Contains(scopes, oauth.ScopeProfile) {
+		return claims, nil
+	}
+
+	profile, err := database.LoadUserProfile(db, user.ID, user.Role)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return claims, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch p := profile.(type) {
+	case *models.StudentProfile:
+		claims["name"] = p.FullName
+		claims["roll_no"] = p.RollNo
+		claims["branch"] = p.Branch
+		claims["is_ta"] = p.IsTA
+		if p.YearOfAdmission != nil {
+			claims["year_of_admission"] = *p.YearOfAdmission
+		}
+	case *models.InstructorProfile:
+		claims["name"] = p.FullName
+		claims["employee_id"] = p.EmployeeID
+		claims["department"] = p.Department
+		claims["title"] = p.Title
+	case *models.AdminProfile:
+		claims["name"] = p.FullName
+		claims["employee_id"] = p.EmployeeID
+		claims["job_title"] = p.JobTitle
+	}
+	claims["updated_at"] = user.UpdatedAt.Unix()
+	return claims, nil
+}
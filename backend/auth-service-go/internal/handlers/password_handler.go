@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/mailer"
+	"auth/internal/models"
+	"auth/internal/password"
+	"auth/internal/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// ChangePassword lets a logged-in user change their own password, subject to the
+// password policy. Every existing session is revoked and a fresh one is returned to the
+// caller.
+func ChangePassword(db *gorm.DB) http.HandlerFunc {
+	policy := password.PolicyFromEnv()
+	return func(w http.ResponseWriter, r *http.Request) {
+		userIDStr, ok := r.Context().Value(middleware.UserIDContextKey).(string)
+		if !ok {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Could not identify user from context"})
+			return
+		}
+
+		var req ChangePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		if req.CurrentPassword == "" || req.NewPassword == "" {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Current and new password are required"})
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, "id = ?", userIDStr).Error; err != nil {
+			util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+			return
+		}
+		ok, _, err := password.Verify(user.PasswordHash, req.CurrentPassword)
+		if err != nil {
+			log.Printf("ERROR: Could not verify password: %v", err)
+		}
+		if !ok {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Current password is incorrect"})
+			return
+		}
+
+		if err := policy.Check(req.NewPassword); err != nil {
+			writePasswordPolicyError(w, err)
+			return
+		}
+		hashedPassword, err := password.Hash(req.NewPassword)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to change password"})
+			return
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return setPassword(tx, policy, user.ID, req.NewPassword, hashedPassword)
+		})
+		if err != nil {
+			if errors.Is(err, password.ErrWeakPassword) {
+				writePasswordPolicyError(w, err)
+				return
+			}
+			log.Printf("ERROR: Failed to change password: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to change password"})
+			return
+		}
+
+		resp, err := startSession(db, nil, r, &user)
+		if err != nil {
+			log.Printf("ERROR: Could not start session: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Password changed, but failed to generate token"})
+			return
+		}
+		resp["message"] = "Password changed successfully"
+		util.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+// ForgotPassword emails a single-use reset link. It answers the same way whether or not
+// the email exists so it cannot be used to discover accounts; the email is sent in the
+// background so the response time does not give it away either.
+func ForgotPassword(db *gorm.DB, mail mailer.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ForgotPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Email is required"})
+			return
+		}
+
+		response := util.H{"message": "If an account exists for that email, a reset link has been sent"}
+
+		var user models.User
+		if err := db.Where("email = ?", req.Email).First(&user).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("ERROR: Failed to look up user for password reset: %v", err)
+			}
+			util.WriteJSON(w, http.StatusAccepted, response)
+			return
+		}
+
+		var rawToken string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			rawToken, err = database.CreatePasswordResetToken(tx, user.ID)
+			return err
+		})
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to start password reset"})
+			return
+		}
+
+		go func(message mailer.Message) {
+			if err := mail.Send(message); err != nil {
+				log.Printf("ERROR: Failed to send password reset email: %v", err)
+			}
+		}(passwordResetMessage(user.Email, rawToken))
+		util.WriteJSON(w, http.StatusAccepted, response)
+	}
+}
+
+// ResetPassword consumes a reset token, sets the new password and revokes every session
+// in one transaction. The new password is subject to the password policy; a rejected one
+// leaves the token unused so the user can try again.
+func ResetPassword(db *gorm.DB) http.HandlerFunc {
+	policy := password.PolicyFromEnv()
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ResetPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		if req.Token == "" || req.NewPassword == "" {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Token and new password are required"})
+			return
+		}
+
+		if err := policy.Check(req.NewPassword); err != nil {
+			writePasswordPolicyError(w, err)
+			return
+		}
+		hashedPassword, err := password.Hash(req.NewPassword)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to process request"})
+			return
+		}
+
+		var userID uuid.UUID
+		err = db.Transaction(func(tx *gorm.DB) error {
+			userID, err = database.ConsumePasswordResetToken(tx, req.Token)
+			if err != nil {
+				return err
+			}
+			return setPassword(tx, policy, userID, req.NewPassword, hashedPassword)
+		})
+		if err != nil {
+			if errors.Is(err, password.ErrWeakPassword) {
+				writePasswordPolicyError(w, err)
+				return
+			}
+			if errors.Is(err, database.ErrInvalidResetToken) {
+				util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid or expired reset token"})
+				return
+			}
+			log.Printf("ERROR: Failed to reset password: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to reset password"})
+			return
+		}
+
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Password reset successfully"})
+	}
+}
+
+// setPassword stores hashedPassword, the hash of newPassword, as the user's password
+// and revokes all of their sessions. It must run inside a transaction; the user's row is
+// locked first so two concurrent changes cannot both pass the reuse check. newPassword
+// must already have passed policy.Check.
+func setPassword(tx *gorm.DB, policy *password.Policy, userID uuid.UUID, newPassword, hashedPassword string) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, "id = ?", userID).Error; err != nil {
+		return err
+	}
+	if err := database.CheckPasswordReuse(tx, userID, newPassword, policy.HistorySize); err != nil {
+		return err
+	}
+	if err := database.UpdatePasswordHash(tx, userID, hashedPassword, policy.HistorySize); err != nil {
+		return err
+	}
+	return database.RevokeUserSessions(tx, userID)
+}
+
+// writePasswordPolicyError answers a rejected new password with the reason, or with a
+// server error when the check itself failed.
+func writePasswordPolicyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, password.ErrWeakPassword) {
+		util.WriteJSON(w, http.StatusBadRequest, util.H{"error": err.Error()})
+		return
+	}
+	log.Printf("ERROR: %v", err)
+	util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to check password"})
+}
+
+func passwordResetMessage(email, rawToken string) mailer.Message {
+	resetURL := os.Getenv("PASSWORD_RESET_URL")
+	if resetURL == "" {
+		resetURL = "http://localhost:3000/reset-password"
+	}
+	return mailer.Message{
+		To:      email,
+		Subject: "Reset your LMS password",
+		Body: fmt.Sprintf("Someone asked to reset the password for this account.\n\n"+
+			"Use this link to choose a new password:\n%s?token=%s\n\n"+
+			"If you did not ask for this, you can ignore this email.", resetURL, rawToken),
+	}
+}
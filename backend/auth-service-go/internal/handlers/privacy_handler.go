@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/models"
+	"auth/internal/util"
+	"errors"
+	"lms/pkg/audit"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ExportUserData returns everything the auth service holds about a user, for a
+// data-subject access request. The gateway combines it with the ERP and classroom data.
+func ExportUserData(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+
+		export, err := database.ExportUserData(db, userID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+				return
+			}
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to export user data"})
+			return
+		}
+		// Nothing changes, but the data is only handed over once the export is on record
+		if err := recordAudit(db, auditLog, r, "user.export", "user", userID.String(), nil, nil); err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to export user data"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"export": export, "generatedAt": time.Now().UTC()})
+	}
+}
+
+// AnonymizeUser scrubs a user's personal data for an erasure request and deactivates
+// the account; see database.AnonymizeUser for what is kept. It cannot be undone, and
+// admins cannot anonymize themselves.
+func AnonymizeUser(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+		if actorID, _ := r.Context().Value(middleware.UserIDContextKey).(string); actorID == userID.String() {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "You cannot anonymize your own account"})
+			return
+		}
+
+		var user *models.User
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if user, err = database.AnonymizeUser(tx, userID); err != nil {
+				return err
+			}
+			// The audit log is immutable, so the entry must not carry the data just erased
+			return recordAudit(tx, auditLog, r, "user.anonymize", "user", userID.String(), nil,
+				accountStatus{user.Status, user.StatusReason})
+		})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+				return
+			}
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to anonymize user"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "User anonymized", "user": user})
+	}
+}
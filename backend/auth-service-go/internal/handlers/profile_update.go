@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/mailer"
+	"auth/internal/models"
+	"auth/internal/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lms/pkg/audit"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// contactNumberPattern accepts an optional leading "+" followed by 7-15 digits,
+// allowing spaces and dashes between digit groups.
+var contactNumberPattern = regexp.MustCompile(`^\+?[0-9](?:[ -]?[0-9]){6,14}$`)
+
+// profileUpdate is implemented by the per-role update DTOs. Columns returns only the
+// fields present in the request, keyed by column name, after validating them.
+type profileUpdate interface {
+	Columns() (map[string]interface{}, error)
+}
+
+// StudentProfileUpdate lists the student fields an admin may change. Fields left out
+// of the request are not touched. TA status is changed through the role endpoint.
+type StudentProfileUpdate struct {
+	FullName        *string `json:"fullName"`
+	RollNo          *string `json:"rollNo"`
+	Branch          *string `json:"branch"`
+	YearOfAdmission *int    `json:"yearOfAdmission"`
+	DateOfBirth     *string `json:"dateOfBirth"` // YYYY-MM-DD
+	Address         *string `json:"address"`
+	ContactNumber   *string `json:"contactNumber"`
+	FatherName      *string `json:"fatherName"`
+	MotherName      *string `json:"motherName"`
+}
+
+// InstructorProfileUpdate lists the instructor fields an admin may change.
+type InstructorProfileUpdate struct {
+	FullName   *string `json:"fullName"`
+	EmployeeID *string `json:"employeeId"`
+	Department *string `json:"department"`
+	Title      *string `json:"title"`
+}
+
+// AdminProfileUpdate lists the admin fields an admin may change.
+type AdminProfileUpdate struct {
+	FullName   *string `json:"fullName"`
+	EmployeeID *string `json:"employeeId"`
+	JobTitle   *string `json:"jobTitle"`
+}
+
+func (u *StudentProfileUpdate) Columns() (map[string]interface{}, error) {
+	c := columnSet{}
+	c.requiredText("full_name", "fullName", u.FullName, 255)
+	c.requiredText("roll_no", "rollNo", u.RollNo, 50)
+	c.optionalText("branch", "branch", u.Branch, 100)
+	c.optionalText("address", "address", u.Address, 1000)
+	c.optionalText("father_name", "fatherName", u.FatherName, 255)
+	c.optionalText("mother_name", "motherName", u.MotherName, 255)
+	if u.ContactNumber != nil {
+		number := strings.TrimSpace(*u.ContactNumber)
+		if number != "" && !contactNumberPattern.MatchString(number) {
+			c.fail("contactNumber must be 7-15 digits, optionally starting with +")
+		}
+		c.columns["contact_number"] = number
+	}
+	if u.YearOfAdmission != nil {
+		year := *u.YearOfAdmission
+		if year < 1950 || year > time.Now().Year()+1 {
+			c.fail(fmt.Sprintf("yearOfAdmission must be between 1950 and %d", time.Now().Year()+1))
+		}
+		c.columns["year_of_admission"] = year
+	}
+	if u.DateOfBirth != nil {
+		dob, err := time.Parse("2006-01-02", *u.DateOfBirth)
+		switch {
+		case err != nil:
+			c.fail("dateOfBirth must be YYYY-MM-DD")
+		case dob.Year() < 1900 || dob.After(time.Now()):
+			c.fail("dateOfBirth must be a past date after 1900")
+		}
+		c.columns["date_of_birth"] = dob
+	}
+	return c.result()
+}
+
+func (u *InstructorProfileUpdate) Columns() (map[string]interface{}, error) {
+	c := columnSet{}
+	c.requiredText("full_name", "fullName", u.FullName, 255)
+	c.requiredText("employee_id", "employeeId", u.EmployeeID, 50)
+	c.optionalText("department", "department", u.Department, 100)
+	c.optionalText("title", "title", u.Title, 100)
+	return c.result()
+}
+
+func (u *AdminProfileUpdate) Columns() (map[string]interface{}, error) {
+	c := columnSet{}
+	c.requiredText("full_name", "fullName", u.FullName, 255)
+	c.requiredText("employee_id", "employeeId", u.EmployeeID, 50)
+	c.optionalText("job_title", "jobTitle", u.JobTitle, 100)
+	return c.result()
+}
+
+// columnSet collects validated columns and every validation error, so a client sees
+// all of its mistakes at once.
+type columnSet struct {
+	columns map[string]interface{}
+	errs    []string
+}
+
+func (c *columnSet) fail(msg string) {
+	c.errs = append(c.errs, msg)
+}
+
+func (c *columnSet) text(column, field string, value *string, maxLen int, required bool) {
+	if c.columns == nil {
+		c.columns = map[string]interface{}{}
+	}
+	if value == nil {
+		return
+	}
+	v := strings.TrimSpace(*value)
+	if required && v == "" {
+		c.fail(field + " cannot be empty")
+	}
+	if len(v) > maxLen {
+		c.fail(fmt.Sprintf("%s must be at most %d characters", field, maxLen))
+	}
+	c.columns[column] = v
+}
+
+func (c *columnSet) requiredText(column, field string, value *string, maxLen int) {
+	c.text(column, field, value, maxLen, true)
+}
+
+func (c *columnSet) optionalText(column, field string, value *string, maxLen int) {
+	c.text(column, field, value, maxLen, false)
+}
+
+func (c *columnSet) result() (map[string]interface{}, error) {
+	if len(c.errs) > 0 {
+		return nil, errors.New(strings.Join(c.errs, "; "))
+	}
+	if len(c.columns) == 0 {
+		return nil, errors.New("no fields to update")
+	}
+	return c.columns, nil
+}
+
+func profileUpdateForRole(role string) profileUpdate {
+	switch models.ProfileKind(role) {
+	case "student":
+		return &StudentProfileUpdate{}
+	case "instructor":
+		return &InstructorProfileUpdate{}
+	}
+	return &AdminProfileUpdate{}
+}
+
+// UpdateUser updates the profile fields allowed for the user's role. Unknown fields
+// are rejected rather than ignored, so a typo or an attempt to set e.g. user_id fails loudly.
+// Email and role have their own endpoints.
+func UpdateUser(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userIDStr := r.PathValue("id")
+		userID, err := uuid.Parse(userIDStr)
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+			return
+		}
+
+		update := profileUpdateForRole(user.Role)
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(update); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload: " + err.Error()})
+			return
+		}
+		columns, err := update.Columns()
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": err.Error()})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			before, err := database.LoadUserProfile(tx, userID, user.Role)
+			if err != nil {
+				return err
+			}
+			if err := database.UpdateUserProfile(tx, userID, user.Role, columns); err != nil {
+				return err
+			}
+			after, err := database.LoadUserProfile(tx, userID, user.Role)
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "user.update_profile", "user", userID.String(),
+				personalData(before, profileAuditFields...), personalData(after, profileAuditFields...))
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrRollNoTaken), errors.Is(err, database.ErrEmployeeIDTaken):
+				util.WriteJSON(w, http.StatusConflict, util.H{"error": err.Error()})
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User has no profile to update"})
+			default:
+				log.Printf("ERROR: Failed to update profile: %v", err)
+				util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to update user profile"})
+			}
+			return
+		}
+
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "User updated successfully"})
+	}
+}
+
+// ChangeEmailRequest is the body for PUT /admin/users/{id}/email.
+type ChangeEmailRequest struct {
+	Email string `json:"email"`
+}
+
+// ChangeUserEmail changes a user's login email. The old address is told about the change.
+// The caller must hold every permission the user has, since whoever controls the email
+// can take over the account through a password reset.
+func ChangeUserEmail(db *gorm.DB, mail mailer.Mailer, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+
+		var req ChangeEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		email, err := mailAddress(strings.TrimSpace(req.Email))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": err.Error()})
+			return
+		}
+
+		var oldEmail string
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := checkTargetWithinCaller(tx, r, userID); err != nil {
+				return err
+			}
+			var err error
+			if oldEmail, err = database.ChangeUserEmail(tx, userID, email); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "user.change_email", "user", userID.String(), util.H{"email": personalDigest(oldEmail)}, util.H{"email": personalDigest(email)})
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+			case errors.Is(err, database.ErrEmailTaken):
+				util.WriteJSON(w, http.StatusConflict, util.H{"error": "Email already exists"})
+			case errors.Is(err, database.ErrPermissionNotHeld):
+				util.WriteJSON(w, http.StatusForbidden, util.H{"error": err.Error()})
+			default:
+				log.Printf("ERROR: Failed to change email: %v", err)
+				util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to change email"})
+			}
+			return
+		}
+
+		if oldEmail != email {
+			notice := mailer.Message{
+				To:      oldEmail,
+				Subject: "Your LMS login email was changed",
+				Body:    fmt.Sprintf("The login email for your LMS account was changed to %s by an administrator.\n\nIf you did not expect this, contact your administrator.", email),
+			}
+			if err := mail.Send(notice); err != nil {
+				log.Printf("ERROR: Failed to notify %s of email change: %v", oldEmail, err)
+			}
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Email updated successfully", "email": email})
+	}
+}
+
+// checkTargetWithinCaller locks a user and fails with ErrPermissionNotHeld unless the
+// caller holds every permission of the user's role and of any further roles given.
+func checkTargetWithinCaller(tx *gorm.DB, r *http.Request, userID uuid.UUID, roles ...string) error {
+	var target models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "role").First(&target, "id = ?", userID).Error; err != nil {
+		return err
+	}
+	missing, err := callerLacksRolePermission(tx, r, append([]string{target.Role}, roles...)...)
+	if err != nil {
+		return err
+	}
+	if missing != "" {
+		return fmt.Errorf("%w: %s", database.ErrPermissionNotHeld, missing)
+	}
+	return nil
+}
+
+// mailAddress validates a bare email address (no display name) and returns it.
+func mailAddress(email string) (string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", errors.New("email is not a valid address")
+	}
+	return addr.Address, nil
+}
+
+// ChangeRoleRequest is the body for POST /admin/users/{id}/role. Moving a TA back to
+// student needs no profile; any other move needs the profile for the new role.
+type ChangeRoleRequest struct {
+	Role              string                    `json:"role"`
+	Reason            string                    `json:"reason"`
+	StudentProfile    *models.StudentProfile    `json:"studentProfile,omitempty"`
+	InstructorProfile *models.InstructorProfile `json:"instructorProfile,omitempty"`
+	AdminProfile      *models.AdminProfile      `json:"adminProfile,omitempty"`
+}
+
+// ChangeUserRole moves a user to another role, replacing their profile row, records
+// who made the change, and signs the user out everywhere. The caller must hold every
+// permission of both the old and the new role.
+func ChangeUserRole(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+		callerID, err := uuid.Parse(fmt.Sprint(r.Context().Value(middleware.UserIDContextKey)))
+		if err != nil {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Could not identify user from context"})
+			return
+		}
+		if callerID == userID {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "You cannot change your own role"})
+			return
+		}
+
+		var req ChangeRoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Role == "" {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		profile := (&CreateUserRequest{
+			Role:              req.Role,
+			StudentProfile:    req.StudentProfile,
+			InstructorProfile: req.InstructorProfile,
+			AdminProfile:      req.AdminProfile,
+		}).ProfileData()
+
+		var change *models.RoleChange
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := checkTargetWithinCaller(tx, r, userID, req.Role); err != nil {
+				return err
+			}
+			var err error
+			if change, err = database.ChangeUserRole(tx, userID, req.Role, profile, callerID, req.Reason); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "user.change_role", "user", userID.String(),
+				util.H{"role": change.OldRole}, util.H{"role": change.NewRole, "reason": change.Reason})
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+			case errors.Is(err, database.ErrRollNoTaken), errors.Is(err, database.ErrEmployeeIDTaken), errors.Is(err, database.ErrSameRole):
+				util.WriteJSON(w, http.StatusConflict, util.H{"error": err.Error()})
+			case errors.Is(err, database.ErrInvalidProfile), errors.Is(err, database.ErrUseTAAssignments), errors.Is(err, database.ErrUnknownRole):
+				util.WriteJSON(w, http.StatusBadRequest, util.H{"error": err.Error()})
+			case errors.Is(err, database.ErrPermissionNotHeld):
+				util.WriteJSON(w, http.StatusForbidden, util.H{"error": err.Error()})
+			default:
+				log.Printf("ERROR: Failed to change role: %v", err)
+				util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to change role"})
+			}
+			return
+		}
+
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Role changed successfully", "change": change})
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/models"
+	"auth/internal/util"
+	"encoding/json"
+	"errors"
+	"lms/pkg/audit"
+	"log"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// RoleRequest is the body for PUT /admin/roles/{name}.
+type RoleRequest struct {
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// ListRoles returns every role and the permissions it grants.
+func ListRoles(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roles, err := database.ListRoles(db)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to list roles"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"roles": roles})
+	}
+}
+
+// ListPermissions returns the permission registry, for building role editors.
+func ListPermissions(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := database.ListPermissions(db)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to list permissions"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"permissions": list})
+	}
+}
+
+// SaveRole creates a role or replaces its permissions. Users with the role pick up the
+// change when their access token is next refreshed.
+func SaveRole(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+
+		name := r.PathValue("name")
+		var role *models.Role
+		err := db.Transaction(func(tx *gorm.DB) error {
+			before, err := database.PermissionsForRole(tx, name)
+			if err != nil {
+				return err
+			}
+			if role, err = database.SaveRole(tx, name, req.Description, req.Permissions); err != nil {
+				return err
+			}
+			after, err := database.PermissionsForRole(tx, name)
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "role.save", "role", name,
+				util.H{"permissions": before}, util.H{"permissions": after, "description": role.Description})
+		})
+		if err != nil {
+			if errors.Is(err, database.ErrInvalidRoleName) || errors.Is(err, database.ErrUnknownPermission) {
+				util.WriteJSON(w, http.StatusBadRequest, util.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, database.ErrAdminRoleLocked) || errors.Is(err, database.ErrLastRoleManager) {
+				util.WriteJSON(w, http.StatusConflict, util.H{"error": err.Error()})
+				return
+			}
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to save role"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Role saved successfully", "role": role})
+	}
+}
+
+// DeleteRole removes a custom role that is not assigned to anyone.
+func DeleteRole(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		err := db.Transaction(func(tx *gorm.DB) error {
+			before, err := database.PermissionsForRole(tx, name)
+			if err != nil {
+				return err
+			}
+			if err := database.DeleteRole(tx, name); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "role.delete", "role", name, util.H{"permissions": before}, nil)
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrUnknownRole):
+				util.WriteJSON(w, http.StatusNotFound, util.H{"error": "Role not found"})
+			case errors.Is(err, database.ErrBuiltinRole), errors.Is(err, database.ErrRoleInUse):
+				util.WriteJSON(w, http.StatusConflict, util.H{"error": err.Error()})
+			default:
+				log.Printf("ERROR: %v", err)
+				util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to delete role"})
+			}
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Role deleted successfully"})
+	}
+}
@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/models"
+	"auth/internal/scim"
+	"auth/internal/util"
+	"errors"
+	"lms/pkg/audit"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SCIMGroup is the part of a SCIM Group resource the LMS stores.
+type SCIMGroup struct {
+	DisplayName string `json:"displayName"`
+	Members     []struct {
+		Value string `json:"value"`
+	} `json:"members"`
+}
+
+func groupVersion(role *models.Role, members []models.User) string {
+	var latest time.Time
+	for _, m := range members {
+		if m.UpdatedAt.After(latest) {
+			latest = m.UpdatedAt
+		}
+	}
+	return scim.Version(role.UpdatedAt.UnixNano(), len(members), latest.UnixNano())
+}
+
+func scimGroupResource(role *models.Role, members []models.User, withMembers bool) map[string]interface{} {
+	lastModified := role.UpdatedAt
+	for _, m := range members {
+		if m.UpdatedAt.After(lastModified) {
+			lastModified = m.UpdatedAt
+		}
+	}
+	res := map[string]interface{}{
+		"schemas":     []interface{}{scim.GroupSchema},
+		"id":          role.Name,
+		"displayName": role.Name,
+		"meta": map[string]interface{}{
+			"resourceType": "Group",
+			"created":      role.CreatedAt,
+			"lastModified": lastModified,
+			"location":     scimBaseURL() + "/Groups/" + role.Name,
+			"version":      groupVersion(role, members),
+		},
+	}
+	if withMembers {
+		list := make([]interface{}, 0, len(members))
+		for _, m := range members {
+			list = append(list, map[string]interface{}{
+				"value": m.ID.String(), "display": m.Email, "type": "User", "$ref": scimBaseURL() + "/Users/" + m.ID.String(),
+			})
+		}
+		res["members"] = list
+	}
+	return res
+}
+
+// wantsMembers honours excludedAttributes=members and attributes lists without members;
+// clients use them to avoid downloading every student in the student group.
+func wantsMembers(r *http.Request) bool {
+	has := func(param string) bool {
+		for _, attr := range strings.Split(r.URL.Query().Get(param), ",") {
+			if n := scim.NormalizePath(attr); n == "members" || strings.HasPrefix(n, "members.") {
+				return true
+			}
+		}
+		return false
+	}
+	if has("excludedAttributes") {
+		return false
+	}
+	return r.URL.Query().Get("attributes") == "" || has("attributes")
+}
+
+// loadSCIMGroup reads the role named in the path and its members.
+func loadSCIMGroup(db *gorm.DB, w http.ResponseWriter, r *http.Request) (*models.Role, []models.User, bool) {
+	role, err := database.FindRole(db, r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, database.ErrUnknownRole) {
+			scim.WriteError(w, scim.Errorf(http.StatusNotFound, "", "group not found"))
+		} else {
+			writeSCIMError(w, err)
+		}
+		return nil, nil, false
+	}
+	members, err := database.RoleMembers(db, []string{role.Name})
+	if err != nil {
+		writeSCIMError(w, err)
+		return nil, nil, false
+	}
+	return role, members[role.Name], true
+}
+
+// lockSCIMGroup reads the role named in the path and its members for a write, locking
+// the role row for the rest of tx, and checks If-Match against what it read.
+func lockSCIMGroup(tx *gorm.DB, r *http.Request) (*models.Role, []models.User, error) {
+	role, err := database.FindRole(tx.Clauses(clause.Locking{Strength: "UPDATE"}), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, database.ErrUnknownRole) {
+			return nil, nil, scim.Errorf(http.StatusNotFound, "", "group not found")
+		}
+		return nil, nil, err
+	}
+	members, err := database.RoleMembers(tx, []string{role.Name})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := scim.CheckPrecondition(r, groupVersion(role, members[role.Name])); err != nil {
+		return nil, nil, err
+	}
+	return role, members[role.Name], nil
+}
+
+func writeSCIMGroup(db *gorm.DB, w http.ResponseWriter, r *http.Request, status int, name string) {
+	role, err := database.FindRole(db, name)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	members, err := database.RoleMembers(db, []string{name})
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	w.Header().Set("ETag", groupVersion(role, members[name]))
+	w.Header().Set("Location", scimBaseURL()+"/Groups/"+name)
+	scim.WriteJSON(w, status, scimGroupResource(role, members[name], wantsMembers(r)))
+}
+
+// SCIMListGroups handles GET /Groups with filter, startIndex and count.
+func SCIMListGroups(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, scimErr := parseFilterParam(r)
+		if scimErr != nil {
+			scim.WriteError(w, scimErr)
+			return
+		}
+		page := scim.ParsePage(r)
+		roles, total, err := database.ListSCIMGroups(db, filter, page)
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		names := make([]string, 0, len(roles))
+		for _, role := range roles {
+			names = append(names, role.Name)
+		}
+		members, err := database.RoleMembers(db, names)
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		withMembers := wantsMembers(r)
+		resources := make([]interface{}, 0, len(roles))
+		for i := range roles {
+			resources = append(resources, scimGroupResource(&roles[i], members[roles[i].Name], withMembers))
+		}
+		scim.WriteJSON(w, http.StatusOK, scim.NewListResponse(resources, total, page))
+	}
+}
+
+// SCIMGetGroup handles GET /Groups/{id}.
+func SCIMGetGroup(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, members, ok := loadSCIMGroup(db, w, r)
+		if !ok {
+			return
+		}
+		version := groupVersion(role, members)
+		if scim.NotModified(r, version) {
+			w.Header().Set("ETag", version)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", version)
+		scim.WriteJSON(w, http.StatusOK, scimGroupResource(role, members, wantsMembers(r)))
+	}
+}
+
+// SCIMCreateGroup handles POST /Groups by creating a custom role with no permissions;
+// an admin grants it permissions afterwards. Listed members are moved into the role.
+func SCIMCreateGroup(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SCIMGroup
+		if err := scim.DecodeBody(r, &req); err != nil {
+			scim.WriteError(w, err)
+			return
+		}
+		name := strings.TrimSpace(req.DisplayName)
+		if exists, err := database.RoleExists(db, name); err != nil {
+			writeSCIMError(w, err)
+			return
+		} else if exists {
+			scim.WriteError(w, scim.Errorf(http.StatusConflict, scim.Uniqueness, "group %q already exists", name))
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if _, err := database.SaveRole(tx, name, "Created through SCIM provisioning", nil); err != nil {
+				return err
+			}
+			if err := recordAudit(tx, auditLog, r, "role.save", "role", name, nil, util.H{"permissions": []string{}}); err != nil {
+				return err
+			}
+			moved, err := setGroupMembers(tx, name, nil, &req)
+			if err != nil {
+				return err
+			}
+			return auditMembershipChanges(tx, auditLog, r, moved)
+		})
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIMGroup(db, w, r, http.StatusCreated, name)
+	}
+}
+
+// SCIMReplaceGroup handles PUT /Groups/{id}. Groups cannot be renamed.
+func SCIMReplaceGroup(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SCIMGroup
+		if err := scim.DecodeBody(r, &req); err != nil {
+			scim.WriteError(w, err)
+			return
+		}
+		var name string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			role, members, err := lockSCIMGroup(tx, r)
+			if err != nil {
+				return err
+			}
+			name = role.Name
+			return updateSCIMGroup(tx, auditLog, r, role, members, &req)
+		})
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIMGroup(db, w, r, http.StatusOK, name)
+	}
+}
+
+// SCIMPatchGroup handles PATCH /Groups/{id}, typically adding or removing members.
+func SCIMPatchGroup(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var patch scim.PatchRequest
+		if err := scim.DecodeBody(r, &patch); err != nil {
+			scim.WriteError(w, err)
+			return
+		}
+		var name string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			role, members, err := lockSCIMGroup(tx, r)
+			if err != nil {
+				return err
+			}
+			name = role.Name
+			resource := scimGroupResource(role, members, true)
+			if err := patch.Apply(resource); err != nil {
+				return err
+			}
+			var req SCIMGroup
+			if err := remarshal(resource, &req); err != nil {
+				return scim.Errorf(http.StatusBadRequest, scim.InvalidValue, "%v", err)
+			}
+			return updateSCIMGroup(tx, auditLog, r, role, members, &req)
+		})
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIMGroup(db, w, r, http.StatusOK, name)
+	}
+}
+
+// updateSCIMGroup saves the desired members of a group locked by lockSCIMGroup.
+func updateSCIMGroup(tx *gorm.DB, auditLog *audit.Logger, r *http.Request, role *models.Role, members []models.User, req *SCIMGroup) error {
+	if !strings.EqualFold(strings.TrimSpace(req.DisplayName), role.Name) {
+		return scim.Errorf(http.StatusBadRequest, scim.Mutability, "groups cannot be renamed")
+	}
+	moved, err := setGroupMembers(tx, role.Name, members, req)
+	if err != nil {
+		return err
+	}
+	return auditMembershipChanges(tx, auditLog, r, moved)
+}
+
+// setGroupMembers moves every requested member that is not yet in the group into the
+// role. Dropping a current member is refused, since they would be left without a role,
+// and so is moving anyone into or out of a privileged role.
+func setGroupMembers(tx *gorm.DB, role string, current []models.User, req *SCIMGroup) ([]*models.RoleChange, error) {
+	wanted := map[uuid.UUID]bool{}
+	for _, member := range req.Members {
+		id, err := uuid.Parse(member.Value)
+		if err != nil {
+			return nil, scim.Errorf(http.StatusBadRequest, scim.InvalidValue, "member %q is not a user ID", member.Value)
+		}
+		wanted[id] = true
+	}
+	for _, member := range current {
+		if !wanted[member.ID] {
+			return nil, scim.Errorf(http.StatusBadRequest, scim.Mutability,
+				"cannot remove %s from %q: users always have exactly one role, so add them to another group instead", member.Email, role)
+		}
+		delete(wanted, member.ID)
+	}
+
+	if len(wanted) > 0 {
+		if err := checkSCIMManagedRole(tx, role); err != nil {
+			return nil, err
+		}
+	}
+	var changes []*models.RoleChange
+	for id := range wanted {
+		var user models.User
+		if err := tx.Select("id", "role").First(&user, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, scim.Errorf(http.StatusBadRequest, scim.InvalidValue, "member %s does not exist", id)
+			}
+			return nil, err
+		}
+		if role == "student" && user.Role == "ta" {
+			continue // TAs are students with TA assignments
+		}
+		if err := checkSCIMManagedRole(tx, user.Role); err != nil {
+			return nil, err
+		}
+		change, err := database.ChangeUserRole(tx, id, role, nil, uuid.Nil, scimChangeReason)
+		switch {
+		case errors.Is(err, database.ErrInvalidProfile):
+			return nil, scim.Errorf(http.StatusBadRequest, scim.InvalidValue,
+				"moving %s to %q needs a new %s profile; set userType and the profile attributes on the User instead", id, role, models.ProfileKind(role))
+		case err != nil:
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func auditMembershipChanges(tx *gorm.DB, auditLog *audit.Logger, r *http.Request, changes []*models.RoleChange) error {
+	for _, change := range changes {
+		err := recordAudit(tx, auditLog, r, "user.change_role", "user", change.UserID.String(),
+			util.H{"role": change.OldRole}, util.H{"role": change.NewRole, "reason": change.Reason})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SCIMDeleteGroup handles DELETE /Groups/{id}. Only custom roles without users can go.
+func SCIMDeleteGroup(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			role, _, err := lockSCIMGroup(tx, r)
+			if err != nil {
+				return err
+			}
+			if err := database.DeleteRole(tx, role.Name); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "role.delete", "role", role.Name, nil, nil)
+		})
+		if err != nil {
+			if errors.Is(err, database.ErrBuiltinRole) || errors.Is(err, database.ErrRoleInUse) {
+				scim.WriteError(w, scim.Errorf(http.StatusBadRequest, scim.Mutability, "%v", err))
+				return
+			}
+			writeSCIMError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,774 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"auth/internal/password"
+	"auth/internal/scim"
+	"auth/internal/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lms/pkg/audit"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SCIM provisioning maps resources onto LMS records as follows:
+//
+//   - User.userName (or the primary email) is the login email, userType the role and
+//     active the account status. Users created without a userType get SCIM_DEFAULT_ROLE.
+//   - name.formatted, title, phoneNumbers and addresses, and the enterprise extension's
+//     employeeNumber and department, fill the role's profile: employeeNumber is a
+//     student's roll number or a staff member's employee ID, department a student's
+//     branch or an instructor's department.
+//   - A Group is a role and its members are the users holding it. Since every user has
+//     exactly one role, adding a member moves them from their old role, and members
+//     cannot be removed except by adding them to another group.
+//   - DELETE on a user deactivates them rather than erasing their records.
+//   - Roles that can manage roles (see database.IsPrivilegedRole) stay with admins: the
+//     provisioning client cannot assign them, take them away, or change the email or
+//     password of a user who holds one.
+
+// scimChangeReason is recorded on role and status changes made by the provisioning client.
+const scimChangeReason = "Changed through SCIM provisioning"
+
+func scimBaseURL() string {
+	return oauth.Issuer() + "/scim/v2"
+}
+
+func scimDefaultRole() string {
+	if role := os.Getenv("SCIM_DEFAULT_ROLE"); role != "" {
+		return role
+	}
+	return "student"
+}
+
+// scimBool accepts true/false as JSON booleans or as strings, which some clients send.
+type scimBool bool
+
+func (b *scimBool) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = scimBool(strings.EqualFold(s, "true"))
+		return nil
+	}
+	var v bool
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*b = scimBool(v)
+	return nil
+}
+
+type scimMultiValue struct {
+	Value   string   `json:"value"`
+	Type    string   `json:"type"`
+	Primary scimBool `json:"primary"`
+}
+
+type scimAddress struct {
+	Formatted     string   `json:"formatted"`
+	StreetAddress string   `json:"streetAddress"`
+	Locality      string   `json:"locality"`
+	Region        string   `json:"region"`
+	PostalCode    string   `json:"postalCode"`
+	Country       string   `json:"country"`
+	Primary       scimBool `json:"primary"`
+}
+
+// SCIMUser is the part of a SCIM User resource the LMS stores.
+type SCIMUser struct {
+	ExternalID   *string          `json:"externalId"`
+	UserName     string           `json:"userName"`
+	Name         *SCIMName        `json:"name"`
+	DisplayName  string           `json:"displayName"`
+	Title        string           `json:"title"`
+	UserType     string           `json:"userType"`
+	Active       *scimBool        `json:"active"`
+	Password     string           `json:"password"`
+	Emails       []scimMultiValue `json:"emails"`
+	PhoneNumbers []scimMultiValue `json:"phoneNumbers"`
+	Addresses    []scimAddress    `json:"addresses"`
+	Enterprise   *SCIMEnterprise  `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"`
+}
+
+// SCIMName is the SCIM "name" attribute; the LMS keeps only a full name.
+type SCIMName struct {
+	Formatted  string `json:"formatted"`
+	GivenName  string `json:"givenName"`
+	FamilyName string `json:"familyName"`
+}
+
+// SCIMEnterprise holds the enterprise User extension attributes that map onto profiles.
+type SCIMEnterprise struct {
+	EmployeeNumber string `json:"employeeNumber"`
+	Department     string `json:"department"`
+}
+
+// primary returns the primary value of a multi-valued attribute, else the first one.
+func primary(values []scimMultiValue) string {
+	for _, v := range values {
+		if v.Primary {
+			return strings.TrimSpace(v.Value)
+		}
+	}
+	if len(values) > 0 {
+		return strings.TrimSpace(values[0].Value)
+	}
+	return ""
+}
+
+func (u *SCIMUser) email() string {
+	if addr, err := mail.ParseAddress(u.UserName); err == nil && addr.Address == strings.TrimSpace(u.UserName) {
+		return addr.Address
+	}
+	return primary(u.Emails)
+}
+
+func (u *SCIMUser) fullName() string {
+	if u.Name != nil {
+		if name := strings.TrimSpace(u.Name.Formatted); name != "" {
+			return name
+		}
+		if name := strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName); name != "" {
+			return name
+		}
+	}
+	return strings.TrimSpace(u.DisplayName)
+}
+
+func (u *SCIMUser) address() string {
+	if len(u.Addresses) == 0 {
+		return ""
+	}
+	a := u.Addresses[0]
+	for _, candidate := range u.Addresses {
+		if candidate.Primary {
+			a = candidate
+		}
+	}
+	if a.Formatted != "" {
+		return strings.TrimSpace(a.Formatted)
+	}
+	var parts []string
+	for _, part := range []string{a.StreetAddress, a.Locality, a.Region, a.PostalCode, a.Country} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// profileUpdate returns the role's profile fields as an update DTO, so they go through
+// the same validation as an admin's profile edit.
+func (u *SCIMUser) profileUpdate(role string) profileUpdate {
+	fullName, title := u.fullName(), strings.TrimSpace(u.Title)
+	var employeeNumber, department string
+	if u.Enterprise != nil {
+		employeeNumber = strings.TrimSpace(u.Enterprise.EmployeeNumber)
+		department = strings.TrimSpace(u.Enterprise.Department)
+	}
+	switch models.ProfileKind(role) {
+	case "student":
+		phone, address := primary(u.PhoneNumbers), u.address()
+		return &StudentProfileUpdate{FullName: &fullName, RollNo: &employeeNumber, Branch: &department,
+			ContactNumber: &phone, Address: &address}
+	case "instructor":
+		return &InstructorProfileUpdate{FullName: &fullName, EmployeeID: &employeeNumber, Department: &department, Title: &title}
+	}
+	return &AdminProfileUpdate{FullName: &fullName, EmployeeID: &employeeNumber, JobTitle: &title}
+}
+
+// newProfile builds the profile record for a user created or moved to another profile table.
+func (u *SCIMUser) newProfile(role string) interface{} {
+	switch update := u.profileUpdate(role).(type) {
+	case *StudentProfileUpdate:
+		return &models.StudentProfile{FullName: *update.FullName, RollNo: *update.RollNo, Branch: *update.Branch,
+			ContactNumber: *update.ContactNumber, Address: *update.Address}
+	case *InstructorProfileUpdate:
+		return &models.InstructorProfile{FullName: *update.FullName, EmployeeID: *update.EmployeeID,
+			Department: *update.Department, Title: *update.Title}
+	case *AdminProfileUpdate:
+		return &models.AdminProfile{FullName: *update.FullName, EmployeeID: *update.EmployeeID, JobTitle: *update.JobTitle}
+	}
+	return nil
+}
+
+// validate checks the parts of the user that do not need the database and returns the
+// login email and role. currentRole is used when userType is not given.
+func (u *SCIMUser) validate(currentRole string) (email, role string, err *scim.Error) {
+	email = u.email()
+	if email == "" {
+		return "", "", scim.Errorf(http.StatusBadRequest, scim.InvalidValue, "userName or a primary email must be an email address")
+	}
+	role = strings.ToLower(strings.TrimSpace(u.UserType))
+	if role == "" {
+		role = currentRole
+	}
+	if role == "student" && currentRole == "ta" {
+		// TA status comes from TA assignments; to the provisioning system they are students.
+		role = "ta"
+	}
+	if _, verr := u.profileUpdate(role).Columns(); verr != nil {
+		return "", "", scim.Errorf(http.StatusBadRequest, scim.InvalidValue, "%v", verr)
+	}
+	return email, role, nil
+}
+
+// profileFields returns the SCIM-managed columns of a profile record.
+func profileFields(profile interface{}) map[string]interface{} {
+	switch p := profile.(type) {
+	case *models.StudentProfile:
+		return map[string]interface{}{"full_name": p.FullName, "roll_no": p.RollNo, "branch": p.Branch,
+			"contact_number": p.ContactNumber, "address": p.Address}
+	case *models.InstructorProfile:
+		return map[string]interface{}{"full_name": p.FullName, "employee_id": p.EmployeeID,
+			"department": p.Department, "title": p.Title}
+	case *models.AdminProfile:
+		return map[string]interface{}{"full_name": p.FullName, "employee_id": p.EmployeeID, "job_title": p.JobTitle}
+	}
+	return nil
+}
+
+// userProfile returns the loaded profile of a user and when it last changed.
+func userProfile(user *models.User) (interface{}, time.Time) {
+	switch {
+	case user.StudentProfile != nil:
+		return user.StudentProfile, user.StudentProfile.UpdatedAt
+	case user.InstructorProfile != nil:
+		return user.InstructorProfile, user.InstructorProfile.UpdatedAt
+	case user.AdminProfile != nil:
+		return user.AdminProfile, user.AdminProfile.UpdatedAt
+	}
+	return nil, time.Time{}
+}
+
+func userVersion(user *models.User) string {
+	_, profileUpdated := userProfile(user)
+	return scim.Version(user.UpdatedAt.UnixNano(), profileUpdated.UnixNano())
+}
+
+// scimUserResource renders a user as a SCIM User. It is a plain map so that PATCH
+// operations can be applied to it directly.
+func scimUserResource(user *models.User) map[string]interface{} {
+	location := scimBaseURL() + "/Users/" + user.ID.String()
+	profile, profileUpdated := userProfile(user)
+	lastModified := user.UpdatedAt
+	if profileUpdated.After(lastModified) {
+		lastModified = profileUpdated
+	}
+
+	res := map[string]interface{}{
+		"schemas":  []interface{}{scim.UserSchema, scim.EnterpriseUserSchema},
+		"id":       user.ID.String(),
+		"userName": user.Email,
+		"userType": user.Role,
+		"active":   user.IsActive(),
+		"emails":   []interface{}{map[string]interface{}{"value": user.Email, "type": "work", "primary": true}},
+		"groups": []interface{}{map[string]interface{}{
+			"value": user.Role, "display": user.Role, "$ref": scimBaseURL() + "/Groups/" + user.Role,
+		}},
+		"meta": map[string]interface{}{
+			"resourceType": "User",
+			"created":      user.CreatedAt,
+			"lastModified": lastModified,
+			"location":     location,
+			"version":      userVersion(user),
+		},
+	}
+	if user.ExternalID != nil {
+		res["externalId"] = *user.ExternalID
+	}
+
+	enterprise := map[string]interface{}{}
+	setIf := func(m map[string]interface{}, key, value string) {
+		if value != "" {
+			m[key] = value
+		}
+	}
+	var fullName string
+	switch p := profile.(type) {
+	case *models.StudentProfile:
+		fullName = p.FullName
+		setIf(enterprise, "employeeNumber", p.RollNo)
+		setIf(enterprise, "department", p.Branch)
+		if p.ContactNumber != "" {
+			res["phoneNumbers"] = []interface{}{map[string]interface{}{"value": p.ContactNumber, "type": "mobile"}}
+		}
+		if p.Address != "" {
+			res["addresses"] = []interface{}{map[string]interface{}{"formatted": p.Address, "type": "home"}}
+		}
+	case *models.InstructorProfile:
+		fullName = p.FullName
+		setIf(enterprise, "employeeNumber", p.EmployeeID)
+		setIf(enterprise, "department", p.Department)
+		setIf(res, "title", p.Title)
+	case *models.AdminProfile:
+		fullName = p.FullName
+		setIf(enterprise, "employeeNumber", p.EmployeeID)
+		setIf(res, "title", p.JobTitle)
+	}
+	if fullName != "" {
+		res["name"] = map[string]interface{}{"formatted": fullName}
+		res["displayName"] = fullName
+	}
+	res[scim.EnterpriseUserSchema] = enterprise
+	return res
+}
+
+// scimAuditRecord is a user resource for audit diffs, without its volatile meta and with
+// personal attributes reduced to digests.
+func scimAuditRecord(user *models.User) util.H {
+	res := scimUserResource(user)
+	delete(res, "meta")
+	return personalData(res, "schemas", "id", "userType", "active", "groups")
+}
+
+// checkSCIMManagedRole refuses privileged roles, which the provisioning client may not
+// assign or take away.
+func checkSCIMManagedRole(db *gorm.DB, role string) error {
+	privileged, err := database.IsPrivilegedRole(db, role)
+	if err != nil {
+		return err
+	}
+	if privileged {
+		return fmt.Errorf("%w: %s", database.ErrPrivilegedRole, role)
+	}
+	return nil
+}
+
+// writeSCIMError maps database and SCIM errors onto SCIM error responses.
+func writeSCIMError(w http.ResponseWriter, err error) {
+	var scimErr *scim.Error
+	switch {
+	case errors.As(err, &scimErr):
+		scim.WriteError(w, scimErr)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		scim.WriteError(w, scim.Errorf(http.StatusNotFound, "", "resource not found"))
+	case errors.Is(err, database.ErrEmailTaken), errors.Is(err, database.ErrRollNoTaken),
+		errors.Is(err, database.ErrEmployeeIDTaken), errors.Is(err, database.ErrExternalIDTaken):
+		scim.WriteError(w, scim.Errorf(http.StatusConflict, scim.Uniqueness, "%v", err))
+	case errors.Is(err, database.ErrPrivilegedRole):
+		scim.WriteError(w, scim.Errorf(http.StatusForbidden, "", "%v", err))
+	case errors.Is(err, database.ErrInvalidProfile), errors.Is(err, database.ErrUnknownRole),
+		errors.Is(err, database.ErrUseTAAssignments), errors.Is(err, database.ErrInvalidRoleName),
+		errors.Is(err, password.ErrWeakPassword):
+		scim.WriteError(w, scim.Errorf(http.StatusBadRequest, scim.InvalidValue, "%v", err))
+	default:
+		log.Printf("ERROR: SCIM request failed: %v", err)
+		scim.WriteError(w, scim.Errorf(http.StatusInternalServerError, "", "internal error"))
+	}
+}
+
+func parseFilterParam(r *http.Request) (scim.Filter, *scim.Error) {
+	expr := strings.TrimSpace(r.URL.Query().Get("filter"))
+	if expr == "" {
+		return nil, nil
+	}
+	return scim.ParseFilter(expr)
+}
+
+// loadSCIMUser reads the user named in the path, writing a 404 if there is none.
+func loadSCIMUser(db *gorm.DB, w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		scim.WriteError(w, scim.Errorf(http.StatusNotFound, "", "user not found"))
+		return nil, false
+	}
+	user, err := database.FindSCIMUser(db, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			scim.WriteError(w, scim.Errorf(http.StatusNotFound, "", "user not found"))
+		} else {
+			writeSCIMError(w, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// lockSCIMUser reads the user named in the path for a write, locking their row for the
+// rest of tx, and checks If-Match against that copy so no other change can slip in
+// between the check and the write.
+func lockSCIMUser(tx *gorm.DB, r *http.Request) (*models.User, error) {
+	userID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return nil, scim.Errorf(http.StatusNotFound, "", "user not found")
+	}
+	user, err := database.FindSCIMUser(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, scim.Errorf(http.StatusNotFound, "", "user not found")
+		}
+		return nil, err
+	}
+	if err := scim.CheckPrecondition(r, userVersion(user)); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func writeSCIMUser(w http.ResponseWriter, status int, user *models.User) {
+	res := scimUserResource(user)
+	w.Header().Set("ETag", userVersion(user))
+	w.Header().Set("Location", res["meta"].(map[string]interface{})["location"].(string))
+	scim.WriteJSON(w, status, res)
+}
+
+// SCIMListUsers handles GET /Users with filter, startIndex and count.
+func SCIMListUsers(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, scimErr := parseFilterParam(r)
+		if scimErr != nil {
+			scim.WriteError(w, scimErr)
+			return
+		}
+		page := scim.ParsePage(r)
+		users, total, err := database.ListSCIMUsers(db, filter, page)
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		resources := make([]interface{}, 0, len(users))
+		for i := range users {
+			resources = append(resources, scimUserResource(&users[i]))
+		}
+		scim.WriteJSON(w, http.StatusOK, scim.NewListResponse(resources, total, page))
+	}
+}
+
+// SCIMGetUser handles GET /Users/{id}, answering 304 when If-None-Match is current.
+func SCIMGetUser(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := loadSCIMUser(db, w, r)
+		if !ok {
+			return
+		}
+		if scim.NotModified(r, userVersion(user)) {
+			w.Header().Set("ETag", userVersion(user))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeSCIMUser(w, http.StatusOK, user)
+	}
+}
+
+// SCIMCreateUser handles POST /Users. Without a password the user gets a random one and
+// has to reset it (or log in through federated login).
+func SCIMCreateUser(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	policy := password.PolicyFromEnv()
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SCIMUser
+		if err := scim.DecodeBody(r, &req); err != nil {
+			scim.WriteError(w, err)
+			return
+		}
+		email, role, scimErr := req.validate(scimDefaultRole())
+		if scimErr != nil {
+			scim.WriteError(w, scimErr)
+			return
+		}
+		if role == "ta" {
+			writeSCIMError(w, database.ErrUseTAAssignments)
+			return
+		}
+		if exists, err := database.RoleExists(db, role); err != nil {
+			writeSCIMError(w, err)
+			return
+		} else if !exists {
+			writeSCIMError(w, fmt.Errorf("%w: %s", database.ErrUnknownRole, role))
+			return
+		}
+		if err := checkSCIMManagedRole(db, role); err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+
+		initialPassword := req.Password
+		if initialPassword == "" {
+			var err error
+			if initialPassword, _, err = oauth.GenerateOpaqueToken(); err != nil {
+				writeSCIMError(w, err)
+				return
+			}
+		} else if err := policy.Check(initialPassword); err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		hash, err := password.Hash(initialPassword)
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+
+		user := models.User{Email: email, PasswordHash: hash, Role: role}
+		if req.Active != nil && !bool(*req.Active) {
+			now := time.Now()
+			user.Status, user.StatusReason, user.StatusChangedAt = models.UserDeactivated, scimChangeReason, &now
+		}
+		var created *models.User
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := database.CreateUserWithProfile(tx, &user, req.newProfile(role)); err != nil {
+				return err
+			}
+			if req.ExternalID != nil {
+				if err := database.SetExternalID(tx, user.ID, req.ExternalID); err != nil {
+					return err
+				}
+			}
+			var err error
+			if created, err = database.FindSCIMUser(tx, user.ID); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "user.create", "user", user.ID.String(), nil, scimAuditRecord(created))
+		})
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIMUser(w, http.StatusCreated, created)
+	}
+}
+
+// SCIMReplaceUser handles PUT /Users/{id}. Attributes left out are cleared, except
+// userType and active, which keep their current values.
+func SCIMReplaceUser(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SCIMUser
+		if err := scim.DecodeBody(r, &req); err != nil {
+			scim.WriteError(w, err)
+			return
+		}
+		var updated *models.User
+		err := db.Transaction(func(tx *gorm.DB) error {
+			user, err := lockSCIMUser(tx, r)
+			if err != nil {
+				return err
+			}
+			updated, err = updateSCIMUser(tx, auditLog, r, user, &req)
+			return err
+		})
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIMUser(w, http.StatusOK, updated)
+	}
+}
+
+// SCIMPatchUser handles PATCH /Users/{id} by applying the operations to the current
+// resource and saving the result as a PUT would.
+func SCIMPatchUser(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var patch scim.PatchRequest
+		if err := scim.DecodeBody(r, &patch); err != nil {
+			scim.WriteError(w, err)
+			return
+		}
+
+		var updated *models.User
+		err := db.Transaction(func(tx *gorm.DB) error {
+			user, err := lockSCIMUser(tx, r)
+			if err != nil {
+				return err
+			}
+			resource := scimUserResource(user)
+			if err := patch.Apply(resource); err != nil {
+				return err
+			}
+			var req SCIMUser
+			if err := remarshal(resource, &req); err != nil {
+				return scim.Errorf(http.StatusBadRequest, scim.InvalidValue, "%v", err)
+			}
+			updated, err = updateSCIMUser(tx, auditLog, r, user, &req)
+			return err
+		})
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		writeSCIMUser(w, http.StatusOK, updated)
+	}
+}
+
+func remarshal(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+// updateSCIMUser saves the desired state of a user locked by lockSCIMUser: externalId,
+// email, role, profile, password and status, each only if it changed. It returns the
+// user as saved.
+func updateSCIMUser(tx *gorm.DB, auditLog *audit.Logger, r *http.Request, user *models.User, req *SCIMUser) (*models.User, error) {
+	email, role, scimErr := req.validate(user.Role)
+	if scimErr != nil {
+		return nil, scimErr
+	}
+
+	if role != user.Role || email != user.Email || req.Password != "" {
+		if err := checkSCIMManagedRole(tx, user.Role); err != nil {
+			return nil, err
+		}
+	}
+	if role != user.Role {
+		if err := checkSCIMManagedRole(tx, role); err != nil {
+			return nil, err
+		}
+	}
+	if fmt.Sprint(req.ExternalID) != fmt.Sprint(user.ExternalID) {
+		if err := database.SetExternalID(tx, user.ID, req.ExternalID); err != nil {
+			return nil, err
+		}
+	}
+	if email != user.Email {
+		if _, err := database.ChangeUserEmail(tx, user.ID, email); err != nil {
+			return nil, err
+		}
+	}
+
+	sameProfile := true
+	if role != user.Role {
+		sameProfile = models.ProfileKind(role) == models.ProfileKind(user.Role)
+		if _, err := database.ChangeUserRole(tx, user.ID, role, req.newProfile(role), uuid.Nil, scimChangeReason); err != nil {
+			return nil, err
+		}
+	}
+	if sameProfile {
+		columns, err := req.profileUpdate(role).Columns()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", database.ErrInvalidProfile, err)
+		}
+		current, _ := userProfile(user)
+		existing := profileFields(current)
+		for column, value := range columns {
+			if fmt.Sprint(existing[column]) == fmt.Sprint(value) {
+				delete(columns, column)
+			}
+		}
+		if len(columns) > 0 {
+			if err := database.UpdateUserProfile(tx, user.ID, role, columns); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if req.Password != "" {
+		policy := password.PolicyFromEnv()
+		if err := policy.Check(req.Password); err != nil {
+			return nil, err
+		}
+		if err := database.CheckPasswordReuse(tx, user.ID, req.Password, policy.HistorySize); err != nil {
+			return nil, err
+		}
+		hash, err := password.Hash(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		if err := database.UpdatePasswordHash(tx, user.ID, hash, policy.HistorySize); err != nil {
+			return nil, err
+		}
+	}
+	if req.Active != nil && bool(*req.Active) != user.IsActive() {
+		status := models.UserDeactivated
+		if *req.Active {
+			status = models.UserActive
+		}
+		if _, err := database.SetUserStatus(tx, user.ID, status, scimChangeReason); err != nil {
+			return nil, err
+		}
+	}
+
+	updated, err := database.FindSCIMUser(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = recordAudit(tx, auditLog, r, "user.scim_update", "user", user.ID.String(), scimAuditRecord(user), scimAuditRecord(updated))
+	return updated, err
+}
+
+// SCIMDeleteUser handles DELETE /Users/{id}. The user is deactivated, not removed, so
+// their registrations, grades and audit history stay intact; GET keeps returning them
+// with active set to false.
+func SCIMDeleteUser(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			user, err := lockSCIMUser(tx, r)
+			if err != nil || user.Status == models.UserDeactivated {
+				return err
+			}
+			updated, err := database.SetUserStatus(tx, user.ID, models.UserDeactivated, "Deleted through SCIM provisioning")
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "user.deactivate", "user", user.ID.String(),
+				accountStatus{user.Status, user.StatusReason}, accountStatus{updated.Status, updated.StatusReason})
+		})
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// --- Discovery ---
+
+// SCIMServiceProviderConfig describes which optional SCIM features are supported.
+func SCIMServiceProviderConfig() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scim.WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"schemas":          []string{scim.ServiceConfigSchema},
+			"documentationUri": scimBaseURL(),
+			"patch":            map[string]interface{}{"supported": true},
+			"bulk":             map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+			"filter":           map[string]interface{}{"supported": true, "maxResults": scim.MaxPageSize},
+			"changePassword":   map[string]interface{}{"supported": true},
+			"sort":             map[string]interface{}{"supported": false},
+			"etag":             map[string]interface{}{"supported": true},
+			"authenticationSchemes": []interface{}{map[string]interface{}{
+				"type":        "oauthbearertoken",
+				"name":        "Provisioning token",
+				"description": "The bearer token configured in SCIM_TOKEN",
+			}},
+		})
+	}
+}
+
+// SCIMResourceTypes lists the User and Group resource types.
+func SCIMResourceTypes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		types := []interface{}{
+			map[string]interface{}{
+				"schemas":  []string{scim.ResourceTypeSchema},
+				"id":       "User",
+				"name":     "User",
+				"endpoint": "/Users",
+				"schema":   scim.UserSchema,
+				"schemaExtensions": []interface{}{
+					map[string]interface{}{"schema": scim.EnterpriseUserSchema, "required": false},
+				},
+			},
+			map[string]interface{}{
+				"schemas":  []string{scim.ResourceTypeSchema},
+				"id":       "Group",
+				"name":     "Group",
+				"endpoint": "/Groups",
+				"schema":   scim.GroupSchema,
+			},
+		}
+		scim.WriteJSON(w, http.StatusOK, scim.NewListResponse(types, int64(len(types)), scim.Page{StartIndex: 1}))
+	}
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"auth/internal/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lms/pkg/audit"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ServiceAccountRequest is the body for creating a service account.
+type ServiceAccountRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes"`
+}
+
+// ServiceAccountUpdate is the body for PATCH /admin/service-accounts/{id}; omitted fields are unchanged.
+type ServiceAccountUpdate struct {
+	Description *string  `json:"description"`
+	Scopes      []string `json:"scopes"`
+	Disabled    *bool    `json:"disabled"`
+}
+
+// APIKeyRequest is the body for issuing an API key. ExpiresIn is a Go duration such as
+// "2160h"; without it the key does not expire. Scopes narrow the account's scopes.
+type APIKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expiresIn"`
+}
+
+// serviceAccountRecord is what the audit log records for a service account.
+type serviceAccountRecord struct {
+	Description string `json:"description"`
+	Scopes      string `json:"scopes"`
+	Disabled    bool   `json:"disabled"`
+}
+
+// CreateServiceAccount registers a machine client. It has no keys until one is issued,
+// and its scopes are limited to service-only scopes and permissions the caller holds.
+func CreateServiceAccount(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ServiceAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		createdBy, err := uuid.Parse(fmt.Sprint(r.Context().Value(middleware.UserIDContextKey)))
+		if err != nil {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Could not identify user from context"})
+			return
+		}
+
+		granted, _ := r.Context().Value(middleware.PermissionsContextKey).([]string)
+
+		var account *models.ServiceAccount
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if account, err = database.CreateServiceAccount(tx, strings.TrimSpace(req.Name), req.Description, req.Scopes, createdBy, granted); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "service_account.create", "service_account", account.ID.String(), nil,
+				serviceAccountRecord{account.Description, account.Scopes, account.Disabled})
+		})
+		if err != nil {
+			writeServiceAccountError(w, err)
+			return
+		}
+		util.WriteJSON(w, http.StatusCreated, util.H{"message": "Service account created", "serviceAccount": account})
+	}
+}
+
+// ListServiceAccounts returns every service account with its keys. Key hashes are never included.
+func ListServiceAccounts(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accounts, err := database.ListServiceAccounts(db)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to list service accounts"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"serviceAccounts": accounts})
+	}
+}
+
+// UpdateServiceAccount changes a service account's description or scopes, or disables it.
+func UpdateServiceAccount(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid service account ID format"})
+			return
+		}
+		var req ServiceAccountUpdate
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+
+		granted, _ := r.Context().Value(middleware.PermissionsContextKey).([]string)
+
+		var account *models.ServiceAccount
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var before models.ServiceAccount
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&before, "id = ?", accountID).Error; err != nil {
+				return err
+			}
+			var err error
+			if account, err = database.UpdateServiceAccount(tx, accountID, req.Description, req.Scopes, req.Disabled, granted); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "service_account.update", "service_account", accountID.String(),
+				serviceAccountRecord{before.Description, before.Scopes, before.Disabled},
+				serviceAccountRecord{account.Description, account.Scopes, account.Disabled})
+		})
+		if err != nil {
+			writeServiceAccountError(w, err)
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Service account updated", "serviceAccount": account})
+	}
+}
+
+// CreateAPIKey issues a key for a service account. The key is only ever shown in this response.
+func CreateAPIKey(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid service account ID format"})
+			return
+		}
+		var req APIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		var expiresAt *time.Time
+		if req.ExpiresIn != "" {
+			ttl, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || ttl <= 0 {
+				util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "expiresIn must be a positive duration such as \"720h\""})
+				return
+			}
+			expiry := time.Now().Add(ttl)
+			expiresAt = &expiry
+		}
+
+		var key *models.APIKey
+		var rawKey string
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if key, rawKey, err = database.CreateAPIKey(tx, accountID, strings.TrimSpace(req.Name), req.Scopes, expiresAt); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "service_account.key_create", "service_account", accountID.String(), nil,
+				util.H{"keyId": key.ID, "prefix": key.Prefix, "scopes": key.Scopes, "expiresAt": key.ExpiresAt})
+		})
+		if err != nil {
+			writeServiceAccountError(w, err)
+			return
+		}
+		util.WriteJSON(w, http.StatusCreated, util.H{
+			"message":  "API key created. Store it now; it cannot be shown again.",
+			"apiKey":   rawKey,
+			"clientId": accountID,
+			"key":      key,
+		})
+	}
+}
+
+// RevokeAPIKey stops a key from being exchanged for new tokens. Tokens it already
+// obtained stay valid until they expire.
+func RevokeAPIKey(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid service account ID format"})
+			return
+		}
+		keyID, err := uuid.Parse(r.PathValue("keyId"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid key ID format"})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			key, err := database.RevokeAPIKey(tx, accountID, keyID)
+			if err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "service_account.key_revoke", "service_account", accountID.String(),
+				util.H{"keyId": key.ID, "prefix": key.Prefix}, nil)
+		})
+		if err != nil {
+			writeServiceAccountError(w, err)
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "API key revoked"})
+	}
+}
+
+func writeServiceAccountError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		util.WriteJSON(w, http.StatusNotFound, util.H{"error": "Service account not found"})
+	case errors.Is(err, database.ErrAPIKeyNotFound):
+		util.WriteJSON(w, http.StatusNotFound, util.H{"error": "API key not found"})
+	case errors.Is(err, database.ErrServiceAccountNameTaken):
+		util.WriteJSON(w, http.StatusConflict, util.H{"error": err.Error()})
+	case errors.Is(err, database.ErrScopeNotHeld):
+		util.WriteJSON(w, http.StatusForbidden, util.H{"error": err.Error()})
+	case errors.Is(err, database.ErrInvalidServiceAccountName), errors.Is(err, database.ErrUnknownScope),
+		errors.Is(err, database.ErrScopeNotGranted):
+		util.WriteJSON(w, http.StatusBadRequest, util.H{"error": err.Error()})
+	default:
+		log.Printf("ERROR: %v", err)
+		util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to update service account"})
+	}
+}
+
+// clientCredentialsGrant issues a token to a service account (RFC 6749 section 4.4).
+// The client secret is one of the account's API keys; client_id is optional, since the
+// key alone identifies the account. "scope" may ask for a subset of its scopes.
+func clientCredentialsGrant(db *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	clientID, secret := clientCredentials(r)
+	if secret == "" {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client credentials are required")
+		return
+	}
+
+	account, scopes, err := database.AuthenticateClient(db, clientID, secret, strings.Fields(r.PostForm.Get("scope")))
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrInvalidClientCredentials):
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		case errors.Is(err, database.ErrScopeNotGranted):
+			writeOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		default:
+			log.Printf("ERROR: %v", err)
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to issue token")
+		}
+		return
+	}
+
+	token, err := oauth.GenerateServiceToken(account.ID.String(), scopes)
+	if err != nil {
+		log.Printf("ERROR: Could not generate service token: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to issue token")
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, util.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(oauth.AccessTokenTTL().Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	})
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	if code == "invalid_client" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="lms"`)
+	}
+	util.WriteJSON(w, status, util.H{"error": code, "error_description": description})
+}
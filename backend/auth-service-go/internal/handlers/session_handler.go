@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/util"
+	"errors"
+	"lms/pkg/audit"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLoginHistoryLimit = 50
+	maxLoginHistoryLimit     = 200
+)
+
+// ListMySessions returns the caller's active sessions, so they can see where they are
+// logged in. currentSessionId identifies the one making the request.
+func ListMySessions(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := contextUserID(w, r)
+		if !ok {
+			return
+		}
+		sessions, err := database.ListActiveSessions(db, userID)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to list sessions"})
+			return
+		}
+		current, _ := r.Context().Value(middleware.SessionIDContextKey).(string)
+		util.WriteJSON(w, http.StatusOK, util.H{"sessions": sessions, "currentSessionId": current})
+	}
+}
+
+// RevokeMySession logs the caller out of one of their sessions, e.g. on a lost device.
+func RevokeMySession(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := contextUserID(w, r)
+		if !ok {
+			return
+		}
+		if revokeSession(w, r, db, userID, nil) {
+			util.WriteJSON(w, http.StatusOK, util.H{"message": "Session revoked"})
+		}
+	}
+}
+
+// ListMyLogins returns the caller's login history; see writeLoginHistory.
+func ListMyLogins(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := contextUserID(w, r)
+		if !ok {
+			return
+		}
+		writeLoginHistory(w, r, db, userID)
+	}
+}
+
+// ListUserSessions lets an admin see a user's active sessions.
+func ListUserSessions(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+		sessions, err := database.ListActiveSessions(db, userID)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to list sessions"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"sessions": sessions})
+	}
+}
+
+// RevokeUserSession lets an admin end one of a user's sessions.
+func RevokeUserSession(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+		audited := func(tx *gorm.DB, sessionID uuid.UUID) error {
+			return recordAudit(tx, auditLog, r, "user.revoke_session", "user", userID.String(),
+				util.H{"sessionId": sessionID}, nil)
+		}
+		if revokeSession(w, r, db, userID, audited) {
+			util.WriteJSON(w, http.StatusOK, util.H{"message": "Session revoked"})
+		}
+	}
+}
+
+// ListUserLogins lets an admin see a user's login history; see writeLoginHistory.
+func ListUserLogins(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+		writeLoginHistory(w, r, db, userID)
+	}
+}
+
+// revokeSession ends the user's session named by the sessionId path value, writing the
+// error response on failure. A non-nil audited runs in the same transaction.
+func revokeSession(w http.ResponseWriter, r *http.Request, db *gorm.DB, userID uuid.UUID, audited func(tx *gorm.DB, sessionID uuid.UUID) error) bool {
+	sessionID, err := uuid.Parse(r.PathValue("sessionId"))
+	if err != nil {
+		util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid session ID format"})
+		return false
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := database.RevokeUserSession(tx, userID, sessionID); err != nil {
+			return err
+		}
+		if audited != nil {
+			return audited(tx, sessionID)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			util.WriteJSON(w, http.StatusNotFound, util.H{"error": "Session not found"})
+			return false
+		}
+		log.Printf("ERROR: %v", err)
+		util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to revoke session"})
+		return false
+	}
+	return true
+}
+
+// writeLoginHistory writes a user's login attempts, newest first.
+//
+// Query parameters:
+//   - before: RFC 3339 timestamp; only attempts made earlier, for paging (use the
+//     CreatedAt of the last attempt on the previous page)
+//   - limit: page size, at most 200 (default 50)
+func writeLoginHistory(w http.ResponseWriter, r *http.Request, db *gorm.DB, userID uuid.UUID) {
+	params := r.URL.Query()
+	before, limit := time.Now(), defaultLoginHistoryLimit
+	var err error
+	if v := params.Get("before"); v != "" {
+		if before, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "before must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "limit must be a positive number"})
+			return
+		}
+	}
+	limit = min(limit, maxLoginHistoryLimit)
+
+	attempts, err := database.ListLoginAttempts(db, userID, before, limit)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to list login history"})
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, util.H{"logins": attempts})
+}
+
+// contextUserID reads the caller's user ID from the access token, writing the error
+// response on failure.
+func contextUserID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userIDStr, _ := r.Context().Value(middleware.UserIDContextKey).(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Could not identify user from context"})
+		return uuid.Nil, false
+	}
+	return userID, true
+}
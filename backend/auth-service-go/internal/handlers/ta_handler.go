@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/erp"
+	"auth/internal/models"
+	"auth/internal/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lms/pkg/audit"
+	"lms/pkg/middleware"
+	"lms/pkg/permissions"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TAAssignmentRequest is the body for granting TA status.
+type TAAssignmentRequest struct {
+	UserID   uuid.UUID `json:"userId"`
+	CourseID uuid.UUID `json:"courseId"`
+	Semester string    `json:"semester"`
+}
+
+// GrantTA makes a student a TA for one course and semester. The course must exist in
+// the ERP service and not be archived.
+func GrantTA(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TAAssignmentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+		req.Semester = strings.TrimSpace(req.Semester)
+		if req.UserID == uuid.Nil || req.CourseID == uuid.Nil || req.Semester == "" {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "userId, courseId and semester are required"})
+			return
+		}
+		grantedBy, err := uuid.Parse(fmt.Sprint(r.Context().Value(middleware.UserIDContextKey)))
+		if err != nil {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Could not identify user from context"})
+			return
+		}
+
+		course, err := erp.GetCourse(r.Header.Get("Authorization"), req.CourseID.String())
+		if err != nil {
+			if errors.Is(err, erp.ErrCourseNotFound) {
+				util.WriteJSON(w, http.StatusNotFound, util.H{"error": "Course not found"})
+				return
+			}
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusBadGateway, util.H{"error": "Could not look up the course"})
+			return
+		}
+		if course.ArchivedAt != nil {
+			util.WriteJSON(w, http.StatusConflict, util.H{"error": "Course is archived"})
+			return
+		}
+
+		var assignment *models.TAAssignment
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if assignment, err = database.GrantTA(tx, req.UserID, req.CourseID, req.Semester, grantedBy); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "ta.grant", "user", req.UserID.String(), nil, req)
+		})
+		if err != nil {
+			writeTAError(w, err)
+			return
+		}
+		util.WriteJSON(w, http.StatusCreated, util.H{"message": "TA status granted", "assignment": assignment})
+	}
+}
+
+// RevokeTA removes a student's TA status for one course and semester.
+func RevokeTA(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.PathValue("userId"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+			return
+		}
+		courseID, err := uuid.Parse(r.PathValue("courseId"))
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid course ID format"})
+			return
+		}
+
+		semester := r.PathValue("semester")
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := database.RevokeTA(tx, userID, courseID, semester); err != nil {
+				return err
+			}
+			return recordAudit(tx, auditLog, r, "ta.revoke", "user", userID.String(),
+				TAAssignmentRequest{UserID: userID, CourseID: courseID, Semester: semester}, nil)
+		})
+		if err != nil {
+			writeTAError(w, err)
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "TA status revoked"})
+	}
+}
+
+// ListTAAssignments returns TA assignments, filtered by the optional userId, courseId
+// and semester query parameters. The gateway uses it to fill a classroom's TA list.
+// Callers with ta:manage or users:read see any assignments; anyone else must either ask
+// for their own (userId) or for a course they teach (courseId).
+func ListTAAssignments(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		var userID, courseID *uuid.UUID
+		if v := params.Get("userId"); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
+				return
+			}
+			userID = &id
+		}
+		if v := params.Get("courseId"); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid course ID format"})
+				return
+			}
+			courseID = &id
+		}
+		if !canListTAAssignments(w, r, userID, courseID) {
+			return
+		}
+
+		assignments, err := database.ListTAAssignments(db, userID, courseID, params.Get("semester"))
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to list TA assignments"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"assignments": assignments})
+	}
+}
+
+// canListTAAssignments decides whether the caller may see the assignments selected by
+// the filters, writing the error response when not.
+func canListTAAssignments(w http.ResponseWriter, r *http.Request, userID, courseID *uuid.UUID) bool {
+	if middleware.HasPermission(r.Context(), permissions.TAManage) || middleware.HasPermission(r.Context(), permissions.UsersRead) {
+		return true
+	}
+	callerID := fmt.Sprint(r.Context().Value(middleware.UserIDContextKey))
+	if userID != nil && userID.String() == callerID {
+		return true
+	}
+	if courseID != nil {
+		course, err := erp.GetCourse(r.Header.Get("Authorization"), courseID.String())
+		if err != nil && !errors.Is(err, erp.ErrCourseNotFound) {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusBadGateway, util.H{"error": "Could not look up the course"})
+			return false
+		}
+		if err == nil && course.InstructorID == callerID {
+			return true
+		}
+	}
+	util.WriteJSON(w, http.StatusForbidden, util.H{"error": "Forbidden: you can only list your own TA assignments or those of courses you teach"})
+	return false
+}
+
+func writeTAError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
+	case errors.Is(err, database.ErrTAAssignmentNotFound):
+		util.WriteJSON(w, http.StatusNotFound, util.H{"error": err.Error()})
+	case errors.Is(err, database.ErrNotAStudent), errors.Is(err, database.ErrTAAlreadyAssigned):
+		util.WriteJSON(w, http.StatusConflict, util.H{"error": err.Error()})
+	default:
+		log.Printf("ERROR: %v", err)
+		util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to update TA status"})
+	}
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/events"
+	"auth/internal/models"
+	"auth/internal/oauth"
+	"auth/internal/util"
+	"encoding/json"
+	"errors"
+	"lms/pkg/jwtauth"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RefreshRequest defines the expected JSON payload for exchanging a refresh token.
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// startSession opens a new server-side session for the user and returns the token pair for the response.
+// If the request comes from a device the user has not logged in from before, a new-device
+// event is published to notifier, which may be nil where that cannot happen.
+func startSession(db *gorm.DB, notifier events.Notifier, r *http.Request, user *models.User) (util.H, error) {
+	client := clientInfo(r)
+	session, refreshToken, err := database.CreateSession(db, user.ID, client)
+	if err != nil {
+		return nil, err
+	}
+
+	if isNew, err := database.RememberDevice(db, user.ID, client); err != nil {
+		log.Printf("ERROR: %v", err)
+	} else if isNew {
+		events.Publish(notifier, events.Event{
+			Type:      events.NewDeviceLogin,
+			UserID:    user.ID.String(),
+			Email:     user.Email,
+			SessionID: session.ID.String(),
+			IPAddress: client.IPAddress,
+			UserAgent: client.UserAgent,
+		})
+	}
+	return tokenPair(db, user, session, refreshToken)
+}
+
+// clientInfo describes the client making a request. Clients that can keep a stable
+// identifier, such as the mobile app, send it in X-Device-ID.
+func clientInfo(r *http.Request) database.ClientInfo {
+	deviceID := strings.TrimSpace(r.Header.Get("X-Device-ID"))
+	if len(deviceID) > 200 {
+		deviceID = deviceID[:200]
+	}
+	return database.ClientInfo{
+		IPAddress: middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		DeviceID:  deviceID,
+	}
+}
+
+func tokenPair(db *gorm.DB, user *models.User, session *models.Session, refreshToken string) (util.H, error) {
+	permissions, err := database.PermissionsForRole(db, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	token, err := oauth.GenerateToken(user.ID.String(), user.Role, session.ID.String(), permissions)
+	if err != nil {
+		return nil, err
+	}
+	return util.H{
+		"token":        token,
+		"refreshToken": refreshToken,
+		"expiresIn":    int(oauth.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a rotated refresh token.
+func RefreshToken(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
+			return
+		}
+
+		session, refreshToken, err := database.RotateRefreshToken(db, req.RefreshToken)
+		if err != nil {
+			if errors.Is(err, database.ErrRefreshTokenReused) {
+				log.Printf("WARN: Refresh token reuse detected; session revoked")
+			}
+			if errors.Is(err, database.ErrInvalidRefreshToken) || errors.Is(err, database.ErrRefreshTokenReused) {
+				util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Invalid or expired refresh token"})
+				return
+			}
+			log.Printf("ERROR: Failed to rotate refresh token: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to refresh token"})
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, "id = ?", session.UserID).Error; err != nil {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Invalid or expired refresh token"})
+			return
+		}
+
+		resp, err := tokenPair(db, &user, session, refreshToken)
+		if err != nil {
+			log.Printf("ERROR: Could not generate JWT: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to generate token"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+// Logout revokes the session the caller's access token belongs to.
+func Logout(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionIDStr, _ := r.Context().Value(middleware.SessionIDContextKey).(string)
+		sessionID, err := uuid.Parse(sessionIDStr)
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Token is not bound to a session"})
+			return
+		}
+
+		if err := database.RevokeSession(db, sessionID); err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to logout"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Logged out successfully"})
+	}
+}
+
+// LogoutAll revokes every session belonging to the caller, on all devices.
+func LogoutAll(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userIDStr, _ := r.Context().Value(middleware.UserIDContextKey).(string)
+		userID, err := uuid.Parse(userIDStr)
+		if err != nil {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Could not identify user from context"})
+			return
+		}
+
+		if err := database.RevokeUserSessions(db, userID); err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to logout"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"message": "Logged out of all sessions"})
+	}
+}
+
+// GetSessionStatus lets other services check whether a session is still active. The
+// caller must present an access token for that very session, as services do when they
+// validate it, so a session ID alone reveals nothing. The token's own session state is
+// not checked here, since that is the question being asked.
+func GetSessionStatus(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Authorization header is required"})
+			return
+		}
+		_, claims, err := jwtauth.ParseToken(tokenString)
+		if err != nil {
+			util.WriteJSON(w, http.StatusUnauthorized, util.H{"error": "Invalid or expired token"})
+			return
+		}
+		if claims.SessionID == "" || claims.SessionID != r.PathValue("id") {
+			util.WriteJSON(w, http.StatusForbidden, util.H{"error": "Token does not belong to this session"})
+			return
+		}
+
+		active, err := database.IsSessionActive(db, claims.SessionID)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to check session"})
+			return
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"active": active})
+	}
+}
+
+// GetJWKS publishes the public signing keys so other services can verify tokens.
+func GetJWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ks, err := oauth.CurrentKeySet()
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Signing keys unavailable"})
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		util.WriteJSON(w, http.StatusOK, util.H(ks.JWKS()))
+	}
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"auth/internal/models"
+	"auth/internal/util"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// userSortColumns maps the sort keys accepted by ListUsers to their columns.
+var userSortColumns = map[string]string{
+	"createdAt": "users.created_at",
+	"email":     "users.email",
+	"role":      "users.role",
+}
+
+// userCursor is the position of a user in a sorted listing: the sort column's value
+// plus the ID as a tie-breaker. It is handed to clients as opaque base64.
+type userCursor struct {
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+// UserEdge pairs a user with the cursor that points just after it.
+type UserEdge struct {
+	Cursor string      `json:"cursor"`
+	User   models.User `json:"user"`
+}
+
+// PageInfo tells the client whether there are more results after EndCursor.
+type PageInfo struct {
+	EndCursor   string `json:"endCursor,omitempty"`
+	HasNextPage bool   `json:"hasNextPage"`
+}
+
+// ListUsers returns a page of users for the admin directory.
+//
+// Query parameters:
+//   - first, after: page size (max 100) and the cursor to continue from
+//   - role, status, branch, department, yearOfAdmission, isTA: exact-match filters
+//   - createdAfter, createdBefore: RFC 3339 bounds on the account creation time
+//   - q: case-insensitive search over email, full name, roll number and employee ID
+//   - sort: createdAt, email or role, prefixed with "-" for descending order
+func ListUsers(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		limit := defaultPageSize
+		if first := params.Get("first"); first != "" {
+			n, err := strconv.Atoi(first)
+			if err != nil || n < 1 {
+				util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "first must be a positive integer"})
+				return
+			}
+			limit = min(n, maxPageSize)
+		}
+
+		sortKey := params.Get("sort")
+		if sortKey == "" {
+			sortKey = "createdAt"
+		}
+		descending := strings.HasPrefix(sortKey, "-")
+		sortColumn, ok := userSortColumns[strings.TrimPrefix(sortKey, "-")]
+		if !ok {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "sort must be one of createdAt, email, role"})
+			return
+		}
+
+		query, err := filterUsers(db.Model(&models.User{}), params)
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": err.Error()})
+			return
+		}
+
+		var totalCount int64
+		if err := query.Session(&gorm.Session{}).Count(&totalCount).Error; err != nil {
+			log.Printf("ERROR: Failed to count users: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to list users"})
+			return
+		}
+
+		direction, comparison := "ASC", ">"
+		if descending {
+			direction, comparison = "DESC", "<"
+		}
+		if after := params.Get("after"); after != "" {
+			cursor, err := decodeUserCursor(after)
+			if err != nil {
+				util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid cursor"})
+				return
+			}
+			var value interface{} = cursor.Value
+			if sortColumn == "users.created_at" {
+				if value, err = time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+					util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid cursor"})
+					return
+				}
+			}
+			query = query.Where(
+				fmt.Sprintf("(%s %s ?) OR (%s = ? AND users.id %s ?)", sortColumn, comparison, sortColumn, comparison),
+				value, value, cursor.ID,
+			)
+		}
+
+		var users []models.User
+		err = query.
+			Preload("StudentProfile").Preload("InstructorProfile").Preload("AdminProfile").
+			Order(fmt.Sprintf("%s %s, users.id %s", sortColumn, direction, direction)).
+			Limit(limit + 1).
+			Find(&users).Error
+		if err != nil {
+			log.Printf("ERROR: Failed to list users: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to list users"})
+			return
+		}
+
+		pageInfo := PageInfo{HasNextPage: len(users) > limit}
+		if pageInfo.HasNextPage {
+			users = users[:limit]
+		}
+		edges := make([]UserEdge, len(users))
+		for i, user := range users {
+			edges[i] = UserEdge{Cursor: encodeUserCursor(user, sortColumn), User: user}
+		}
+		if len(edges) > 0 {
+			pageInfo.EndCursor = edges[len(edges)-1].Cursor
+		}
+
+		util.WriteJSON(w, http.StatusOK, util.H{"edges": edges, "pageInfo": pageInfo, "totalCount": totalCount})
+	}
+}
+
+// filterUsers joins the profile tables and applies the directory filters from the query string.
+func filterUsers(query *gorm.DB, params url.Values) (*gorm.DB, error) {
+	query = query.Select("users.*").
+		Joins("LEFT JOIN student_profiles ON student_profiles.user_id = users.id").
+		Joins("LEFT JOIN instructor_profiles ON instructor_profiles.user_id = users.id").
+		Joins("LEFT JOIN admin_profiles ON admin_profiles.user_id = users.id")
+
+	if role := params.Get("role"); role != "" {
+		query = query.Where("users.role = ?", role)
+	}
+	if status := params.Get("status"); status != "" {
+		query = query.Where("users.status = ?", status)
+	}
+	if branch := params.Get("branch"); branch != "" {
+		query = query.Where("student_profiles.branch = ?", branch)
+	}
+	if department := params.Get("department"); department != "" {
+		query = query.Where("instructor_profiles.department = ?", department)
+	}
+	if year := params.Get("yearOfAdmission"); year != "" {
+		n, err := strconv.Atoi(year)
+		if err != nil {
+			return nil, fmt.Errorf("yearOfAdmission must be a number")
+		}
+		query = query.Where("student_profiles.year_of_admission = ?", n)
+	}
+	if isTA := params.Get("isTA"); isTA != "" {
+		b, err := strconv.ParseBool(isTA)
+		if err != nil {
+			return nil, fmt.Errorf("isTA must be true or false")
+		}
+		query = query.Where("student_profiles.is_ta = ?", b)
+	}
+	if after := params.Get("createdAfter"); after != "" {
+		t, err := time.Parse(time.RFC3339, after)
+		if err != nil {
+			return nil, fmt.Errorf("createdAfter must be an RFC 3339 timestamp")
+		}
+		query = query.Where("users.created_at >= ?", t)
+	}
+	if before := params.Get("createdBefore"); before != "" {
+		t, err := time.Parse(time.RFC3339, before)
+		if err != nil {
+			return nil, fmt.Errorf("createdBefore must be an RFC 3339 timestamp")
+		}
+		query = query.Where("users.created_at < ?", t)
+	}
+	if q := strings.TrimSpace(params.Get("q")); q != "" {
+		pattern := "%" + escapeLike(q) + "%"
+		query = query.Where(
+			"users.email ILIKE @q OR student_profiles.full_name ILIKE @q OR instructor_profiles.full_name ILIKE @q "+
+				"OR admin_profiles.full_name ILIKE @q OR student_profiles.roll_no ILIKE @q "+
+				"OR instructor_profiles.employee_id ILIKE @q OR admin_profiles.employee_id ILIKE @q",
+			map[string]interface{}{"q": pattern},
+		)
+	}
+	return query, nil
+}
+
+// escapeLike escapes the LIKE wildcards so search terms match literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func encodeUserCursor(user models.User, sortColumn string) string {
+	cursor := userCursor{ID: user.ID}
+	switch sortColumn {
+	case "users.email":
+		cursor.Value = user.Email
+	case "users.role":
+		cursor.Value = user.Role
+	default:
+		cursor.Value = user.CreatedAt.Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserCursor(s string) (userCursor, error) {
+	var cursor userCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
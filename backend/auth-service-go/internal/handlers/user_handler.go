@@ -3,20 +3,22 @@ package handlers
 import (
 	"auth/internal/database"
 	"auth/internal/models"
+	"auth/internal/password"
 	"auth/internal/util"
 	"encoding/json"
 	"errors"
+	"lms/pkg/audit"
 	"lms/pkg/middleware" // <-- THE FIX: Import the shared middleware package
+	"lms/pkg/permissions"
 	"log"
 	"net/http"
 	"strings"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
-// ... (GetUserByID, UpdateUser, CreateUser functions remain the same) ...
+// ... (GetUserByID, CreateUser functions remain the same) ...
 
 // GetCurrentUser handles a logged-in user fetching their own profile.
 func GetCurrentUser(db *gorm.DB) http.HandlerFunc {
@@ -37,7 +39,11 @@ func GetCurrentUser(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		util.WriteJSON(w, http.StatusOK, util.H{"user": user})
+		resp := util.H{"user": user}
+		if actorID, _ := r.Context().Value(middleware.ActorIDContextKey).(string); actorID != "" {
+			resp["impersonatedBy"] = actorID
+		}
+		util.WriteJSON(w, http.StatusOK, resp)
 	}
 }
 
@@ -66,56 +72,6 @@ func GetUserByID(db *gorm.DB) http.HandlerFunc {
 	}
 }
 
-func UpdateUser(db *gorm.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		userIDStr := r.PathValue("id")
-		userID, err := uuid.Parse(userIDStr)
-		if err != nil {
-			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid user ID format"})
-			return
-		}
-
-		var user models.User
-		if err := db.First(&user, "id = ?", userID).Error; err != nil {
-			util.WriteJSON(w, http.StatusNotFound, util.H{"error": "User not found"})
-			return
-		}
-
-		var updates map[string]interface{}
-		if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Invalid request payload"})
-			return
-		}
-
-		tx := db.Begin()
-		defer tx.Rollback()
-
-		var updateErr error
-		switch user.Role {
-		case "student", "ta":
-			updateErr = tx.Model(&models.StudentProfile{}).Where("user_id = ?", userID).Updates(updates).Error
-		case "instructor":
-			updateErr = tx.Model(&models.InstructorProfile{}).Where("user_id = ?", userID).Updates(updates).Error
-		case "admin":
-			updateErr = tx.Model(&models.AdminProfile{}).Where("user_id = ?", userID).Updates(updates).Error
-		}
-
-		if updateErr != nil {
-			log.Printf("ERROR: Failed to update profile: %v", updateErr)
-			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to update user profile"})
-			return
-		}
-
-		if err := tx.Commit().Error; err != nil {
-			log.Printf("ERROR: Failed to commit transaction: %v", err)
-			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to update user"})
-			return
-		}
-
-		util.WriteJSON(w, http.StatusOK, util.H{"message": "User updated successfully"})
-	}
-}
-
 type CreateUserRequest struct {
 	Email             string                    `json:"email"`
 	Password          string                    `json:"password"`
@@ -125,7 +81,19 @@ type CreateUserRequest struct {
 	AdminProfile      *models.AdminProfile      `json:"adminProfile,omitempty"`
 }
 
-func CreateUser(db *gorm.DB) http.HandlerFunc {
+// ProfileData returns the profile matching the requested role, as CreateUserProfile expects it.
+func (req *CreateUserRequest) ProfileData() interface{} {
+	switch models.ProfileKind(req.Role) {
+	case "student":
+		return req.StudentProfile
+	case "instructor":
+		return req.InstructorProfile
+	}
+	return req.AdminProfile
+}
+
+func CreateUser(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	policy := password.PolicyFromEnv()
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req CreateUserRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -137,10 +105,36 @@ func CreateUser(db *gorm.DB) http.HandlerFunc {
 			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Email, password, and role are required"})
 			return
 		}
+		if req.Role == "ta" {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": database.ErrUseTAAssignments.Error()})
+			return
+		}
+
+		if exists, err := database.RoleExists(db, req.Role); err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to create user"})
+			return
+		} else if !exists {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "Role does not exist: " + req.Role})
+			return
+		}
+		if missing, err := callerLacksRolePermission(db, r, req.Role); err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to create user"})
+			return
+		} else if missing != "" {
+			util.WriteJSON(w, http.StatusForbidden, util.H{"error": "You cannot create a user with permissions you do not have: " + missing})
+			return
+		}
+
+		if err := policy.Check(req.Password); err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": err.Error()})
+			return
+		}
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		hashedPassword, err := password.Hash(req.Password)
 		if err != nil {
-			log.Printf("ERROR: could not hash password: %v", err)
+			log.Printf("ERROR: %v", err)
 			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to process request"})
 			return
 		}
@@ -155,7 +149,7 @@ func CreateUser(db *gorm.DB) http.HandlerFunc {
 
 		user := models.User{
 			Email:        req.Email,
-			PasswordHash: string(hashedPassword),
+			PasswordHash: hashedPassword,
 			Role:         req.Role,
 		}
 		if err := tx.Create(&user).Error; err != nil {
@@ -168,17 +162,7 @@ func CreateUser(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		var profileData interface{}
-		switch req.Role {
-		case "student", "ta":
-			profileData = req.StudentProfile
-		case "instructor":
-			profileData = req.InstructorProfile
-		case "admin":
-			profileData = req.AdminProfile
-		}
-
-		err = database.CreateUserProfile(tx, user.ID, req.Role, profileData)
+		err = database.CreateUserProfile(tx, user.ID, req.Role, req.ProfileData())
 		if err != nil {
 			if strings.Contains(err.Error(), "duplicate key") && strings.Contains(err.Error(), "roll_no") {
 				util.WriteJSON(w, http.StatusConflict, util.H{"error": "Roll number already exists"})
@@ -190,6 +174,14 @@ func CreateUser(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		err = recordAudit(tx, auditLog, r, "user.create", "user", user.ID.String(), nil,
+			util.H{"email": personalDigest(user.Email), "role": user.Role, "profile": personalData(req.ProfileData(), profileAuditFields...)})
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to create user"})
+			return
+		}
+
 		if err := tx.Commit().Error; err != nil {
 			log.Printf("ERROR: could not commit transaction: %v", err)
 			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to create user"})
@@ -199,3 +191,30 @@ func CreateUser(db *gorm.DB) http.HandlerFunc {
 		util.WriteJSON(w, http.StatusCreated, util.H{"message": "User created successfully", "userID": user.ID})
 	}
 }
+
+// callerLacksRolePermission returns the first permission granted by one of the roles that
+// the caller does not hold, or "" if the caller holds them all. Managing users must never
+// hand out, or reach into accounts with, more access than the caller has.
+func callerLacksRolePermission(db *gorm.DB, r *http.Request, roles ...string) (string, error) {
+	granted, _ := r.Context().Value(middleware.PermissionsContextKey).([]string)
+	for _, role := range roles {
+		rolePermissions, err := database.PermissionsForRole(db, role)
+		if err != nil {
+			return "", err
+		}
+		if missing := permissionNotHeld(granted, rolePermissions); missing != "" {
+			return missing, nil
+		}
+	}
+	return "", nil
+}
+
+// permissionNotHeld returns the first of required that granted does not cover, or "".
+func permissionNotHeld(granted, required []string) string {
+	for _, permission := range required {
+		if !permissions.Grants(granted, permission) {
+			return permission
+		}
+	}
+	return ""
+}
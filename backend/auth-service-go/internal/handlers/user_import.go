@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"auth/internal/database"
+	"auth/internal/mailer"
+	"auth/internal/models"
+	"auth/internal/password"
+	"auth/internal/util"
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lms/pkg/audit"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// maxImportBytes and maxImportRows keep one request from tying up the service.
+	maxImportBytes = 10 << 20
+	maxImportRows  = 5000
+)
+
+// Ways to give imported users a first password when the row has none.
+const (
+	importPasswordsGenerate  = "generate"   // random password, returned in the report
+	importPasswordsResetLink = "reset-link" // unusable password, reset link emailed to the user
+)
+
+// Per-row statuses in an import report.
+const (
+	importRowValid   = "valid"   // dry run only: the row would be created
+	importRowCreated = "created" // the user was created
+	importRowFailed  = "error"   // the row was rejected; see Errors
+)
+
+// ImportRowResult is the outcome of one input row. Row numbers are 1-based and count
+// data rows only, so the CSV header is not row 1.
+type ImportRowResult struct {
+	Row             int      `json:"row"`
+	Email           string   `json:"email,omitempty"`
+	Status          string   `json:"status"`
+	Errors          []string `json:"errors,omitempty"`
+	UserID          string   `json:"userId,omitempty"`
+	InitialPassword string   `json:"initialPassword,omitempty"`
+	ResetLinkSent   bool     `json:"resetLinkSent,omitempty"`
+	req             *CreateUserRequest
+	needsPassword   bool
+}
+
+// ImportUsers creates many users from CSV (text/csv) or JSON lines (application/x-ndjson).
+//
+// CSV files need a header row. Recognised columns are email, password, role, fullName,
+// rollNo, branch, yearOfAdmission, dateOfBirth (YYYY-MM-DD), address, contactNumber,
+// fatherName, motherName, employeeId, department, title and jobTitle; columns that do not
+// apply to a row's role are ignored. JSON lines use the same shape as POST /users. As
+// there, TA status cannot be imported; it follows from TA assignments.
+//
+// With ?dryRun=true every row is validated and the report is returned without writing.
+// Rows without a password need ?passwords=generate or ?passwords=reset-link.
+func ImportUsers(db *gorm.DB, mail mailer.Mailer, auditLog *audit.Logger) http.HandlerFunc {
+	policy := password.PolicyFromEnv()
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+		passwordMode := r.URL.Query().Get("passwords")
+		if passwordMode != "" && passwordMode != importPasswordsGenerate && passwordMode != importPasswordsResetLink {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "passwords must be generate or reset-link"})
+			return
+		}
+
+		body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+		var rows []*ImportRowResult
+		var err error
+		if isJSONLines(r.Header.Get("Content-Type")) {
+			rows, err = parseJSONLinesImport(body)
+		} else {
+			rows, err = parseCSVImport(body)
+		}
+		if err != nil {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": err.Error()})
+			return
+		}
+		if len(rows) == 0 {
+			util.WriteJSON(w, http.StatusBadRequest, util.H{"error": "No rows to import"})
+			return
+		}
+
+		roles, err := database.ListRoles(db)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to validate import"})
+			return
+		}
+		rolePermissions := make(map[string][]string, len(roles))
+		for _, role := range roles {
+			names := make([]string, 0, len(role.Permissions))
+			for _, permission := range role.Permissions {
+				names = append(names, permission.Name)
+			}
+			rolePermissions[role.Name] = names
+		}
+
+		granted, _ := r.Context().Value(middleware.PermissionsContextKey).([]string)
+		validateImportRows(rows, passwordMode, rolePermissions, granted, policy)
+		if err := checkImportConflicts(db, rows); err != nil {
+			log.Printf("ERROR: Failed to check import conflicts: %v", err)
+			util.WriteJSON(w, http.StatusInternalServerError, util.H{"error": "Failed to validate import"})
+			return
+		}
+
+		if !dryRun {
+			for _, row := range rows {
+				if row.Status == importRowValid {
+					importRow(db, mail, auditLog, r, row, passwordMode)
+				}
+			}
+		}
+
+		summary := map[string]int{}
+		for _, row := range rows {
+			summary[row.Status]++
+		}
+		util.WriteJSON(w, http.StatusOK, util.H{"dryRun": dryRun, "summary": summary, "results": rows})
+	}
+}
+
+// validateImportRows applies the same checks as CreateUser to every parsed row.
+// rolePermissions maps every known role to its permissions; granted is the caller's.
+func validateImportRows(rows []*ImportRowResult, passwordMode string, rolePermissions map[string][]string, granted []string, policy *password.Policy) {
+	for _, row := range rows {
+		if row.Status == importRowFailed {
+			continue
+		}
+		req := row.req
+		if req.Email == "" || req.Role == "" {
+			row.Errors = append(row.Errors, "email and role are required")
+		} else if _, err := mail.ParseAddress(req.Email); err != nil {
+			row.Errors = append(row.Errors, "email is not a valid address")
+		}
+		rolePerms, known := rolePermissions[req.Role]
+		if req.Role == "ta" {
+			row.Errors = append(row.Errors, database.ErrUseTAAssignments.Error())
+		} else if req.Role != "" && !known {
+			row.Errors = append(row.Errors, "role does not exist: "+req.Role)
+		} else if missing := permissionNotHeld(granted, rolePerms); missing != "" {
+			row.Errors = append(row.Errors, "role "+req.Role+" grants a permission you do not have: "+missing)
+		} else if err := database.ValidateUserProfile(req.Role, req.ProfileData()); err != nil {
+			row.Errors = append(row.Errors, err.Error())
+		}
+		if req.Password == "" {
+			if passwordMode == "" {
+				row.Errors = append(row.Errors, "password is required unless passwords=generate or passwords=reset-link")
+			}
+			row.needsPassword = true
+		} else if err := policy.Check(req.Password); err != nil {
+			row.Errors = append(row.Errors, err.Error())
+		}
+		row.markValidity()
+	}
+}
+
+// importKey identifies a value that must be unique, e.g. {"roll number", "CS21001"}.
+type importKey struct{ kind, value string }
+
+// uniqueKeys lists the unique identifiers a row would claim.
+func (req *CreateUserRequest) uniqueKeys() []importKey {
+	keys := []importKey{{"email", strings.ToLower(req.Email)}}
+	switch profile := req.ProfileData().(type) {
+	case *models.StudentProfile:
+		if profile != nil && profile.RollNo != "" {
+			keys = append(keys, importKey{"roll number", profile.RollNo})
+		}
+	case *models.InstructorProfile:
+		if profile != nil && profile.EmployeeID != "" {
+			keys = append(keys, importKey{"instructor employee ID", profile.EmployeeID})
+		}
+	case *models.AdminProfile:
+		if profile != nil && profile.EmployeeID != "" {
+			keys = append(keys, importKey{"admin employee ID", profile.EmployeeID})
+		}
+	}
+	return keys
+}
+
+// checkImportConflicts flags emails, roll numbers and employee IDs that are repeated
+// within the file or already taken in the database.
+func checkImportConflicts(db *gorm.DB, rows []*ImportRowResult) error {
+	lookups := []struct{ kind, table, column string }{
+		{"email", "users", "LOWER(email)"},
+		{"roll number", "student_profiles", "roll_no"},
+		{"instructor employee ID", "instructor_profiles", "employee_id"},
+		{"admin employee ID", "admin_profiles", "employee_id"},
+	}
+
+	firstSeen := map[importKey]int{}
+	values := map[string][]string{}
+	for _, row := range rows {
+		if row.req == nil {
+			continue
+		}
+		for _, k := range row.req.uniqueKeys() {
+			if k.value == "" {
+				continue
+			}
+			if first, ok := firstSeen[k]; ok {
+				row.Errors = append(row.Errors, fmt.Sprintf("duplicate %s '%s' (also in row %d)", k.kind, k.value, first))
+				continue
+			}
+			firstSeen[k] = row.Row
+			values[k.kind] = append(values[k.kind], k.value)
+		}
+	}
+
+	existing := map[importKey]bool{}
+	for _, lookup := range lookups {
+		if len(values[lookup.kind]) == 0 {
+			continue
+		}
+		var found []string
+		if err := db.Table(lookup.table).Where(lookup.column+" IN ?", values[lookup.kind]).Pluck(lookup.column, &found).Error; err != nil {
+			return err
+		}
+		for _, value := range found {
+			existing[importKey{lookup.kind, value}] = true
+		}
+	}
+
+	for _, row := range rows {
+		if row.req == nil {
+			continue
+		}
+		for _, k := range row.req.uniqueKeys() {
+			if existing[k] {
+				row.Errors = append(row.Errors, fmt.Sprintf("%s '%s' already exists", k.kind, k.value))
+			}
+		}
+		row.markValidity()
+	}
+	return nil
+}
+
+// importRow creates one validated row in its own transaction, so a failure only affects that row.
+func importRow(db *gorm.DB, mail mailer.Mailer, auditLog *audit.Logger, r *http.Request, row *ImportRowResult, passwordMode string) {
+	req := row.req
+	initialPassword := req.Password
+	if row.needsPassword {
+		generated, err := generateInitialPassword()
+		if err != nil {
+			row.fail(err.Error())
+			return
+		}
+		initialPassword = generated
+	}
+
+	hashedPassword, err := password.Hash(initialPassword)
+	if err != nil {
+		row.fail("could not hash password")
+		return
+	}
+
+	var resetToken string
+	user := models.User{Email: req.Email, PasswordHash: hashedPassword, Role: req.Role}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		if err := database.CreateUserProfile(tx, user.ID, req.Role, req.ProfileData()); err != nil {
+			return err
+		}
+		if row.needsPassword && passwordMode == importPasswordsResetLink {
+			if resetToken, err = database.CreatePasswordResetToken(tx, user.ID); err != nil {
+				return err
+			}
+		}
+		return recordAudit(tx, auditLog, r, "user.create", "user", user.ID.String(), nil,
+			util.H{"email": personalDigest(req.Email), "role": req.Role, "profile": personalData(req.ProfileData(), profileAuditFields...), "source": "import"})
+	})
+	if err != nil {
+		row.fail(err.Error())
+		return
+	}
+
+	row.Status = importRowCreated
+	row.UserID = user.ID.String()
+	if row.needsPassword {
+		switch passwordMode {
+		case importPasswordsGenerate:
+			row.InitialPassword = initialPassword
+		case importPasswordsResetLink:
+			if err := mail.Send(passwordResetMessage(user.Email, resetToken)); err != nil {
+				log.Printf("ERROR: Failed to send reset link to imported user %s: %v", user.Email, err)
+				row.Errors = append(row.Errors, "user created, but the reset link could not be sent")
+			} else {
+				row.ResetLinkSent = true
+			}
+		}
+	}
+}
+
+func (row *ImportRowResult) fail(msg string) {
+	row.Status = importRowFailed
+	row.Errors = append(row.Errors, msg)
+}
+
+func (row *ImportRowResult) markValidity() {
+	if len(row.Errors) > 0 {
+		row.Status = importRowFailed
+	} else {
+		row.Status = importRowValid
+	}
+}
+
+func isJSONLines(contentType string) bool {
+	return strings.Contains(contentType, "ndjson") || strings.Contains(contentType, "jsonl") ||
+		strings.Contains(contentType, "json-seq")
+}
+
+func parseJSONLinesImport(body io.Reader) ([]*ImportRowResult, error) {
+	var rows []*ImportRowResult
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if len(rows) >= maxImportRows {
+			return nil, fmt.Errorf("import is limited to %d rows", maxImportRows)
+		}
+		row := &ImportRowResult{Row: len(rows) + 1}
+		var req CreateUserRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			row.fail("invalid JSON: " + err.Error())
+		} else {
+			row.req = &req
+			row.Email = req.Email
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read import: %w", err)
+	}
+	return rows, nil
+}
+
+func parseCSVImport(body io.Reader) ([]*ImportRowResult, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	var rows []*ImportRowResult
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if len(rows) >= maxImportRows {
+			return nil, fmt.Errorf("import is limited to %d rows", maxImportRows)
+		}
+		row := &ImportRowResult{Row: len(rows) + 1}
+		rows = append(rows, row)
+		if err != nil {
+			row.fail("invalid CSV: " + err.Error())
+			continue
+		}
+
+		get := func(name string) string {
+			if i, ok := columns[strings.ToLower(name)]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		req, errs := csvRowToRequest(get)
+		row.req = req
+		row.Email = req.Email
+		row.Errors = append(row.Errors, errs...)
+		if len(errs) > 0 {
+			row.Status = importRowFailed
+		}
+	}
+	return rows, nil
+}
+
+// csvRowToRequest builds a CreateUserRequest from the CSV columns relevant to the row's role.
+func csvRowToRequest(get func(string) string) (*CreateUserRequest, []string) {
+	var errs []string
+	req := &CreateUserRequest{Email: get("email"), Password: get("password"), Role: get("role")}
+
+	switch models.ProfileKind(req.Role) {
+	case "student":
+		profile := &models.StudentProfile{
+			FullName:      get("fullName"),
+			RollNo:        get("rollNo"),
+			Branch:        get("branch"),
+			Address:       get("address"),
+			ContactNumber: get("contactNumber"),
+			FatherName:    get("fatherName"),
+			MotherName:    get("motherName"),
+		}
+		if v := get("yearOfAdmission"); v != "" {
+			year, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, "yearOfAdmission must be a number")
+			} else {
+				profile.YearOfAdmission = &year
+			}
+		}
+		if v := get("dateOfBirth"); v != "" {
+			dob, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				errs = append(errs, "dateOfBirth must be YYYY-MM-DD")
+			} else {
+				profile.DateOfBirth = &dob
+			}
+		}
+		req.StudentProfile = profile
+	case "instructor":
+		req.InstructorProfile = &models.InstructorProfile{
+			FullName:   get("fullName"),
+			EmployeeID: get("employeeId"),
+			Department: get("department"),
+			Title:      get("title"),
+		}
+	default:
+		req.AdminProfile = &models.AdminProfile{
+			FullName:   get("fullName"),
+			EmployeeID: get("employeeId"),
+			JobTitle:   get("jobTitle"),
+		}
+	}
+	return req, errs
+}
+
+// generateInitialPassword returns a random 16-character password.
+func generateInitialPassword() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
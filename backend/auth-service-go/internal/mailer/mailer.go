@@ -0,0 +1,99 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers outgoing email. Handlers depend on this interface so local
+// development can run without a mail server.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromEnv picks a mailer based on MAILER: "smtp" sends through SMTP_HOST, anything else
+// falls back to the LogMailer, which writes to MAILER_FILE if it is set.
+func FromEnv() Mailer {
+	if os.Getenv("MAILER") == "smtp" {
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	}
+	return &LogMailer{Path: os.Getenv("MAILER_FILE")}
+}
+
+// LogMailer is the development stand-in. It appends each message to Path,
+// or prints it to the service log when Path is empty.
+type LogMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	formatted := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.Body)
+	if m.Path == "" {
+		log.Printf("MAIL:\n%s", formatted)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open mail file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(formatted + "----\n"); err != nil {
+		return fmt.Errorf("could not write mail file: %w", err)
+	}
+	return nil
+}
+
+// SMTPMailer sends mail through an SMTP relay using PLAIN auth when credentials are set.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	if m.Host == "" || m.From == "" {
+		return fmt.Errorf("SMTP_HOST and MAIL_FROM must be set to send mail")
+	}
+	port := m.Port
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", m.From, msg.To, msg.Subject)
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	body.WriteString(msg.Body)
+
+	if err := smtp.SendMail(m.Host+":"+port, auth, m.From, []string{msg.To}, []byte(body.String())); err != nil {
+		return fmt.Errorf("could not send mail: %w", err)
+	}
+	return nil
+}
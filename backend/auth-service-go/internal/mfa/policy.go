@@ -0,0 +1,25 @@
+package mfa
+
+import (
+	"os"
+	"strings"
+)
+
+// defaultRequiredRoles lists the roles that must use MFA when MFA_REQUIRED_ROLES is unset.
+const defaultRequiredRoles = "admin,instructor"
+
+// RequiredForRole reports whether users with the given role must enroll in MFA before
+// they can log in. The list comes from MFA_REQUIRED_ROLES (comma separated); set it to
+// "none" to make MFA optional for everyone.
+func RequiredForRole(role string) bool {
+	roles := os.Getenv("MFA_REQUIRED_ROLES")
+	if roles == "" {
+		roles = defaultRequiredRoles
+	}
+	for _, r := range strings.Split(roles, ",") {
+		if strings.TrimSpace(r) == role {
+			return true
+		}
+	}
+	return false
+}
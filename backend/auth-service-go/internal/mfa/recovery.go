@@ -0,0 +1,34 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// RecoveryCodeCount is how many one-time recovery codes a user gets at a time.
+const RecoveryCodeCount = 10
+
+// GenerateRecoveryCodes returns n random codes formatted as "xxxxx-xxxxx".
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("could not generate recovery code: %w", err)
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode lets users type codes with or without the dash and in any case.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) == 10 && !strings.Contains(code, "-") {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (identity *FederatedIdentity) BeforeCreate(tx *gorm.DB) (err error) {
+	if identity.ID == uuid.Nil {
+		identity.ID = uuid.New()
+	}
+	return
+}
+
+func (state *FederatedLoginState) BeforeCreate(tx *gorm.DB) (err error) {
+	if state.ID == uuid.Nil {
+		state.ID = uuid.New()
+	}
+	return
+}
+
+// FederatedIdentity links a user to their account at an external identity provider,
+// identified by the provider's issuer and the "sub" claim it gives the user. Once linked,
+// the user is found by the link alone, even if their email changes at either end.
+type FederatedIdentity struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;index"`
+	Issuer      string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_federated_subject"`
+	Subject     string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_federated_subject"`
+	Email       string    `gorm:"type:varchar(255)"`
+	LastLoginAt *time.Time
+	CreatedAt   time.Time
+}
+
+// FederatedLoginState is an upstream login in progress. It keeps the OAuth state, the
+// nonce expected in the ID token and the PKCE verifier on the server, so none of them
+// travel through the browser. It is deleted when the callback uses it.
+type FederatedLoginState struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key"`
+	StateHash    string    `gorm:"type:varchar(64);not null;uniqueIndex"`
+	Nonce        string    `gorm:"type:varchar(64);not null"`
+	CodeVerifier string    `gorm:"type:varchar(128);not null"`
+	ExpiresAt    time.Time `gorm:"not null;index"`
+	CreatedAt    time.Time
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (device *KnownDevice) BeforeCreate(tx *gorm.DB) (err error) {
+	if device.ID == uuid.Nil {
+		device.ID = uuid.New()
+	}
+	return
+}
+
+// KnownDevice is a device a user has logged in from. A login from a device that is not
+// yet known emits a new-device event. DeviceHash is the SHA-256 of the client's
+// X-Device-ID header, or of its user agent when it sends none.
+type KnownDevice struct {
+	ID            uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID        uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_known_device"`
+	DeviceHash    string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_known_device"`
+	UserAgent     string    `gorm:"type:text"`
+	LastIPAddress string    `gorm:"type:varchar(64)"`
+	FirstSeenAt   time.Time `gorm:"not null"`
+	LastSeenAt    time.Time `gorm:"not null"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (attempt *LoginAttempt) BeforeCreate(tx *gorm.DB) (err error) {
+	if attempt.ID == uuid.Nil {
+		attempt.ID = uuid.New()
+	}
+	return
+}
+
+// Outcomes recorded for a login attempt.
+const (
+	LoginSucceeded       = "success"
+	LoginBadCredentials  = "bad_credentials"
+	LoginAccountLocked   = "locked"
+	LoginMFAChallenged   = "mfa_challenge"
+	LoginMFAFailed       = "mfa_failed"
+	LoginAccountInactive = "inactive"
+	LoginNoAccount       = "no_account" // a federated login that matched no LMS user
+)
+
+// LoginAttempt records every password check on POST /login, every MFA code checked at
+// login and every federated login, successful or not. UserID is nil when the login did
+// not match any account. Together they make up a user's login history.
+type LoginAttempt struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key"`
+	UserID    *uuid.UUID `gorm:"type:uuid;index"`
+	Email     string     `gorm:"type:varchar(255);index"`
+	IPAddress string     `gorm:"type:varchar(64);index"`
+	UserAgent string     `gorm:"type:text"`
+	Outcome   string     `gorm:"type:varchar(30);not null"`
+	CreatedAt time.Time  `gorm:"index"`
+}
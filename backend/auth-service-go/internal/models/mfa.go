@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (credential *TOTPCredential) BeforeCreate(tx *gorm.DB) (err error) {
+	if credential.ID == uuid.Nil {
+		credential.ID = uuid.New()
+	}
+	return
+}
+
+func (code *RecoveryCode) BeforeCreate(tx *gorm.DB) (err error) {
+	if code.ID == uuid.Nil {
+		code.ID = uuid.New()
+	}
+	return
+}
+
+// TOTPCredential is a user's authenticator-app secret. It only counts as enabled once
+// the user has proven they can generate codes, which sets ConfirmedAt.
+type TOTPCredential struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	Secret      string    `gorm:"type:varchar(64);not null"`
+	ConfirmedAt *time.Time
+	// LastUsedStep is the last accepted TOTP time step, so a code cannot be replayed.
+	LastUsedStep int64 `gorm:"not null;default:0"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// RecoveryCode is a hashed single-use code for logging in without the authenticator app.
+type RecoveryCode struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	CodeHash  string    `gorm:"type:varchar(64);not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
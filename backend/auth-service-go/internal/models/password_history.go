@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (entry *PasswordHistory) BeforeCreate(tx *gorm.DB) (err error) {
+	if entry.ID == uuid.Nil {
+		entry.ID = uuid.New()
+	}
+	return
+}
+
+// PasswordHistory keeps the hashes of a user's previous passwords so the password policy
+// can refuse recent ones. Only as many as the policy looks at are kept.
+type PasswordHistory struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID       uuid.UUID `gorm:"type:uuid;not null;index"`
+	PasswordHash string    `gorm:"type:varchar(255);not null"`
+	CreatedAt    time.Time `gorm:"index"`
+}
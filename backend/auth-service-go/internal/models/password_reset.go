@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (token *PasswordResetToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+	return
+}
+
+// PasswordResetToken is a single-use token emailed to a user who forgot their password.
+// Only a hash of the token is stored.
+type PasswordResetToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	TokenHash string    `gorm:"type:varchar(64);not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Permission is one entry of the permission registry, e.g. "course:create".
+type Permission struct {
+	Name        string `gorm:"type:varchar(100);primaryKey"`
+	Description string `gorm:"type:text"`
+}
+
+// Role is a named set of permissions that users can be given. Built-in roles (admin,
+// instructor, student, ta) cannot be deleted; other roles, such as registrar staff or
+// auditors, are created by admins and use the admin profile.
+type Role struct {
+	Name        string       `gorm:"type:varchar(50);primaryKey"`
+	Description string       `gorm:"type:text"`
+	Builtin     bool         `gorm:"not null;default:false"`
+	Permissions []Permission `gorm:"many2many:role_permissions;joinForeignKey:RoleName;joinReferences:PermissionName"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ProfileKind returns which profile table a role uses: "student", "instructor" or "admin".
+// Custom roles are staff roles and use the admin profile.
+func ProfileKind(role string) string {
+	switch role {
+	case "student", "ta":
+		return "student"
+	case "instructor":
+		return "instructor"
+	}
+	return "admin"
+}
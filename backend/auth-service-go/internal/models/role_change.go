@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (change *RoleChange) BeforeCreate(tx *gorm.DB) (err error) {
+	if change.ID == uuid.Nil {
+		change.ID = uuid.New()
+	}
+	return
+}
+
+// RoleChange records who moved a user from one role to another, and why.
+type RoleChange struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	OldRole   string    `gorm:"type:varchar(50);not null"`
+	NewRole   string    `gorm:"type:varchar(50);not null"`
+	ChangedBy uuid.UUID `gorm:"type:uuid;not null"`
+	Reason    string    `gorm:"type:text"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (session *Session) BeforeCreate(tx *gorm.DB) (err error) {
+	if session.ID == uuid.Nil {
+		session.ID = uuid.New()
+	}
+	return
+}
+
+func (token *RefreshToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+	return
+}
+
+// Session is a server-side login. Every access token carries its ID in the "sid" claim,
+// so revoking the session ends all of its tokens at once. Impersonation sessions record
+// the admin acting as the user and have no refresh token. IPAddress and UserAgent are
+// those of the login that started the session.
+type Session struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key"`
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;index"`
+	ImpersonatorID *uuid.UUID `gorm:"type:uuid;index" json:",omitempty"`
+	IPAddress      string     `gorm:"type:varchar(64)"`
+	UserAgent      string     `gorm:"type:text"`
+	DeviceHash     string     `gorm:"type:varchar(64)" json:"-"`
+	ExpiresAt      time.Time  `gorm:"not null"`
+	RevokedAt      *time.Time `gorm:"index"`
+	LastUsedAt     time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// IsActive reports whether the session can still be used.
+func (session *Session) IsActive() bool {
+	return session.RevokedAt == nil && time.Now().Before(session.ExpiresAt)
+}
+
+// RefreshToken is a single-use token that can be exchanged for a new access token.
+// Each exchange marks the token used and issues its replacement in the same session.
+type RefreshToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	SessionID uuid.UUID `gorm:"type:uuid;not null;index"`
+	TokenHash string    `gorm:"type:varchar(64);not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
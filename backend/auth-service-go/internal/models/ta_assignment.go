@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func (assignment *TAAssignment) BeforeCreate(tx *gorm.DB) (err error) {
+	if assignment.ID == uuid.Nil {
+		assignment.ID = uuid.New()
+	}
+	return
+}
+
+// TAAssignment makes a student a teaching assistant for one course offering. A student
+// with at least one assignment has the "ta" role and StudentProfile.IsTA set; revoking
+// the last assignment turns them back into a plain student. CourseID refers to an ERP course.
+type TAAssignment struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_ta_assignment"`
+	CourseID  uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_ta_assignment;index"`
+	Semester  string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_ta_assignment"`
+	GrantedBy uuid.UUID `gorm:"type:uuid;not null"`
+	CreatedAt time.Time
+}
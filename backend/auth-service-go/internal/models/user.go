@@ -11,6 +11,9 @@ func (user *User) BeforeCreate(tx *gorm.DB) (err error) {
 	if user.ID == uuid.Nil {
 		user.ID = uuid.New()
 	}
+	if user.Status == "" {
+		user.Status = UserActive
+	}
 	return
 }
 
@@ -35,13 +38,27 @@ func (profile *AdminProfile) BeforeCreate(tx *gorm.DB) (err error) {
 	return
 }
 
+// Account statuses. Only active users can log in; the others keep their records
+// (registrations, grades, audit history) but every session is revoked.
+const (
+	UserActive      = "active"
+	UserSuspended   = "suspended"   // temporarily blocked, e.g. pending an investigation
+	UserDeactivated = "deactivated" // soft-deleted, e.g. graduated students or departed staff
+)
+
 // --- MODELS ---
 
 type User struct {
-	ID                uuid.UUID `gorm:"type:uuid;primary_key"`
-	Email             string    `gorm:"type:varchar(255);uniqueIndex;not null"`
-	PasswordHash      string    `gorm:"type:varchar(255);not null"`
-	Role              string    `gorm:"type:varchar(50);not null;index"`
+	ID                uuid.UUID  `gorm:"type:uuid;primary_key"`
+	Email             string     `gorm:"type:varchar(255);uniqueIndex;not null"`
+	PasswordHash      string     `gorm:"type:varchar(255);not null" json:"-"`
+	Role              string     `gorm:"type:varchar(50);not null;index"`
+	FailedLoginCount  int        `gorm:"not null;default:0" json:"-"`
+	LockedUntil       *time.Time `json:",omitempty"`
+	Status            string     `gorm:"type:varchar(20);not null;default:active;index"`
+	StatusReason      string     `gorm:"type:text" json:",omitempty"`
+	StatusChangedAt   *time.Time `json:",omitempty"`
+	ExternalID        *string    `gorm:"type:varchar(255);uniqueIndex" json:",omitempty"` // the provisioning system's ID, set through SCIM
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
 	StudentProfile    *StudentProfile    `gorm:"foreignKey:UserID"`
@@ -49,6 +66,11 @@ type User struct {
 	AdminProfile      *AdminProfile      `gorm:"foreignKey:UserID"`
 }
 
+// IsActive reports whether the user is allowed to log in.
+func (user *User) IsActive() bool {
+	return user.Status == "" || user.Status == UserActive
+}
+
 type StudentProfile struct {
 	ID              uuid.UUID `gorm:"type:uuid;primary_key"`
 	UserID          uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
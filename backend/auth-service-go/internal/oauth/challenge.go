@@ -0,0 +1,65 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// Purposes of an MFA challenge token. The token proves the password step succeeded and
+// says which second step is allowed next.
+const (
+	ChallengeVerifyMFA = "mfa_verify"
+	ChallengeEnrollMFA = "mfa_enroll"
+)
+
+// mfaChallengeTTL bounds how long a user has to finish the second login step.
+const mfaChallengeTTL = 5 * time.Minute
+
+// mfaChallengeAudience keeps challenge tokens from being mistaken for anything else.
+const mfaChallengeAudience = "lms-mfa-challenge"
+
+// ChallengeClaims are the claims of an MFA challenge token.
+type ChallengeClaims struct {
+	Purpose string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+// GenerateMFAChallenge issues a short-lived token for the second login step.
+// It is deliberately not an access token, so it cannot be used against any API.
+func GenerateMFAChallenge(userID, purpose string) (string, error) {
+	claims := ChallengeClaims{
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "lms-auth-service",
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{mfaChallengeAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return Sign(claims)
+}
+
+// ParseMFAChallenge validates a challenge token issued for the given purpose and returns its user ID.
+func ParseMFAChallenge(tokenString, purpose string) (string, error) {
+	claims := &ChallengeClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, ownKey,
+		jwt.WithAudience(mfaChallengeAudience),
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
+	if err != nil {
+		return "", fmt.Errorf("invalid MFA challenge: %w", err)
+	}
+	if claims.Purpose != purpose {
+		return "", errors.New("MFA challenge was issued for a different step")
+	}
+	return claims.Subject, nil
+}
+
+// ownKey resolves one of our own public keys for tokens we only ever verify ourselves.
+func ownKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	return PublicKey(kid)
+}
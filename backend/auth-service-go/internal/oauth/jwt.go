@@ -1,47 +1,91 @@
 package oauth
 
 import (
-	"errors"
-	"fmt"
-	"os"
+	"auth/internal/util"
+	"lms/pkg/jwtauth"
+	"lms/pkg/permissions"
+	"strings"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v5"
 )
 
+// defaultAccessTokenTTL is used when ACCESS_TOKEN_TTL is unset or invalid.
+const defaultAccessTokenTTL = 15 * time.Minute
+
 // CustomClaims defines our custom JWT claims structure. It embeds the standard
-// RegisteredClaims and adds our own custom 'Role' field.
+// RegisteredClaims and adds our own custom 'Role', 'SessionID' and 'Permissions' fields.
 type CustomClaims struct {
-	Role string `json:"role"`
+	Role        string         `json:"role"`
+	SessionID   string         `json:"sid,omitempty"`
+	Permissions []string       `json:"perms,omitempty"`
+	Actor       *jwtauth.Actor `json:"act,omitempty"`
+	ClientID    string         `json:"client_id,omitempty"`
+	Scope       string         `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a new JWT for a given user ID and role.
-func GenerateToken(userID, role string) (string, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", errors.New("JWT_SECRET environment variable is not set or empty")
+// AccessTokenTTL returns how long an access token stays valid, read from ACCESS_TOKEN_TTL (e.g. "15m").
+func AccessTokenTTL() time.Duration {
+	return util.EnvDuration("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// GenerateToken creates a new short-lived JWT for a given user ID and role, carrying the
+// role's permissions and bound to the server-side session it was issued for. It is
+// signed with the active key. Permission changes reach a user at their next refresh.
+func GenerateToken(userID, role, sessionID string, permissions []string) (string, error) {
+	return generateAccessToken(userID, role, sessionID, permissions, nil, AccessTokenTTL())
+}
+
+// GenerateImpersonationToken creates an access token for userID that names actorID in
+// the RFC 8693 "act" claim, so every service can tell an admin is viewing as the user.
+func GenerateImpersonationToken(userID, role, sessionID string, permissions []string, actorID string, ttl time.Duration) (string, error) {
+	return generateAccessToken(userID, role, sessionID, permissions, &jwtauth.Actor{Subject: actorID}, ttl)
+}
+
+// ServiceRole is the "role" claim of tokens issued to service accounts.
+const ServiceRole = "service"
+
+// GenerateServiceToken creates an access token for a service account from the
+// client-credentials grant. Its subject and client_id are the account ID, and it has no
+// session: it simply expires. Scopes that are also permissions are copied into "perms"
+// so permission-checked routes accept the token too.
+func GenerateServiceToken(accountID string, scopes []string) (string, error) {
+	var perms []string
+	for _, scope := range scopes {
+		if !permissions.IsServiceScope(scope) {
+			perms = append(perms, scope)
+		}
 	}
+	claims := CustomClaims{
+		Role:        ServiceRole,
+		Permissions: perms,
+		ClientID:    accountID,
+		Scope:       strings.Join(scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "lms-auth-service",
+			Subject:   accountID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL())),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return signWithType(claims, jwtauth.AccessTokenType)
+}
 
+func generateAccessToken(userID, role, sessionID string, permissions []string, actor *jwtauth.Actor, ttl time.Duration) (string, error) {
 	// Create our custom claims
 	claims := CustomClaims{
-		role, // Set our custom role claim
-		jwt.RegisteredClaims{
+		Role:        role,
+		SessionID:   sessionID,
+		Permissions: permissions,
+		Actor:       actor,
+		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    "lms-auth-service",
 			Subject:   userID,
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	// Create a new token object, specifying the signing method and the claims.
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Sign the token with our secret key to get the complete, signed token string.
-	tokenString, err := token.SignedString([]byte(jwtSecret))
-	if err != nil {
-		return "", fmt.Errorf("could not sign token: %w", err)
-	}
-
-	return tokenString, nil
+	return signWithType(claims, jwtauth.AccessTokenType)
 }
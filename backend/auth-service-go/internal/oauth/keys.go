@@ -0,0 +1,213 @@
+package oauth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is one private key the auth service can sign tokens with.
+type SigningKey struct {
+	ID      string // published as the "kid" header and in the JWKS
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+}
+
+// KeySet holds every key published in the JWKS; only Active is used to sign new tokens.
+// Keeping retired keys in the set lets tokens they signed stay valid until they expire.
+type KeySet struct {
+	Active *SigningKey
+	Keys   map[string]*SigningKey
+}
+
+var (
+	keysMu     sync.RWMutex
+	loadedKeys *KeySet
+)
+
+// LoadSigningKeys reads every "<kid>.pem" private key (RSA or Ed25519, PKCS#1 or PKCS#8)
+// from JWT_KEYS_DIR. JWT_ACTIVE_KID picks the signing key; by default the last kid in
+// lexical order is used, so date-named keys rotate naturally.
+//
+// Without JWT_KEYS_DIR an ephemeral RSA key is generated, which is only suitable for local development.
+func LoadSigningKeys() error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	var ks *KeySet
+	var err error
+	if dir == "" {
+		log.Println("WARNING: JWT_KEYS_DIR is not set; generating an ephemeral signing key")
+		ks, err = ephemeralKeySet()
+	} else {
+		ks, err = loadKeySetFromDir(dir, os.Getenv("JWT_ACTIVE_KID"))
+	}
+	if err != nil {
+		return err
+	}
+
+	keysMu.Lock()
+	loadedKeys = ks
+	keysMu.Unlock()
+	return nil
+}
+
+// CurrentKeySet returns the loaded key set, or an error if LoadSigningKeys has not run.
+func CurrentKeySet() (*KeySet, error) {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	if loadedKeys == nil {
+		return nil, errors.New("signing keys have not been loaded")
+	}
+	return loadedKeys, nil
+}
+
+// PublicKey returns the public half of the key with the given kid.
+func PublicKey(kid string) (crypto.PublicKey, error) {
+	ks, err := CurrentKeySet()
+	if err != nil {
+		return nil, err
+	}
+	key, ok := ks.Keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key.Private.Public(), nil
+}
+
+// Sign signs the claims with the active key and sets the "kid" header.
+func Sign(claims jwt.Claims) (string, error) {
+	return signWithType(claims, "JWT")
+}
+
+// signWithType is Sign with an explicit "typ" header, which is how access tokens
+// are told apart from every other JWT we issue.
+func signWithType(claims jwt.Claims, typ string) (string, error) {
+	ks, err := CurrentKeySet()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(ks.Active.Method, claims)
+	token.Header["kid"] = ks.Active.ID
+	token.Header["typ"] = typ
+	tokenString, err := token.SignedString(ks.Active.Private)
+	if err != nil {
+		return "", fmt.Errorf("could not sign token: %w", err)
+	}
+	return tokenString, nil
+}
+
+// JWKS returns the public keys in RFC 7517 JSON Web Key Set form.
+func (ks *KeySet) JWKS() map[string]interface{} {
+	ids := make([]string, 0, len(ks.Keys))
+	for id := range ks.Keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	keys := make([]map[string]string, 0, len(ids))
+	for _, id := range ids {
+		key := ks.Keys[id]
+		jwk := map[string]string{"kid": key.ID, "use": "sig", "alg": key.Method.Alg()}
+		switch pub := key.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		keys = append(keys, jwk)
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+func loadKeySetFromDir(dir, activeKID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list signing keys: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.pem signing keys found in %s", dir)
+	}
+	sort.Strings(paths)
+
+	ks := &KeySet{Keys: make(map[string]*SigningKey)}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := readSigningKey(path, kid)
+		if err != nil {
+			return nil, err
+		}
+		ks.Keys[kid] = key
+		if activeKID == "" {
+			ks.Active = key // paths are sorted, so the last one wins
+		}
+	}
+
+	if activeKID != "" {
+		active, ok := ks.Keys[activeKID]
+		if !ok {
+			return nil, fmt.Errorf("JWT_ACTIVE_KID %q does not match any key in %s", activeKID, dir)
+		}
+		ks.Active = active
+	}
+	return ks, nil
+}
+
+func readSigningKey(path, kid string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing key %s: %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("signing key %s has unsupported PEM type %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signing key %s: %w", path, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Private: key}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Private: key}, nil
+	default:
+		return nil, fmt.Errorf("signing key %s must be an RSA or Ed25519 key", path)
+	}
+}
+
+func ephemeralKeySet() (*KeySet, error) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate signing key: %w", err)
+	}
+	key := &SigningKey{ID: "ephemeral", Method: jwt.SigningMethodRS256, Private: private}
+	return &KeySet{Active: key, Keys: map[string]*SigningKey{key.ID: key}}, nil
+}
@@ -0,0 +1,92 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"lms/pkg/jwtauth"
+	"os"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// AuthorizationCodeTTL bounds how long a client has to redeem an authorization code.
+const AuthorizationCodeTTL = 2 * time.Minute
+
+// idTokenTTL is how long an ID token is valid. Clients are expected to start their own
+// session from it rather than keep presenting it.
+const idTokenTTL = 10 * time.Minute
+
+// Scopes an OpenID Connect client can ask for.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// SupportedOIDCScopes is what the discovery document advertises.
+var SupportedOIDCScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// Issuer is the OpenID Connect issuer identifier, read from OIDC_ISSUER. It must be the
+// public URL of the auth service, since clients fetch discovery from it.
+func Issuer() string {
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		return strings.TrimSuffix(issuer, "/")
+	}
+	return "http://localhost:8081"
+}
+
+// GenerateIDToken signs an OpenID Connect ID token for a user. userClaims carries the
+// scope-dependent claims (role, name, email, profile fields).
+func GenerateIDToken(clientID, userID, sessionID, nonce string, authTime time.Time, userClaims map[string]interface{}) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for name, value := range userClaims {
+		claims[name] = value
+	}
+	claims["iss"] = Issuer()
+	claims["sub"] = userID
+	claims["aud"] = clientID
+	claims["azp"] = clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(idTokenTTL).Unix()
+	claims["auth_time"] = authTime.Unix()
+	claims["sid"] = sessionID
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	return Sign(claims)
+}
+
+// GenerateClientAccessToken creates the access token a client receives alongside the ID
+// token. It acts for the user but carries none of their permissions, only the granted
+// scopes, and names the client as its audience; AuthMiddleware refuses it everywhere but
+// the userinfo endpoint. It shares the user's session and ends when they log out.
+func GenerateClientAccessToken(userID, role, sessionID, clientID, scope string) (string, error) {
+	claims := CustomClaims{
+		Role:      role,
+		SessionID: sessionID,
+		ClientID:  clientID,
+		Scope:     scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "lms-auth-service",
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{clientID},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL())),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return signWithType(claims, jwtauth.AccessTokenType)
+}
+
+// VerifyPKCE checks an RFC 7636 code verifier against an S256 code challenge.
+func VerifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,36 @@
+package oauth
+
+import (
+	"auth/internal/util"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// defaultRefreshTokenTTL is used when REFRESH_TOKEN_TTL is unset or invalid.
+const defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+// RefreshTokenTTL returns how long a session can be kept alive by refreshing, read from REFRESH_TOKEN_TTL.
+func RefreshTokenTTL() time.Duration {
+	return util.EnvDuration("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// GenerateOpaqueToken returns a new random bearer token along with the hash that should be stored.
+// It backs refresh tokens and password reset tokens; only the hash ever reaches the database.
+func GenerateOpaqueToken() (token string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("could not generate token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken returns the hex-encoded SHA-256 hash of an opaque token.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
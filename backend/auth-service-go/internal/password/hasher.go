@@ -0,0 +1,187 @@
+// Package password hashes, checks and vets user passwords.
+//
+// Hashes are self-describing strings that carry their algorithm and its parameters, so
+// the hashing policy can be strengthened at any time: existing hashes keep verifying,
+// and are replaced with ones made under the current policy the next time their owner
+// logs in.
+package password
+
+import (
+	"auth/internal/util"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHash is returned for a stored hash no hasher recognizes.
+var ErrUnknownHash = errors.New("unrecognized password hash format")
+
+// Hasher is one password hashing algorithm.
+type Hasher interface {
+	// Hash returns an encoded hash of password that records the algorithm and parameters.
+	Hash(password string) (string, error)
+	// Recognizes reports whether encoded was made by this algorithm.
+	Recognizes(encoded string) bool
+	// Verify reports whether password matches encoded, using the parameters stored in it.
+	Verify(encoded, password string) (bool, error)
+	// NeedsRehash reports whether encoded is weaker than what Hash produces now, either
+	// because another algorithm made it or because its parameters are lower.
+	NeedsRehash(encoded string) bool
+}
+
+// algorithms lists every format that can still be verified, newest first.
+var algorithms = []Hasher{&Argon2id{}, &Bcrypt{}}
+
+// Defaults for Argon2id, from the OWASP password storage recommendations.
+const (
+	defaultArgon2Memory      = 19 * 1024 // KiB
+	defaultArgon2Iterations  = 2
+	defaultArgon2Parallelism = 1
+)
+
+// Current returns the hasher new passwords are hashed with. PASSWORD_HASHER picks the
+// algorithm, "argon2id" (the default) or "bcrypt"; ARGON2_MEMORY_KIB,
+// ARGON2_ITERATIONS, ARGON2_PARALLELISM and BCRYPT_COST tune it.
+func Current() Hasher {
+	if os.Getenv("PASSWORD_HASHER") == "bcrypt" {
+		return &Bcrypt{Cost: util.EnvInt("BCRYPT_COST", bcrypt.DefaultCost)}
+	}
+	return &Argon2id{
+		Memory:      uint32(util.EnvInt("ARGON2_MEMORY_KIB", defaultArgon2Memory)),
+		Iterations:  uint32(util.EnvInt("ARGON2_ITERATIONS", defaultArgon2Iterations)),
+		Parallelism: uint8(util.EnvInt("ARGON2_PARALLELISM", defaultArgon2Parallelism)),
+	}
+}
+
+// Hash hashes a new password with the current hasher.
+func Hash(password string) (string, error) {
+	encoded, err := Current().Hash(password)
+	if err != nil {
+		return "", fmt.Errorf("could not hash password: %w", err)
+	}
+	return encoded, nil
+}
+
+// Verify checks password against a stored hash of any known format. When it matches,
+// rehash reports whether the hash should be replaced with Hash(password). An empty hash
+// never matches; it marks an account that cannot log in with a password.
+func Verify(encoded, password string) (ok, rehash bool, err error) {
+	if encoded == "" {
+		return false, false, nil
+	}
+	for _, h := range algorithms {
+		if h.Recognizes(encoded) {
+			ok, err := h.Verify(encoded, password)
+			if !ok || err != nil {
+				return false, false, err
+			}
+			return true, Current().NeedsRehash(encoded), nil
+		}
+	}
+	return false, false, ErrUnknownHash
+}
+
+// Argon2id hashes with Argon2id, encoded in the PHC string format:
+// $argon2id$v=19$m=<memory KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *Argon2id) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (a *Argon2id) Verify(encoded, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(candidate, key) == 1, nil
+}
+
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+	params, _, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory < a.Memory || params.Iterations < a.Iterations ||
+		params.Parallelism < a.Parallelism || len(key) < argon2KeyLength
+}
+
+func decodeArgon2id(encoded string) (params Argon2id, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("invalid argon2id key")
+	}
+	return params, salt, key, nil
+}
+
+// Bcrypt hashes with bcrypt, whose standard encoding already records the cost. It is
+// kept so hashes made before Argon2id keep working until they are rehashed.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(hash), err
+}
+
+func (b *Bcrypt) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *Bcrypt) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	if !b.Recognizes(encoded) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < b.Cost
+}
@@ -0,0 +1,121 @@
+package password
+
+import (
+	"auth/internal/util"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrWeakPassword is wrapped by every policy violation; the wrapping error says which.
+var ErrWeakPassword = errors.New("password does not meet the password policy")
+
+// ErrPasswordReused is returned for a password the user has had recently.
+var ErrPasswordReused = fmt.Errorf("%w: it matches one of your recent passwords", ErrWeakPassword)
+
+// Policy decides which new passwords are acceptable.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// HistorySize is how many of a user's most recent passwords, counting the current
+	// one, cannot be chosen again.
+	HistorySize int
+	// Breached holds upper-case hex SHA-1 digests of passwords known from breaches.
+	Breached map[string]struct{}
+}
+
+// PolicyFromEnv reads the policy from PASSWORD_MIN_LENGTH (default 8),
+// PASSWORD_MAX_LENGTH (default 128), PASSWORD_HISTORY (default 5; 1 only forbids the
+// current password) and PASSWORD_BREACHED_LIST, the path of a local list of breached
+// passwords with one per line, either in plain text or as SHA-1 hex (optionally followed
+// by ":count", as in the Have I Been Pwned downloads). Without a list no breach check is
+// made.
+func PolicyFromEnv() *Policy {
+	return &Policy{
+		MinLength:   util.EnvInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:   util.EnvInt("PASSWORD_MAX_LENGTH", 128),
+		HistorySize: util.EnvInt("PASSWORD_HISTORY", 5),
+		Breached:    breachedList(os.Getenv("PASSWORD_BREACHED_LIST")),
+	}
+}
+
+// Check vets a new password on its own; reuse is checked against the user's history by
+// the database layer.
+func (p *Policy) Check(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w: it must be at least %d characters", ErrWeakPassword, p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("%w: it must be at most %d characters", ErrWeakPassword, p.MaxLength)
+	}
+	if _, found := p.Breached[sha1Hex(password)]; found {
+		return fmt.Errorf("%w: it has appeared in a data breach", ErrWeakPassword)
+	}
+	return nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+var (
+	breachedMu    sync.Mutex
+	breachedCache = map[string]map[string]struct{}{}
+)
+
+// breachedList loads a breached-password list once per path. A list that cannot be read
+// is logged and treated as empty rather than blocking every password change.
+func breachedList(path string) map[string]struct{} {
+	if path == "" {
+		return nil
+	}
+	breachedMu.Lock()
+	defer breachedMu.Unlock()
+	if list, ok := breachedCache[path]; ok {
+		return list
+	}
+
+	list := map[string]struct{}{}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("ERROR: Could not read breached password list: %v", err)
+		breachedCache[path] = list
+		return list
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if digest, _, _ := strings.Cut(line, ":"); isSHA1Hex(digest) {
+			list[strings.ToUpper(digest)] = struct{}{}
+		} else {
+			list[sha1Hex(line)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("ERROR: Could not read breached password list: %v", err)
+	}
+	breachedCache[path] = list
+	return list
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
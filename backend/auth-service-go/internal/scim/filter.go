@@ -0,0 +1,451 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2).
+type Filter interface {
+	// Matches evaluates the filter against a resource in its JSON form.
+	Matches(resource map[string]interface{}) bool
+}
+
+// Logical joins two filters with "and" or "or".
+type Logical struct {
+	Op          string
+	Left, Right Filter
+}
+
+// Not negates a filter.
+type Not struct {
+	Filter Filter
+}
+
+// Comparison is "attr op value", or "attr pr" with a nil Value. Attr is normalized by
+// NormalizePath. Value is a string, float64, bool or nil.
+type Comparison struct {
+	Attr  string
+	Op    string
+	Value interface{}
+}
+
+// ValuePath filters the elements of a multi-valued attribute, e.g. emails[type eq "work"].
+// The attribute names inside Filter are relative to the elements.
+type ValuePath struct {
+	Attr   string
+	Filter Filter
+}
+
+var compareOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+// Core schema prefixes may be written out in full; they are dropped when normalizing.
+var corePrefixes = []string{strings.ToLower(UserSchema) + ":", strings.ToLower(GroupSchema) + ":"}
+
+// NormalizePath lowercases an attribute path and strips a core schema prefix, so
+// "urn:ietf:params:scim:schemas:core:2.0:User:userName" becomes "username". Extension
+// attributes keep their (lowercased) schema URN.
+func NormalizePath(path string) string {
+	path = strings.ToLower(strings.TrimSpace(path))
+	for _, prefix := range corePrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return strings.TrimPrefix(path, prefix)
+		}
+	}
+	return path
+}
+
+// ParseFilter parses a filter expression. Errors are SCIM invalidFilter errors.
+func ParseFilter(expr string) (Filter, *Error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, Errorf(http.StatusBadRequest, InvalidFilter, "%v", err)
+	}
+	p := &filterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, Errorf(http.StatusBadRequest, InvalidFilter, "invalid filter: %v", err)
+	}
+	return f, nil
+}
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokOpen
+	tokClose
+	tokOpenBracket
+	tokCloseBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokOpen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokClose, ")"})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{tokOpenBracket, "["})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{tokCloseBracket, "]"})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(expr) && expr[j] != '"'; j++ {
+				if expr[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			var s string
+			if err := json.Unmarshal([]byte(expr[i:j+1]), &s); err != nil {
+				return nil, fmt.Errorf("invalid string %s", expr[i:j+1])
+			}
+			tokens = append(tokens, token{tokString, s})
+			i = j + 1
+		default:
+			j := i
+			for j < len(expr) && isWordChar(rune(expr[j])) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, token{tokWord, expr[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._:$-+", r)
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *filterParser) peekKeyword(word string) bool {
+	t := p.peek()
+	return t != nil && t.kind == tokWord && strings.EqualFold(t.text, word)
+}
+
+func (p *filterParser) expect(kind tokenKind, what string) error {
+	t := p.peek()
+	if t == nil || t.kind != kind {
+		return fmt.Errorf("expected %s", what)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	if p.peekKeyword("not") {
+		p.pos++
+		if err := p.expect(tokOpen, `"(" after not`); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokClose, `")"`); err != nil {
+			return nil, err
+		}
+		return &Not{Filter: inner}, nil
+	}
+	if t.kind == tokOpen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokClose, `")"`); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+	if t.kind != tokWord {
+		return nil, fmt.Errorf("expected an attribute, got %q", t.text)
+	}
+	attr := NormalizePath(t.text)
+	p.pos++
+
+	if next := p.peek(); next != nil && next.kind == tokOpenBracket {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokCloseBracket, `"]"`); err != nil {
+			return nil, err
+		}
+		return &ValuePath{Attr: attr, Filter: inner}, nil
+	}
+
+	opTok := p.peek()
+	if opTok == nil || opTok.kind != tokWord {
+		return nil, fmt.Errorf("expected an operator after %s", t.text)
+	}
+	op := strings.ToLower(opTok.text)
+	p.pos++
+	if op == "pr" {
+		return &Comparison{Attr: attr, Op: op}, nil
+	}
+	if !compareOps[op] {
+		return nil, fmt.Errorf("unknown operator %q", opTok.text)
+	}
+
+	valTok := p.peek()
+	if valTok == nil {
+		return nil, fmt.Errorf("expected a value after %s", op)
+	}
+	p.pos++
+	if valTok.kind == tokString {
+		return &Comparison{Attr: attr, Op: op, Value: valTok.text}, nil
+	}
+	if valTok.kind != tokWord {
+		return nil, fmt.Errorf("expected a value after %s", op)
+	}
+	switch strings.ToLower(valTok.text) {
+	case "true":
+		return &Comparison{Attr: attr, Op: op, Value: true}, nil
+	case "false":
+		return &Comparison{Attr: attr, Op: op, Value: false}, nil
+	case "null":
+		return &Comparison{Attr: attr, Op: op, Value: nil}, nil
+	}
+	n, err := strconv.ParseFloat(valTok.text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", valTok.text)
+	}
+	return &Comparison{Attr: attr, Op: op, Value: n}, nil
+}
+
+func (f *Logical) Matches(resource map[string]interface{}) bool {
+	if f.Op == "and" {
+		return f.Left.Matches(resource) && f.Right.Matches(resource)
+	}
+	return f.Left.Matches(resource) || f.Right.Matches(resource)
+}
+
+func (f *Not) Matches(resource map[string]interface{}) bool {
+	return !f.Filter.Matches(resource)
+}
+
+func (f *ValuePath) Matches(resource map[string]interface{}) bool {
+	for _, element := range asList(lookup(resource, f.Attr)) {
+		if m, ok := element.(map[string]interface{}); ok && f.Filter.Matches(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Comparison) Matches(resource map[string]interface{}) bool {
+	values := asList(lookup(resource, f.Attr))
+	if f.Op == "pr" {
+		for _, v := range values {
+			if v != nil && v != "" {
+				return true
+			}
+		}
+		return false
+	}
+	if f.Op == "ne" {
+		return !(&Comparison{Attr: f.Attr, Op: "eq", Value: f.Value}).Matches(resource)
+	}
+	for _, v := range values {
+		// A multi-valued attribute compares on the "value" of its elements.
+		if m, ok := v.(map[string]interface{}); ok {
+			v = m["value"]
+		}
+		if compare(v, f.Op, f.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the value at a normalized attribute path, or nil. Names are matched
+// case-insensitively, and an extension URN selects the extension's object.
+func lookup(resource map[string]interface{}, path string) interface{} {
+	if strings.HasPrefix(path, "urn:") {
+		if i := strings.LastIndex(path, ":"); i > 0 {
+			ext, ok := getFold(resource, path[:i]).(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			return lookup(ext, path[i+1:])
+		}
+	}
+	var current interface{} = resource
+	for _, name := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			// Sub-attribute of a multi-valued attribute, e.g. emails.value
+			var collected []interface{}
+			for _, element := range asList(current) {
+				if em, ok := element.(map[string]interface{}); ok {
+					collected = append(collected, getFold(em, name))
+				}
+			}
+			current = collected
+			continue
+		}
+		current = getFold(m, name)
+	}
+	return current
+}
+
+func getFold(m map[string]interface{}, name string) interface{} {
+	return m[keyFold(m, name)]
+}
+
+// keyFold returns the key of m that equals name case-insensitively, or name itself.
+func keyFold(m map[string]interface{}, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for key := range m {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+func asList(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	}
+	return []interface{}{v}
+}
+
+func compare(actual interface{}, op string, expected interface{}) bool {
+	switch want := expected.(type) {
+	case nil:
+		return op == "eq" && actual == nil
+	case bool:
+		got, ok := actual.(bool)
+		return ok && op == "eq" && got == want
+	case float64:
+		got, ok := actual.(float64)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return got == want
+		case "gt":
+			return got > want
+		case "ge":
+			return got >= want
+		case "lt":
+			return got < want
+		case "le":
+			return got <= want
+		}
+		return false
+	case string:
+		got, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		got, want = strings.ToLower(got), strings.ToLower(want)
+		switch op {
+		case "eq":
+			return got == want
+		case "co":
+			return strings.Contains(got, want)
+		case "sw":
+			return strings.HasPrefix(got, want)
+		case "ew":
+			return strings.HasSuffix(got, want)
+		case "gt":
+			return got > want
+		case "ge":
+			return got >= want
+		case "lt":
+			return got < want
+		case "le":
+			return got <= want
+		}
+	}
+	return false
+}
@@ -0,0 +1,309 @@
+package scim
+
+import (
+	"net/http"
+	"strings"
+)
+
+// PatchRequest is the body of a PATCH request (RFC 7644 section 3.5.2).
+type PatchRequest struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []Operation `json:"Operations"`
+}
+
+// Operation is one add, replace or remove. Path may be empty for add and replace, in
+// which case Value is an object of attributes to set.
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// Apply runs the operations in order against a resource in its JSON form, changing it in
+// place. The caller turns the result back into records, validating it as it would a PUT.
+func (req *PatchRequest) Apply(resource map[string]interface{}) *Error {
+	if len(req.Operations) == 0 {
+		return Errorf(http.StatusBadRequest, InvalidSyntax, "no operations")
+	}
+	for _, op := range req.Operations {
+		if err := op.apply(resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchPath is a parsed PATCH path: attr, optionally narrowed by a value filter, and an
+// optional sub-attribute, e.g. emails[type eq "work"].value. Schema is set for
+// extension attributes.
+type patchPath struct {
+	Schema string
+	Attr   string
+	Filter Filter
+	Sub    string
+}
+
+func parsePatchPath(path string) (*patchPath, *Error) {
+	path = strings.TrimSpace(path)
+	lower := strings.ToLower(path)
+	for _, prefix := range corePrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			path, lower = path[len(prefix):], lower[len(prefix):]
+		}
+	}
+
+	p := &patchPath{}
+	if strings.HasPrefix(lower, "urn:") {
+		// The attribute follows the last ":" before any filter
+		end := len(path)
+		if i := strings.Index(path, "["); i >= 0 {
+			end = i
+		}
+		i := strings.LastIndex(path[:end], ":")
+		p.Schema, path = path[:i], path[i+1:]
+	}
+
+	if open := strings.Index(path, "["); open >= 0 {
+		close := strings.LastIndex(path, "]")
+		if close < open {
+			return nil, Errorf(http.StatusBadRequest, InvalidPath, "invalid path %q", path)
+		}
+		filter, err := ParseFilter(path[open+1 : close])
+		if err != nil {
+			return nil, Errorf(http.StatusBadRequest, InvalidPath, "invalid path filter: %s", err.Detail)
+		}
+		p.Attr, p.Filter = path[:open], filter
+		rest := path[close+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") {
+				return nil, Errorf(http.StatusBadRequest, InvalidPath, "invalid path %q", path)
+			}
+			p.Sub = rest[1:]
+		}
+	} else if attr, sub, found := strings.Cut(path, "."); found {
+		p.Attr, p.Sub = attr, sub
+	} else {
+		p.Attr = path
+	}
+	if p.Attr == "" {
+		return nil, Errorf(http.StatusBadRequest, InvalidPath, "invalid path %q", path)
+	}
+	return p, nil
+}
+
+func (op Operation) apply(resource map[string]interface{}) *Error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return Errorf(http.StatusBadRequest, InvalidSyntax, "unknown operation %q", op.Op)
+	}
+
+	if op.Path == "" {
+		if kind == "remove" {
+			return Errorf(http.StatusBadRequest, NoTarget, "remove needs a path")
+		}
+		values, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return Errorf(http.StatusBadRequest, InvalidValue, "%s without a path needs an object value", kind)
+		}
+		for name, value := range values {
+			if err := (Operation{Op: kind, Path: name, Value: value}).apply(resource); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if values, ok := op.Value.(map[string]interface{}); ok && isExtension(resource, op.Path) {
+		// The path names a whole extension, e.g. "urn:...:enterprise:2.0:User"
+		key := keyFold(resource, op.Path)
+		ext, _ := resource[key].(map[string]interface{})
+		if ext == nil || kind == "replace" {
+			ext = map[string]interface{}{}
+		}
+		for name, value := range values {
+			ext[keyFold(ext, name)] = value
+		}
+		resource[key] = ext
+		return nil
+	}
+
+	path, err := parsePatchPath(op.Path)
+	if err != nil {
+		return err
+	}
+	target := resource
+	if path.Schema != "" {
+		key := keyFold(resource, path.Schema)
+		ext, ok := resource[key].(map[string]interface{})
+		if !ok {
+			if kind == "remove" {
+				return nil
+			}
+			ext = map[string]interface{}{}
+			resource[key] = ext
+		}
+		target = ext
+	}
+	key := keyFold(target, path.Attr)
+
+	if path.Filter == nil {
+		if path.Sub == "" {
+			return setAttr(target, key, kind, op.Value)
+		}
+		parent, ok := target[key].(map[string]interface{})
+		if !ok {
+			if _, multi := target[key].([]interface{}); multi {
+				return Errorf(http.StatusBadRequest, InvalidPath, "%q is multi-valued; select elements with a filter", path.Attr)
+			}
+			if kind == "remove" {
+				return nil
+			}
+			parent = map[string]interface{}{}
+			target[key] = parent
+		}
+		return setAttr(parent, keyFold(parent, path.Sub), kind, op.Value)
+	}
+
+	elements, _ := target[key].([]interface{})
+	var kept []interface{}
+	matched := 0
+	for _, element := range elements {
+		m, ok := element.(map[string]interface{})
+		if !ok || !path.Filter.Matches(m) {
+			kept = append(kept, element)
+			continue
+		}
+		matched++
+		switch {
+		case kind == "remove" && path.Sub == "":
+			continue // drop the element
+		case kind == "remove":
+			delete(m, keyFold(m, path.Sub))
+		case path.Sub != "":
+			m[keyFold(m, path.Sub)] = op.Value
+		default:
+			values, ok := op.Value.(map[string]interface{})
+			if !ok {
+				return Errorf(http.StatusBadRequest, InvalidValue, "replacing %s elements needs an object value", path.Attr)
+			}
+			for name, value := range values {
+				m[keyFold(m, name)] = value
+			}
+		}
+		kept = append(kept, m)
+	}
+	if matched == 0 && kind != "remove" {
+		// Some clients replace e.g. emails[type eq "work"].value on a user with no work
+		// email; create the element the filter describes instead of failing.
+		element, ok := elementFromFilter(path.Filter)
+		if !ok {
+			return Errorf(http.StatusBadRequest, NoTarget, "no %s value matches the path filter", path.Attr)
+		}
+		if path.Sub != "" {
+			element[path.Sub] = op.Value
+		} else if values, ok := op.Value.(map[string]interface{}); ok {
+			for name, value := range values {
+				element[keyFold(element, name)] = value
+			}
+		}
+		kept = append(kept, element)
+	}
+	if kept == nil {
+		delete(target, key)
+	} else {
+		target[key] = kept
+	}
+	return nil
+}
+
+// setAttr applies an operation to a single attribute. Adding to a multi-valued attribute
+// appends; replacing overwrites.
+func setAttr(target map[string]interface{}, key, kind string, value interface{}) *Error {
+	switch kind {
+	case "remove":
+		// Some clients name the elements to remove in the value instead of a path filter,
+		// e.g. {"op": "remove", "path": "members", "value": [{"value": "<id>"}]}.
+		existing, isList := target[key].([]interface{})
+		if removed, ok := value.([]interface{}); ok && isList {
+			var kept []interface{}
+			for _, element := range existing {
+				if !containsValue(removed, element) {
+					kept = append(kept, element)
+				}
+			}
+			target[key] = kept
+			return nil
+		}
+		delete(target, key)
+	case "add":
+		existing, isList := target[key].([]interface{})
+		if isList {
+			target[key] = append(existing, asList(value)...)
+			return nil
+		}
+		if values, ok := value.(map[string]interface{}); ok {
+			if current, ok := target[key].(map[string]interface{}); ok {
+				for name, v := range values {
+					current[keyFold(current, name)] = v
+				}
+				return nil
+			}
+		}
+		target[key] = value
+	case "replace":
+		target[key] = value
+	}
+	return nil
+}
+
+// isExtension reports whether path names a whole schema extension of the resource.
+func isExtension(resource map[string]interface{}, path string) bool {
+	if strings.EqualFold(path, EnterpriseUserSchema) {
+		return true
+	}
+	_, ok := resource[keyFold(resource, path)].(map[string]interface{})
+	return ok && strings.HasPrefix(strings.ToLower(path), "urn:")
+}
+
+// elementFromFilter builds the element a filter of eq comparisons joined by "and"
+// describes, e.g. {"type": "work"} for type eq "work".
+func elementFromFilter(f Filter) (map[string]interface{}, bool) {
+	switch f := f.(type) {
+	case *Comparison:
+		if f.Op != "eq" || f.Value == nil || strings.Contains(f.Attr, ".") {
+			return nil, false
+		}
+		return map[string]interface{}{f.Attr: f.Value}, true
+	case *Logical:
+		if f.Op != "and" {
+			return nil, false
+		}
+		left, ok := elementFromFilter(f.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := elementFromFilter(f.Right)
+		if !ok {
+			return nil, false
+		}
+		for name, value := range right {
+			left[name] = value
+		}
+		return left, true
+	}
+	return nil, false
+}
+
+// containsValue reports whether element has the same "value" as one of list's elements.
+func containsValue(list []interface{}, element interface{}) bool {
+	m, ok := element.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, candidate := range list {
+		if c, ok := candidate.(map[string]interface{}); ok && getFold(c, "value") == getFold(m, "value") {
+			return true
+		}
+	}
+	return false
+}
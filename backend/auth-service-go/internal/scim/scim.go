@@ -0,0 +1,211 @@
+// Package scim implements the protocol side of SCIM 2.0 (RFC 7643 and RFC 7644): the
+// error and list envelopes, filter expressions, PATCH operations and ETags. How users
+// and groups map onto LMS records lives in the handlers.
+package scim
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"lms/pkg/middleware"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema URNs used by the LMS resources.
+const (
+	UserSchema           = "urn:ietf:params:scim:schemas:core:2.0:User"
+	EnterpriseUserSchema = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	GroupSchema          = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListResponseSchema   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema        = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema          = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ServiceConfigSchema  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema   = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+// ContentType is the media type of every SCIM request and response body.
+const ContentType = "application/scim+json"
+
+// Actor is recorded as the actor of changes made through SCIM, in place of a user ID.
+const Actor = "scim"
+
+// MaxPageSize caps the count parameter of list requests.
+const MaxPageSize = 200
+
+// scimType values for Error (RFC 7644 section 3.12).
+const (
+	InvalidFilter = "invalidFilter"
+	InvalidPath   = "invalidPath"
+	InvalidSyntax = "invalidSyntax"
+	InvalidValue  = "invalidValue"
+	NoTarget      = "noTarget"
+	Mutability    = "mutability"
+	Uniqueness    = "uniqueness"
+)
+
+// Error is a SCIM error response.
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// Errorf returns a SCIM error with the given status and scimType.
+func Errorf(status int, scimType, format string, args ...interface{}) *Error {
+	return &Error{Status: status, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+// WriteJSON writes a SCIM response body.
+func WriteJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// WriteError writes err in the SCIM error format.
+func WriteError(w http.ResponseWriter, err *Error) {
+	body := map[string]interface{}{
+		"schemas": []string{ErrorSchema},
+		"status":  strconv.Itoa(err.Status),
+		"detail":  err.Detail,
+	}
+	if err.ScimType != "" {
+		body["scimType"] = err.ScimType
+	}
+	WriteJSON(w, err.Status, body)
+}
+
+// Meta is the "meta" attribute of a resource.
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+	Version      string    `json:"version"`
+}
+
+// ListResponse is the envelope of a query result.
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// NewListResponse wraps one page of resources.
+func NewListResponse(resources []interface{}, total int64, page Page) ListResponse {
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	return ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: total,
+		StartIndex:   page.StartIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// Page is the 1-based startIndex and count of a list request.
+type Page struct {
+	StartIndex int
+	Count      int
+}
+
+// Offset returns the number of results to skip.
+func (p Page) Offset() int {
+	return p.StartIndex - 1
+}
+
+// ParsePage reads startIndex and count from a list request. Out of range values are
+// clamped as RFC 7644 section 3.4.2.4 asks, rather than rejected.
+func ParsePage(r *http.Request) Page {
+	page := Page{StartIndex: 1, Count: 100}
+	if v, err := strconv.Atoi(r.URL.Query().Get("startIndex")); err == nil && v > 1 {
+		page.StartIndex = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil {
+		page.Count = v
+	}
+	if page.Count < 0 {
+		page.Count = 0
+	}
+	if page.Count > MaxPageSize {
+		page.Count = MaxPageSize
+	}
+	return page
+}
+
+// Version returns a weak ETag built from the given parts, e.g. a row's updated_at.
+func Version(parts ...interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(parts...)))
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// CheckPrecondition compares a resource's current version with the request's If-Match
+// header. A missing header or "*" always matches.
+func CheckPrecondition(r *http.Request, version string) *Error {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || ifMatch == "*" {
+		return nil
+	}
+	for _, tag := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(tag) == version {
+			return nil
+		}
+	}
+	return Errorf(http.StatusPreconditionFailed, "", "resource has been modified (current version %s)", version)
+}
+
+// NotModified reports whether the request's If-None-Match header names the current version.
+func NotModified(r *http.Request, version string) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if tag = strings.TrimSpace(tag); tag == version || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireToken protects the SCIM endpoints with a static bearer token shared with the
+// provisioning client (the HR or student information system). With no token configured
+// every request is refused, so SCIM stays off until an operator sets one up.
+func RequireToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				WriteError(w, Errorf(http.StatusNotFound, "", "SCIM provisioning is not configured"))
+				return
+			}
+			presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+				WriteError(w, Errorf(http.StatusUnauthorized, "", "invalid or missing provisioning token"))
+				return
+			}
+			// Audit entries name the provisioning client as the actor.
+			ctx := context.WithValue(r.Context(), middleware.UserIDContextKey, Actor)
+			ctx = context.WithValue(ctx, middleware.UserRoleContextKey, Actor)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// DecodeBody reads a JSON request body into dest.
+func DecodeBody(r *http.Request, dest interface{}) *Error {
+	if err := json.NewDecoder(r.Body).Decode(dest); err != nil {
+		return Errorf(http.StatusBadRequest, InvalidSyntax, "invalid request body: %v", err)
+	}
+	return nil
+}
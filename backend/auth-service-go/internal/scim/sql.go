@@ -0,0 +1,143 @@
+package scim
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Kinds of Column, which decide the operators a filter may use on them.
+const (
+	StringColumn = iota
+	BoolColumn
+	TimeColumn
+)
+
+// Column maps a filterable SCIM attribute onto a SQL expression.
+type Column struct {
+	Expr      string
+	Kind      int
+	CaseExact bool // compare strings as stored rather than case-insensitively
+}
+
+// ToSQL translates a filter into a SQL condition over the given columns, which are keyed
+// by normalized attribute path. Filtering on an attribute that is not in columns is an
+// invalidFilter error. Multi-valued attributes such as emails are mapped by their
+// sub-attributes ("emails.value", "emails.type").
+func ToSQL(f Filter, columns map[string]Column) (string, []interface{}, *Error) {
+	switch f := f.(type) {
+	case *Logical:
+		left, leftArgs, err := ToSQL(f.Left, columns)
+		if err != nil {
+			return "", nil, err
+		}
+		right, rightArgs, err := ToSQL(f.Right, columns)
+		if err != nil {
+			return "", nil, err
+		}
+		return "(" + left + " " + strings.ToUpper(f.Op) + " " + right + ")", append(leftArgs, rightArgs...), nil
+	case *Not:
+		inner, args, err := ToSQL(f.Filter, columns)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT " + inner, args, nil
+	case *ValuePath:
+		return ToSQL(prefixAttrs(f.Filter, f.Attr), columns)
+	case *Comparison:
+		return comparisonSQL(f, columns)
+	}
+	return "", nil, Errorf(http.StatusBadRequest, InvalidFilter, "unsupported filter")
+}
+
+// prefixAttrs turns the relative attributes inside a value path into full paths.
+func prefixAttrs(f Filter, attr string) Filter {
+	switch f := f.(type) {
+	case *Logical:
+		return &Logical{Op: f.Op, Left: prefixAttrs(f.Left, attr), Right: prefixAttrs(f.Right, attr)}
+	case *Not:
+		return &Not{Filter: prefixAttrs(f.Filter, attr)}
+	case *Comparison:
+		return &Comparison{Attr: attr + "." + f.Attr, Op: f.Op, Value: f.Value}
+	}
+	return f
+}
+
+func comparisonSQL(f *Comparison, columns map[string]Column) (string, []interface{}, *Error) {
+	col, ok := columns[f.Attr]
+	if !ok {
+		// "emails co x" filters on the value of each email
+		col, ok = columns[f.Attr+".value"]
+	}
+	if !ok {
+		return "", nil, Errorf(http.StatusBadRequest, InvalidFilter, "filtering on %q is not supported", f.Attr)
+	}
+
+	if f.Op == "pr" {
+		if col.Kind == StringColumn {
+			return "(" + col.Expr + " IS NOT NULL AND " + col.Expr + " <> '')", nil, nil
+		}
+		return "(" + col.Expr + " IS NOT NULL)", nil, nil
+	}
+	if f.Value == nil {
+		switch f.Op {
+		case "eq":
+			return "(" + col.Expr + " IS NULL)", nil, nil
+		case "ne":
+			return "(" + col.Expr + " IS NOT NULL)", nil, nil
+		}
+		return "", nil, Errorf(http.StatusBadRequest, InvalidFilter, "null can only be compared with eq or ne")
+	}
+
+	switch col.Kind {
+	case BoolColumn:
+		b, ok := f.Value.(bool)
+		if !ok || (f.Op != "eq" && f.Op != "ne") {
+			return "", nil, Errorf(http.StatusBadRequest, InvalidFilter, "%s can only be compared to true or false with eq or ne", f.Attr)
+		}
+		if f.Op == "ne" {
+			b = !b
+		}
+		return "(" + col.Expr + " = ?)", []interface{}{b}, nil
+
+	case TimeColumn:
+		s, _ := f.Value.(string)
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return "", nil, Errorf(http.StatusBadRequest, InvalidFilter, "%s must be compared to an RFC 3339 date-time", f.Attr)
+		}
+		sqlOp, ok := orderingOps[f.Op]
+		if !ok {
+			return "", nil, Errorf(http.StatusBadRequest, InvalidFilter, "%s does not support %s", f.Attr, f.Op)
+		}
+		return "(" + col.Expr + " " + sqlOp + " ?)", []interface{}{t}, nil
+	}
+
+	s, ok := f.Value.(string)
+	if !ok {
+		return "", nil, Errorf(http.StatusBadRequest, InvalidFilter, "%s must be compared to a string", f.Attr)
+	}
+	expr, placeholder := col.Expr, "?"
+	if !col.CaseExact {
+		expr, placeholder = "LOWER("+col.Expr+")", "LOWER(?)"
+	}
+	switch f.Op {
+	case "co":
+		return "(" + expr + " LIKE " + placeholder + ")", []interface{}{"%" + escapeLike(s) + "%"}, nil
+	case "sw":
+		return "(" + expr + " LIKE " + placeholder + ")", []interface{}{escapeLike(s) + "%"}, nil
+	case "ew":
+		return "(" + expr + " LIKE " + placeholder + ")", []interface{}{"%" + escapeLike(s)}, nil
+	case "ne":
+		return "(" + col.Expr + " IS NULL OR " + expr + " <> " + placeholder + ")", []interface{}{s}, nil
+	}
+	return "(" + expr + " " + orderingOps[f.Op] + " " + placeholder + ")", []interface{}{s}, nil
+}
+
+var orderingOps = map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+
+// escapeLike escapes LIKE wildcards so a filter value matches literally (Postgres uses
+// backslash as the default escape character).
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
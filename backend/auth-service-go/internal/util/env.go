@@ -0,0 +1,25 @@
+package util
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// EnvDuration reads a time.Duration (e.g. "15m") from the environment, falling back when unset or invalid.
+func EnvDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// EnvInt reads a positive integer from the environment, falling back when unset or invalid.
+func EnvInt(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
@@ -1,5 +1,5 @@
 from fastapi import FastAPI
-from app.routers import classrooms, assignments
+from app.routers import classrooms, assignments, privacy
 
 app = FastAPI(
     title="LMS Classroom Service",
@@ -14,4 +14,5 @@ async def health_check():
 # Include all the API routers
 app.include_router(classrooms.router)
 app.include_router(assignments.router)
+app.include_router(privacy.router)
 
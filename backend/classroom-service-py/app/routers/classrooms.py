@@ -1,7 +1,7 @@
 from fastapi import APIRouter, Depends, HTTPException, status, Body
 from typing import List
 from app.models import Classroom, ClassroomIn, Module, Announcement
-from app.security import get_current_user, get_instructor_or_ta, User
+from app.security import get_current_user, get_instructor_or_ta, require_scope, CLASSROOM_SYNC, User
 from app.db import classroom_collection, PyObjectId
 from bson import ObjectId
 
@@ -31,24 +31,28 @@ async def sync_classroom_from_erp(
         instructor_id: str = Body(...),
         semester: str = Body(...),
         name: str = Body(...),
-        student_ids: List[str] = Body(...)
+        student_ids: List[str] = Body(...),
+        ta_ids: List[str] = Body(default=[]),
+        service: User = Depends(require_scope(CLASSROOM_SYNC))
 ):
     """
     (Gateway-Internal) Idempotently creates or updates a classroom.
-    This is the core of "automatic enrollment". It should ONLY be called by the gateway.
+    This is the core of "automatic enrollment". Only service accounts with the
+    classroom:sync scope (i.e. the gateway) may call it.
     """
     query = {"course_id": course_id, "semester": semester}
 
-    # We update the student list, name, and instructor every time.
+    # We update the student list, TA list, name, and instructor every time.
+    # TAs come from the auth service's per-course TA assignments.
     # We only set the other fields if the document is being created (upsert=True)
     update = {
         "$set": {
             "instructor_id": instructor_id,
             "name": name,
-            "student_ids": student_ids
+            "student_ids": student_ids,
+            "ta_ids": ta_ids
         },
         "$setOnInsert": {
-            "ta_ids": [],
             "announcements": [],
             "modules": []
         }
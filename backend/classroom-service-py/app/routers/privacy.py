@@ -0,0 +1,32 @@
+from fastapi import APIRouter, Depends
+from fastapi.encoders import jsonable_encoder
+from app.security import require_permission, PRIVACY_MANAGE, User
+from app.db import assignment_collection, submission_collection
+from bson import ObjectId
+
+router = APIRouter(
+    prefix="/privacy",
+    tags=["Privacy"]
+)
+
+
+@router.get("/users/{user_id}/submissions")
+async def export_user_submissions(
+        user_id: str,
+        admin: User = Depends(require_permission(PRIVACY_MANAGE))
+):
+    """
+    (Admin) Every submission a user has made, with the title of its assignment, for a
+    data-subject access request. The gateway adds these to the user's data export.
+    """
+    submissions = await submission_collection.find({"student_id": user_id}).to_list(None)
+
+    assignment_ids = list({s["assignment_id"] for s in submissions})
+    titles = {
+        a["_id"]: a.get("title")
+        async for a in assignment_collection.find({"_id": {"$in": assignment_ids}}, {"title": 1})
+    }
+    for submission in submissions:
+        submission["assignment_title"] = titles.get(submission["assignment_id"])
+
+    return jsonable_encoder(submissions, custom_encoder={ObjectId: str})
@@ -1,34 +1,147 @@
+import json
+import logging
 import os
-from fastapi import Depends, HTTPException, status
+import time
+import urllib.error
+import urllib.request
+from collections import OrderedDict
+from fastapi import Depends, HTTPException, Request, status
+from fastapi.concurrency import run_in_threadpool
 from fastapi.security import OAuth2PasswordBearer
 from jose import jwt, JWTError
 from pydantic import BaseModel
-from typing import Optional
+from typing import List, Optional
 
-# This MUST match the secret in your Go services (.env file)
-JWT_SECRET = os.getenv("JWT_SECRET")
-if not JWT_SECRET:
-    raise RuntimeError("JWT_SECRET environment variable is not set")
+# Tokens are signed by the auth service; we only ever fetch its public keys.
+# python-jose cannot verify EdDSA, so the auth service must sign with an RSA key.
+ALGORITHMS = ["RS256"]
+
+# Where to ask whether a token's session ("sid" claim) has been revoked.
+AUTH_SERVICE_URL = os.getenv("AUTH_SERVICE_URL", "http://localhost:8081")
+JWKS_URL = os.getenv("JWKS_URL", f"{AUTH_SERVICE_URL}/.well-known/jwks.json")
+# The key set is refetched after this long, or sooner when a token names an unknown kid.
+JWKS_MAX_AGE = 600
+JWKS_MIN_REFRESH_INTERVAL = 30
+_jwks = {"keys": None, "fetched_at": 0.0}
+# How long an "active" answer is trusted. Revoked sessions stay cached until evicted.
+SESSION_CACHE_TTL = 15
+# Upper bound on cached sessions; the least recently used answer is dropped past it.
+SESSION_CACHE_SIZE = int(os.getenv("SESSION_CACHE_SIZE", "10000"))
+
+logger = logging.getLogger(__name__)
+# Methods an impersonation token may use; every other request made with one is refused.
+READ_ONLY_METHODS = {"GET", "HEAD", "OPTIONS"}
 
-ALGORITHM = "HS256"
 # This just tells FastAPI to look for an "Authorization: Bearer <token>" header
 oauth2_scheme = OAuth2PasswordBearer(tokenUrl="token")
 
 
+def _get_jwks(kid: Optional[str]) -> dict:
+    """Returns the auth service's key set, refetching it when stale or missing the kid."""
+    keys = _jwks["keys"]
+    age = time.monotonic() - _jwks["fetched_at"]
+    known = keys is not None and any(k.get("kid") == kid for k in keys["keys"])
+    if keys is None or age >= JWKS_MAX_AGE or (not known and age >= JWKS_MIN_REFRESH_INTERVAL):
+        try:
+            with urllib.request.urlopen(JWKS_URL, timeout=5) as resp:
+                keys = json.load(resp)
+            _jwks["keys"], _jwks["fetched_at"] = keys, time.monotonic()
+        except (urllib.error.URLError, ValueError):
+            if keys is None:
+                raise
+    return keys
+
+
+class _SessionCache:
+    """A size-bounded, least-recently-used map of session ID to (active, expires_at)."""
+
+    def __init__(self, max_size: int):
+        self._entries: OrderedDict = OrderedDict()
+        self._max_size = max(max_size, 1)
+
+    def get(self, session_id: str) -> Optional[bool]:
+        entry = self._entries.get(session_id)
+        if entry is None:
+            return None
+        active, expires_at = entry
+        if time.monotonic() >= expires_at:
+            del self._entries[session_id]
+            return None
+        self._entries.move_to_end(session_id)
+        return active
+
+    def put(self, session_id: str, active: bool, ttl: float) -> None:
+        self._entries[session_id] = (active, time.monotonic() + ttl)
+        self._entries.move_to_end(session_id)
+        while len(self._entries) > self._max_size:
+            self._entries.popitem(last=False)
+
+
+_session_cache = _SessionCache(SESSION_CACHE_SIZE)
+
+
+def _is_session_active(session_id: str, token: str) -> bool:
+    """Asks the auth service whether a session is still active, caching the answer briefly.
+    The auth service only answers for the session the presented token belongs to."""
+    cached = _session_cache.get(session_id)
+    if cached is not None:
+        return cached
+
+    request = urllib.request.Request(
+        f"{AUTH_SERVICE_URL}/sessions/{session_id}",
+        headers={"Authorization": f"Bearer {token}"},
+    )
+    try:
+        with urllib.request.urlopen(request, timeout=5) as resp:
+            active = bool(json.load(resp).get("active"))
+    except urllib.error.HTTPError as e:
+        if e.code != 404:
+            raise
+        active = False
+
+    # A revoked session never comes back, so that answer only leaves the cache by eviction.
+    _session_cache.put(session_id, active, SESSION_CACHE_TTL if active else float("inf"))
+    return active
+
+
 class User(BaseModel):
     id: str  # This is the 'sub' claim
     role: str
+    permissions: List[str] = []  # The 'perms' claim, from the role's grants in the auth service
+    actor_id: Optional[str] = None  # The 'act' claim's subject when an admin is impersonating
+    client_id: Optional[str] = None  # Set when the token belongs to a service account, not a person
+    scopes: List[str] = []  # The service account token's 'scope' claim
 
+    def has_permission(self, required: str) -> bool:
+        """Mirrors permissions.Grants in the Go services: a trailing '*' grants a prefix."""
+        for granted in self.permissions:
+            if granted == required or (granted.endswith("*") and required.startswith(granted[:-1])):
+                return True
+        return False
 
-async def get_current_user(token: str = Depends(oauth2_scheme)) -> User:
+
+async def get_current_user(request: Request, token: str = Depends(oauth2_scheme)) -> User:
     credentials_exception = HTTPException(
         status_code=status.HTTP_401_UNAUTHORIZED,
         detail="Invalid or expired token",
         headers={"WWW-Authenticate": "Bearer"},
     )
+    try:
+        kid = jwt.get_unverified_header(token).get("kid")
+    except JWTError:
+        raise credentials_exception
+    try:
+        jwks = await run_in_threadpool(_get_jwks, kid)
+    except (urllib.error.URLError, ValueError):
+        raise HTTPException(
+            status_code=status.HTTP_503_SERVICE_UNAVAILABLE,
+            detail="Could not fetch signing keys",
+        )
+
     try:
         # Decode the JWT from the auth-service
-        payload = jwt.decode(token, JWT_SECRET, algorithms=[ALGORITHM])
+        # Only OpenID Connect client tokens carry an audience, and they are refused below
+        payload = jwt.decode(token, jwks, algorithms=ALGORITHMS, options={"verify_aud": False})
 
         # The Go service sets 'sub' (Subject) as the UserID and 'role' as the Role
         user_id = payload.get("sub")
@@ -37,30 +150,96 @@ async def get_current_user(token: str = Depends(oauth2_scheme)) -> User:
         if user_id is None or role is None:
             raise credentials_exception
 
-        return User(id=user_id, role=role)
-
     except JWTError:
         raise credentials_exception
 
-
-# --- RBAC Dependencies ---
-
-def get_instructor_or_ta(user: User = Depends(get_current_user)):
-    """Dependency for actions only instructors or TAs can perform."""
-    if user.role not in ["instructor", "ta"]:
+    # Sessions can be ended early (logout, revocation), so a valid signature is not enough.
+    # Every user token carries a sid; only service account tokens (client_id == sub) have
+    # no session and are bounded by their expiry alone.
+    session_id = payload.get("sid")
+    client_id = payload.get("client_id")
+    is_service = client_id is not None and client_id == user_id
+    if not session_id and not is_service:
+        raise credentials_exception
+    # Tokens issued to an OpenID Connect client on a user's behalf are only good for the
+    # auth service's userinfo endpoint; they must not act as the user here
+    if client_id is not None and not is_service:
         raise HTTPException(
             status_code=status.HTTP_403_FORBIDDEN,
-            detail="Instructor or TA access required"
+            detail="OpenID Connect client tokens are not accepted here",
         )
-    return user
+    if session_id:
+        try:
+            active = await run_in_threadpool(_is_session_active, session_id, token)
+        except (urllib.error.URLError, ValueError):
+            raise HTTPException(
+                status_code=status.HTTP_503_SERVICE_UNAVAILABLE,
+                detail="Could not verify session",
+            )
+        if not active:
+            raise credentials_exception
 
+    # Impersonation (RFC 8693 "act" claim) is read-only, and every such request is logged
+    actor_id = (payload.get("act") or {}).get("sub")
+    if actor_id:
+        logger.warning("IMPERSONATION: actor=%s subject=%s %s %s", actor_id, user_id, request.method, request.url.path)
+        if request.method not in READ_ONLY_METHODS:
+            raise HTTPException(
+                status_code=status.HTTP_403_FORBIDDEN,
+                detail="Write operations are not allowed while impersonating",
+            )
 
-def get_student(user: User = Depends(get_current_user)):
-    """Dependency for actions students can perform."""
-    # TAs are also students, so they can submit work
-    if user.role not in ["student", "ta"]:
-        raise HTTPException(
-            status_code=status.HTTP_403_FORBIDDEN,
-            detail="Student or TA access required"
-        )
-    return user
\ No newline at end of file
+    return User(
+        id=user_id,
+        role=role,
+        permissions=payload.get("perms") or [],
+        actor_id=actor_id,
+        client_id=client_id if is_service else None,
+        scopes=(payload.get("scope") or "").split(),
+    )
+
+
+# --- Permission Dependencies ---
+
+# Permission names, as registered in lms/pkg/permissions.
+CLASSROOM_MANAGE = "classroom:manage"
+CLASSROOM_SUBMIT = "classroom:submit"
+PRIVACY_MANAGE = "privacy:manage"
+# Service-only scope, held by the gateway's service account.
+CLASSROOM_SYNC = "classroom:sync"
+
+
+def require_permission(required: str):
+    """Builds a dependency that only lets through users whose token grants `required`."""
+    def dependency(user: User = Depends(get_current_user)) -> User:
+        if not user.has_permission(required):
+            raise HTTPException(
+                status_code=status.HTTP_403_FORBIDDEN,
+                detail=f"Missing permission {required}"
+            )
+        return user
+    return dependency
+
+
+def require_scope(required: str):
+    """Builds a dependency for internal-only routes: only service account tokens carrying
+    `required` in their scope claim get through, never end users."""
+    def dependency(user: User = Depends(get_current_user)) -> User:
+        if not user.client_id:
+            raise HTTPException(
+                status_code=status.HTTP_403_FORBIDDEN,
+                detail="Service account token required"
+            )
+        if required not in user.scopes:
+            raise HTTPException(
+                status_code=status.HTTP_403_FORBIDDEN,
+                detail=f"Missing scope {required}"
+            )
+        return user
+    return dependency
+
+
+# Kept under their old names so the routers read the same; both are now permission checks.
+# Instructors and TAs hold classroom:manage, students and TAs hold classroom:submit.
+get_instructor_or_ta = require_permission(CLASSROOM_MANAGE)
+get_student = require_permission(CLASSROOM_SUBMIT)
@@ -0,0 +1,191 @@
+// Command loadtest checks that course caps hold under concurrent registration. It
+// creates a throwaway capped course, registers many students for it in parallel through
+// the real registration handler, and exits with status 1 if more students got in than
+// the cap allows or the seat count disagrees with the registrations.
+//
+//	go run ./cmd/loadtest -students 5000 -cap 40 -concurrency 64
+//
+// go test ./cmd/loadtest runs a smaller check when LOADTEST_DATABASE_DSN points at a
+// disposable Postgres database.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"erp/internal/database"
+	"erp/internal/handlers"
+	"erp/internal/models"
+	"flag"
+	"fmt"
+	"lms/pkg/middleware"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+	"gorm.io/gorm"
+)
+
+// config describes one load test run.
+type config struct {
+	students    int    // number of students registering
+	courseCap   int    // cap of the test course
+	concurrency int    // registrations in flight at once
+	semester    string // semester to register for
+	keep        bool   // keep the test course and its registrations afterwards
+}
+
+func main() {
+	var cfg config
+	flag.IntVar(&cfg.students, "students", 2000, "number of students registering")
+	flag.IntVar(&cfg.courseCap, "cap", 50, "cap of the test course")
+	flag.IntVar(&cfg.concurrency, "concurrency", 64, "registrations in flight at once")
+	flag.StringVar(&cfg.semester, "semester", "Load Test", "semester to register for")
+	flag.BoolVar(&cfg.keep, "keep", false, "keep the test course and its registrations afterwards")
+	flag.Parse()
+
+	if err := godotenv.Load("../../.env"); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: error loading .env file: %v", err)
+	}
+
+	db, err := database.ConnectDatabase()
+	if err != nil {
+		log.Fatalf("Could not connect to the database: %v", err)
+	}
+	if err := prepare(db, cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	problems, err := run(db, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, problem := range problems {
+		log.Printf("FAIL: %s", problem)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	log.Printf("PASS: the cap held")
+}
+
+// prepare migrates the tables the registration handler uses and sizes the connection pool.
+func prepare(db *gorm.DB, cfg config) error {
+	if err := db.AutoMigrate(&models.Course{}, &models.Registration{}, &models.PrerequisiteConsent{}, &models.CourseOffering{}); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	// One connection per worker, so the test measures locking rather than pool waits
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	sqlDB.SetMaxOpenConns(cfg.concurrency)
+	sqlDB.SetMaxIdleConns(cfg.concurrency)
+	return nil
+}
+
+// run performs the load test and returns every way the outcome was wrong.
+func run(db *gorm.DB, cfg config) ([]string, error) {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	course := models.Course{
+		CourseCode:   "LOADTEST-" + hex.EncodeToString(suffix),
+		Name:         "Capacity load test",
+		Credits:      1,
+		InstructorID: uuid.New(),
+		CourseCap:    &cfg.courseCap,
+	}
+	if err := db.Create(&course).Error; err != nil {
+		return nil, fmt.Errorf("could not create test course: %w", err)
+	}
+	if !cfg.keep {
+		defer func() {
+			db.Unscoped().Where("course_id = ?", course.ID).Delete(&models.Registration{})
+			db.Where("course_id = ?", course.ID).Delete(&models.CourseOffering{})
+			db.Delete(&course)
+		}()
+	}
+
+	// Stand in for AuthMiddleware: each request says which student it is
+	register := handlers.RegisterForCourse(db)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), middleware.UserIDContextKey, r.Header.Get("X-User-ID"))
+		register(w, r.WithContext(ctx))
+	}))
+	defer server.Close()
+	client := &http.Client{
+		Timeout:   time.Minute,
+		Transport: &http.Transport{MaxIdleConnsPerHost: cfg.concurrency},
+	}
+	body, _ := json.Marshal(handlers.RegisterRequest{CourseID: course.ID, Semester: cfg.semester})
+
+	log.Printf("Registering %d students for %s (cap %d), %d at a time", cfg.students, course.CourseCode, cfg.courseCap, cfg.concurrency)
+	start := time.Now()
+	var (
+		mu       sync.Mutex
+		statuses = map[int]int{}
+		failures int
+		wg       sync.WaitGroup
+	)
+	jobs := make(chan struct{})
+	for range cfg.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				req, _ := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(body))
+				req.Header.Set("X-User-ID", uuid.NewString())
+				resp, err := client.Do(req)
+				mu.Lock()
+				if err != nil {
+					failures++
+				} else {
+					statuses[resp.StatusCode]++
+					resp.Body.Close()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for range cfg.students {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var registered int64
+	if err := db.Model(&models.Registration{}).Where("course_id = ? AND semester = ?", course.ID, cfg.semester).Count(&registered).Error; err != nil {
+		return nil, fmt.Errorf("could not count registrations: %w", err)
+	}
+	var offering models.CourseOffering
+	if err := db.First(&offering, "course_id = ? AND semester = ?", course.ID, cfg.semester).Error; err != nil {
+		return nil, fmt.Errorf("could not read seat count: %w", err)
+	}
+
+	log.Printf("Done in %s (%.0f registrations/s): statuses %v, transport errors %d",
+		elapsed.Round(time.Millisecond), float64(cfg.students)/elapsed.Seconds(), statuses, failures)
+	log.Printf("Registrations: %d, seats taken: %d, cap: %d", registered, offering.SeatsTaken, cfg.courseCap)
+
+	var problems []string
+	if registered > int64(cfg.courseCap) {
+		problems = append(problems, fmt.Sprintf("%d registrations exceed the cap of %d", registered, cfg.courseCap))
+	}
+	if want := int64(min(cfg.students, cfg.courseCap)); registered != want {
+		problems = append(problems, fmt.Sprintf("expected %d registrations, got %d", want, registered))
+	}
+	if int64(statuses[http.StatusCreated]) != registered {
+		problems = append(problems, fmt.Sprintf("%d registrations succeeded but %d were stored", statuses[http.StatusCreated], registered))
+	}
+	if int64(offering.SeatsTaken) != registered {
+		problems = append(problems, fmt.Sprintf("seat count %d does not match %d registrations", offering.SeatsTaken, registered))
+	}
+	return problems, nil
+}
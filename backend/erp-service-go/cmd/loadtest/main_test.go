@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The cap depends on Postgres row locks, so this needs a real database. It creates and
+// removes its own course, but should still be pointed at a database nobody else uses.
+func TestCourseCapHoldsUnderLoad(t *testing.T) {
+	dsn := os.Getenv("LOADTEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("LOADTEST_DATABASE_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("could not connect to the database: %v", err)
+	}
+
+	cfg := config{students: 500, courseCap: 20, concurrency: 32, semester: "Load Test"}
+	if err := prepare(db, cfg); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := run(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+}
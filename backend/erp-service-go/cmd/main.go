@@ -4,7 +4,9 @@ import (
 	"erp/internal/database"
 	"erp/internal/handlers"
 	"erp/internal/models"
+	"lms/pkg/audit"
 	"lms/pkg/middleware"
+	"lms/pkg/permissions"
 	"log"
 	"net/http"
 	"os"
@@ -27,11 +29,19 @@ func main() {
 		&models.Registration{},
 		&models.ProjectRegistration{},
 		&models.AcademicStanding{},
+		&models.PrerequisiteConsent{},
+		&models.CourseOffering{},
 	)
 	if err != nil {
 		log.Fatalf("[ERP Service] Failed to migrate database: %v", err)
 	}
 
+	// Grade changes and course edits go to the audit log shared with the auth service
+	auditLog := audit.New(db, "erp")
+	if err := auditLog.Migrate(); err != nil {
+		log.Fatalf("[ERP Service] Failed to migrate audit log: %v", err)
+	}
+
 	router := http.NewServeMux()
 	router.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"status": "erp service is up and running"}`))
@@ -39,27 +49,45 @@ func main() {
 
 	// --- General Course Routes ---
 	courseRouter := http.NewServeMux()
-	courseRouter.Handle("POST /", middleware.AdminMiddleware(http.HandlerFunc(handlers.CreateCourse(db))))
+	courseRouter.Handle("POST /", middleware.RequirePermission(permissions.CourseCreate)(http.HandlerFunc(handlers.CreateCourse(db, auditLog))))
 	courseRouter.Handle("GET /", http.HandlerFunc(handlers.ListCourses(db))) // Publicly viewable
-	courseRouter.Handle("GET /{courseId}/roster/{semester}", middleware.InstructorMiddleware(http.HandlerFunc(handlers.GetCourseRoster(db))))
+	courseRouter.Handle("GET /{courseId}", http.HandlerFunc(handlers.GetCourse(db)))
+	courseEditor := middleware.RequirePermission(permissions.CourseUpdate)
+	courseRouter.Handle("PATCH /{courseId}", courseEditor(http.HandlerFunc(handlers.UpdateCourse(db, auditLog))))
+	courseRouter.Handle("PUT /{courseId}/prerequisites", courseEditor(http.HandlerFunc(handlers.SetPrerequisites(db, auditLog))))
+	courseRouter.Handle("PUT /{courseId}/anti-requisites", courseEditor(http.HandlerFunc(handlers.SetAntiRequisites(db, auditLog))))
+	courseRouter.Handle("PUT /{courseId}/co-requisites", courseEditor(http.HandlerFunc(handlers.SetCoRequisites(db, auditLog))))
+	courseRouter.Handle("PUT /{courseId}/prerequisite-rule", courseEditor(http.HandlerFunc(handlers.SetPrerequisiteRule(db, auditLog))))
+	courseRouter.Handle("GET /{courseId}/eligibility", middleware.RequirePermission(permissions.RegistrationOwn)(http.HandlerFunc(handlers.CheckEligibility(db))))
+	// The course's instructor or a course editor; checked by the handlers
+	courseRouter.Handle("PUT /{courseId}/consents/{userId}", http.HandlerFunc(handlers.GrantConsent(db, auditLog)))
+	courseRouter.Handle("DELETE /{courseId}/consents/{userId}", http.HandlerFunc(handlers.RevokeConsent(db, auditLog)))
+	courseRouter.Handle("DELETE /{courseId}", courseEditor(http.HandlerFunc(handlers.DeleteCourse(db, auditLog))))
+	courseRouter.Handle("GET /{courseId}/roster/{semester}", middleware.RequirePermission(permissions.RosterRead)(http.HandlerFunc(handlers.GetCourseRoster(db))))
 	router.Handle("/courses/", http.StripPrefix("/courses", middleware.AuthMiddleware(courseRouter)))
+	// Without the trailing slash the path is not stripped, so it would read as a course ID
+	router.Handle("GET /courses", middleware.AuthMiddleware(http.HandlerFunc(handlers.ListCourses(db))))
 	router.Handle("/courses", middleware.AuthMiddleware(courseRouter))
 
 	// --- Student Registration Routes ---
 	regRouter := http.NewServeMux()
-	regRouter.Handle("POST /", middleware.StudentMiddleware(http.HandlerFunc(handlers.RegisterForCourse(db))))
-	regRouter.Handle("GET /me", middleware.StudentMiddleware(http.HandlerFunc(handlers.ListMyRegistrations(db))))
-	regRouter.Handle("DELETE /{courseId}/{semester}", middleware.StudentMiddleware(http.HandlerFunc(handlers.DropCourse(db))))
-	regRouter.Handle("POST /grades", middleware.InstructorMiddleware(http.HandlerFunc(handlers.SubmitGrades(db))))
+	registrant := middleware.RequirePermission(permissions.RegistrationOwn)
+	regRouter.Handle("POST /", registrant(http.HandlerFunc(handlers.RegisterForCourse(db))))
+	regRouter.Handle("GET /me", registrant(http.HandlerFunc(handlers.ListMyRegistrations(db))))
+	regRouter.Handle("DELETE /{courseId}/{semester}", registrant(http.HandlerFunc(handlers.DropCourse(db))))
+	regRouter.Handle("POST /grades", middleware.RequirePermission(permissions.GradesSubmit)(http.HandlerFunc(handlers.SubmitGrades(db, auditLog))))
 	router.Handle("/registrations/", http.StripPrefix("/registrations", middleware.AuthMiddleware(regRouter)))
 	router.Handle("/registrations", middleware.AuthMiddleware(regRouter))
 
 	// --- NEW: Admin-specific ERP Routes ---
 	adminRouter := http.NewServeMux()
-	adminRouter.HandleFunc("GET /roster/{courseId}/{semester}", handlers.AdminGetCourseRoster(db))
-	adminRouter.HandleFunc("GET /records/student/{studentId}", handlers.GetStudentAcademicRecord(db))
-	// All routes in this group are protected by both Auth and Admin middleware
-	router.Handle("/admin/erp/", http.StripPrefix("/admin/erp", middleware.AuthMiddleware(middleware.AdminMiddleware(adminRouter))))
+	adminRouter.Handle("GET /roster/{courseId}/{semester}", middleware.RequirePermission(permissions.RosterReadAny)(http.HandlerFunc(handlers.AdminGetCourseRoster(db))))
+	// privacy:manage also reads records, since a privacy export includes the student's academic history
+	adminRouter.Handle("GET /records/student/{studentId}", middleware.RequireAnyPermission(permissions.RecordsRead, permissions.PrivacyManage)(http.HandlerFunc(handlers.GetStudentAcademicRecord(db))))
+	adminRouter.Handle("GET /departments/{department}/prerequisites", middleware.RequirePermission(permissions.CourseUpdate)(http.HandlerFunc(handlers.GetPrerequisiteGraph(db))))
+	adminRouter.Handle("GET /instructors/{instructorId}/courses", middleware.RequirePermission(permissions.UsersManage)(http.HandlerFunc(handlers.ListInstructorCourses(db))))
+	// All routes in this group need a valid token plus the permission named on each route
+	router.Handle("/admin/erp/", http.StripPrefix("/admin/erp", middleware.AuthMiddleware(adminRouter)))
 
 	log.Println("🚀 ERP service starting on port 8082...")
 	if err := http.ListenAndServe(":8082", router); err != nil {
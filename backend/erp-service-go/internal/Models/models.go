@@ -1,6 +1,7 @@
 package models
 
 import (
+	"erp/internal/prereq"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,9 +19,37 @@ type Course struct {
 	SemesterOffered string    `gorm:"type:varchar(50)"`
 	CourseCap       *int
 	Prerequisites   []*Course `gorm:"many2many:course_prerequisites;"`
-	AntiRequisites  []*Course `gorm:"many2many:course_anti_requisites;"`
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	// PrerequisiteRule, when set, is the prerequisite expression students must satisfy,
+	// and Prerequisites lists the courses it mentions. Without it every course in
+	// Prerequisites must be passed.
+	PrerequisiteRule *prereq.Expr `gorm:"type:jsonb;serializer:json"`
+	AntiRequisites   []*Course    `gorm:"many2many:course_anti_requisites;"`
+	// CoRequisites must be taken in the same semester or passed earlier.
+	CoRequisites []*Course `gorm:"many2many:course_co_requisites;"`
+	// ArchivedAt is set instead of deleting a course that registrations or other courses
+	// refer to. Archived courses stay in records but cannot be registered for.
+	ArchivedAt *time.Time `gorm:"index"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// PrerequisiteConsent records an instructor's consent for a student to register for a
+// course, which satisfies "consent" prerequisite rules.
+type PrerequisiteConsent struct {
+	CourseID  uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	GrantedBy uuid.UUID `gorm:"type:uuid;not null"`
+	CreatedAt time.Time
+}
+
+// CourseOffering counts the seats taken in a course in one semester. Registering claims
+// a seat by incrementing SeatsTaken only while it is below the course cap, in a single
+// statement, so concurrent registrations cannot overfill the course.
+type CourseOffering struct {
+	CourseID   uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Semester   string    `gorm:"type:varchar(50);primaryKey"`
+	SeatsTaken int       `gorm:"not null;default:0"`
+	UpdatedAt  time.Time
 }
 
 // Registration correctly stores semester-wise grades and status.
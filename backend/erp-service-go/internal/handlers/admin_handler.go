@@ -3,8 +3,15 @@ package handlers
 import (
 	"encoding/json"
 	"erp/internal/models"
+	"fmt"
+	"log"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -57,3 +64,194 @@ func GetStudentAcademicRecord(db *gorm.DB) http.HandlerFunc {
 		json.NewEncoder(w).Encode(record)
 	}
 }
+
+// ListInstructorCourses lets an admin see every course an instructor is assigned to,
+// e.g. before the instructor's account is deactivated.
+func ListInstructorCourses(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		instructorID, err := uuid.Parse(r.PathValue("instructorId"))
+		if err != nil {
+			http.Error(w, "Invalid instructor ID format", http.StatusBadRequest)
+			return
+		}
+
+		var courses []models.Course
+		if err := db.Where("instructor_id = ?", instructorID).Order("course_code").Find(&courses).Error; err != nil {
+			log.Printf("ERROR: Failed to fetch courses for instructor %s: %v", instructorID, err)
+			http.Error(w, "Failed to retrieve instructor courses", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(courses)
+	}
+}
+
+// PrerequisiteGraphCourse is one course in a prerequisite graph. Depth is how many
+// levels of prerequisites lie below it, and LongestChain the course codes of the
+// longest prerequisite chain that ends in it.
+type PrerequisiteGraphCourse struct {
+	ID            uuid.UUID `json:"id"`
+	CourseCode    string    `json:"courseCode"`
+	Name          string    `json:"name"`
+	Department    string    `json:"department"`
+	Archived      bool      `json:"archived"`
+	Prerequisites []string  `json:"prerequisites"`
+	Depth         int       `json:"depth"`
+	LongestChain  []string  `json:"longestChain"`
+}
+
+// PrerequisiteGraph is the prerequisite graph of a department. Courses from other
+// departments that the department's courses require are included too, marked by their
+// own department.
+type PrerequisiteGraph struct {
+	Department string                    `json:"department"`
+	Courses    []PrerequisiteGraphCourse `json:"courses"`
+}
+
+// GetPrerequisiteGraph returns the prerequisite graph of a department as JSON, or as
+// Graphviz DOT with ?format=dot. A course's department is the letter prefix of its
+// code, e.g. CS for CS101.
+func GetPrerequisiteGraph(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		department := strings.ToUpper(r.PathValue("department"))
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "dot" {
+			http.Error(w, "format must be json or dot", http.StatusBadRequest)
+			return
+		}
+
+		var courses []models.Course
+		if err := db.Select("id", "course_code", "name", "archived_at").Find(&courses).Error; err != nil {
+			log.Printf("ERROR: Failed to fetch courses: %v", err)
+			http.Error(w, "Failed to build prerequisite graph", http.StatusInternalServerError)
+			return
+		}
+		graph, err := loadPrerequisiteGraph(db)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "Failed to build prerequisite graph", http.StatusInternalServerError)
+			return
+		}
+
+		byID := map[uuid.UUID]*models.Course{}
+		for i := range courses {
+			byID[courses[i].ID] = &courses[i]
+		}
+		codes := func(ids []uuid.UUID) []string {
+			out := make([]string, 0, len(ids))
+			for _, id := range ids {
+				if c, ok := byID[id]; ok {
+					out = append(out, c.CourseCode)
+				}
+			}
+			return out
+		}
+		chains := graph.LongestChains(func(a, b uuid.UUID) bool {
+			return byID[a] != nil && byID[b] != nil && byID[a].CourseCode < byID[b].CourseCode
+		})
+
+		// The department's courses, then every course they need, however indirectly
+		included := map[uuid.UUID]bool{}
+		var queue []uuid.UUID
+		for _, c := range courses {
+			if courseDepartment(c.CourseCode) == department {
+				included[c.ID] = true
+				queue = append(queue, c.ID)
+			}
+		}
+		if len(queue) == 0 {
+			http.Error(w, "No courses found for department "+department, http.StatusNotFound)
+			return
+		}
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			for _, p := range graph.Prerequisites(id) {
+				if !included[p] && byID[p] != nil {
+					included[p] = true
+					queue = append(queue, p)
+				}
+			}
+		}
+
+		result := PrerequisiteGraph{Department: department, Courses: []PrerequisiteGraphCourse{}}
+		for _, c := range courses {
+			if !included[c.ID] {
+				continue
+			}
+			chain := chains[c.ID]
+			if chain == nil {
+				chain = []uuid.UUID{c.ID}
+			}
+			result.Courses = append(result.Courses, PrerequisiteGraphCourse{
+				ID:            c.ID,
+				CourseCode:    c.CourseCode,
+				Name:          c.Name,
+				Department:    courseDepartment(c.CourseCode),
+				Archived:      c.ArchivedAt != nil,
+				Prerequisites: codes(graph.Prerequisites(c.ID)),
+				Depth:         len(chain) - 1,
+				LongestChain:  codes(chain),
+			})
+		}
+		sort.Slice(result.Courses, func(i, j int) bool {
+			a, b := result.Courses[i], result.Courses[j]
+			if a.Depth != b.Depth {
+				return a.Depth < b.Depth
+			}
+			return a.CourseCode < b.CourseCode
+		})
+
+		if format == "dot" {
+			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(result.dot()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// dot renders the graph in Graphviz DOT, prerequisites on the left. Courses from other
+// departments are dashed and archived courses grey.
+func (g PrerequisiteGraph) dot() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n\trankdir=LR;\n\tnode [shape=box];\n", strconv.Quote(g.Department+" prerequisites"))
+	for _, c := range g.Courses {
+		var attrs []string
+		if c.Department != g.Department {
+			attrs = append(attrs, "style=dashed")
+		}
+		if c.Archived {
+			attrs = append(attrs, "color=grey", "fontcolor=grey")
+		}
+		label := fmt.Sprintf("%s\n%s\ndepth %d", c.CourseCode, c.Name, c.Depth)
+		fmt.Fprintf(&b, "\t%s [label=%s%s];\n", strconv.Quote(c.CourseCode), strconv.Quote(label), prefixedJoin(attrs))
+	}
+	for _, c := range g.Courses {
+		for _, p := range c.Prerequisites {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", strconv.Quote(p), strconv.Quote(c.CourseCode))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func prefixedJoin(attrs []string) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	return ", " + strings.Join(attrs, ", ")
+}
+
+// courseDepartment returns the letter prefix of a course code, e.g. CS for CS101.
+func courseDepartment(code string) string {
+	if end := strings.IndexFunc(code, func(r rune) bool { return !unicode.IsLetter(r) }); end >= 0 {
+		code = code[:end]
+	}
+	return strings.ToUpper(code)
+}
@@ -3,34 +3,55 @@ package handlers
 import (
 	"encoding/json"
 	"erp/internal/models"
+	"erp/internal/prereq"
 	"errors"
+	"fmt"
+	"lms/pkg/audit"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CreateCourseRequest now includes fields for all the new course rules.
 type CreateCourseRequest struct {
-	CourseCode      string      `json:"courseCode"`
-	Name            string      `json:"name"`
-	Description     string      `json:"description"`
-	Credits         int         `json:"credits"`
-	InstructorID    uuid.UUID   `json:"instructorId"`
-	SemesterOffered string      `json:"semesterOffered"`
-	CourseCap       *int        `json:"courseCap"`
-	PrerequisiteIDs []uuid.UUID `json:"prerequisiteIds"` // We accept a list of Course IDs
+	CourseCode       string      `json:"courseCode"`
+	Name             string      `json:"name"`
+	Description      string      `json:"description"`
+	Credits          int         `json:"credits"`
+	InstructorID     uuid.UUID   `json:"instructorId"`
+	SemesterOffered  string      `json:"semesterOffered"`
+	CourseCap        *int        `json:"courseCap"`
+	PrerequisiteIDs  []uuid.UUID `json:"prerequisiteIds"` // We accept a list of Course IDs
+	AntiRequisiteIDs []uuid.UUID `json:"antiRequisiteIds"`
+	CoRequisiteIDs   []uuid.UUID `json:"coRequisiteIds"`
 }
 
 // CreateCourse handles creating a new course with all its rules.
-func CreateCourse(db *gorm.DB) http.HandlerFunc {
+func CreateCourse(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req CreateCourseRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request payload", http.StatusBadRequest)
 			return
 		}
+		requested := map[string][]uuid.UUID{
+			"Prerequisites":  req.PrerequisiteIDs,
+			"AntiRequisites": req.AntiRequisiteIDs,
+			"CoRequisites":   req.CoRequisiteIDs,
+		}
+		for association, ids := range requested {
+			for _, conflicting := range requisiteConflicts[association] {
+				if overlap(ids, requested[conflicting]) {
+					http.Error(w, "A course cannot be both required and an anti-requisite", http.StatusBadRequest)
+					return
+				}
+			}
+		}
 
 		course := models.Course{
 			CourseCode:      req.CourseCode,
@@ -55,19 +76,37 @@ func CreateCourse(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		// If there are prerequisites, find them and associate them.
-		if len(req.PrerequisiteIDs) > 0 {
-			var prereqs []models.Course
-			if err := tx.Where("id IN ?", req.PrerequisiteIDs).Find(&prereqs).Error; err != nil {
-				http.Error(w, "Failed to find prerequisites", http.StatusInternalServerError)
+		// If there are requisites, find them and associate them. Nothing names the new
+		// course yet, so they cannot close a prerequisite or co-requisite cycle.
+		for association, ids := range requested {
+			if len(ids) == 0 {
+				continue
+			}
+			requisites, err := findRequisites(tx, ids)
+			if err != nil {
+				if errors.Is(err, errInvalidRequisite) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				log.Printf("ERROR: %v", err)
+				http.Error(w, "Failed to find requisite courses", http.StatusInternalServerError)
 				return
 			}
-			if err := tx.Model(&course).Association("Prerequisites").Append(prereqs); err != nil {
-				http.Error(w, "Failed to associate prerequisites", http.StatusInternalServerError)
+			if err := tx.Model(&course).Association(association).Append(requisites); err != nil {
+				log.Printf("ERROR: Failed to associate %s: %v", association, err)
+				http.Error(w, "Failed to associate requisites", http.StatusInternalServerError)
 				return
 			}
 		}
 
+		event := audit.FromRequest(r, "course.create", "course", course.ID.String())
+		event.After = course
+		if err := auditLog.Record(tx, event); err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "Failed to record course creation", http.StatusInternalServerError)
+			return
+		}
+
 		if err := tx.Commit().Error; err != nil {
 			http.Error(w, "Failed to commit course creation", http.StatusInternalServerError)
 			return
@@ -79,12 +118,17 @@ func CreateCourse(db *gorm.DB) http.HandlerFunc {
 	}
 }
 
-// ListCourses handles fetching all courses, including their prerequisites.
+// ListCourses handles fetching all courses, including their prerequisites. Archived
+// courses are left out unless ?includeArchived=true.
 func ListCourses(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var courses []models.Course
+		query := db
+		if r.URL.Query().Get("includeArchived") != "true" {
+			query = query.Where("archived_at IS NULL")
+		}
 		// Use Preload to efficiently fetch the related prerequisite courses.
-		if err := db.Preload("Prerequisites").Find(&courses).Error; err != nil {
+		if err := query.Preload("Prerequisites").Find(&courses).Error; err != nil {
 			log.Printf("ERROR: Failed to fetch courses: %v", err)
 			http.Error(w, "Failed to retrieve course catalog", http.StatusInternalServerError)
 			return
@@ -94,3 +138,592 @@ func ListCourses(db *gorm.DB) http.HandlerFunc {
 		json.NewEncoder(w).Encode(courses)
 	}
 }
+
+// GetCourse returns one course with its prerequisites, anti-requisites and
+// co-requisites. Archived courses are still returned, since past registrations refer to
+// them.
+func GetCourse(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var course models.Course
+		if !loadCourse(w, r, db.Preload("Prerequisites").Preload("AntiRequisites").Preload("CoRequisites"), &course) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(course)
+	}
+}
+
+// optionalInt tells a JSON null apart from a missing field, so PATCH can remove a value.
+type optionalInt struct {
+	Set   bool
+	Value *int
+}
+
+func (o *optionalInt) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	return json.Unmarshal(data, &o.Value)
+}
+
+// UpdateCourseRequest lists the fields PATCH /courses/{courseId} can change; fields left
+// out keep their value. The course code is fixed once a course exists.
+type UpdateCourseRequest struct {
+	Name            *string     `json:"name"`
+	Description     *string     `json:"description"`
+	Credits         *int        `json:"credits"`
+	InstructorID    *uuid.UUID  `json:"instructorId"`
+	SemesterOffered *string     `json:"semesterOffered"`
+	CourseCap       optionalInt `json:"courseCap"` // null removes the cap
+	Archived        *bool       `json:"archived"`
+}
+
+// UpdateCourse edits a course's details. Changes that would break existing
+// registrations are refused: the cap cannot drop below the enrollment of a semester
+// still in progress, and credits cannot change once grades have been given.
+func UpdateCourse(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateCourseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if req.Name != nil && *req.Name == "" {
+			http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+			return
+		}
+		if req.Credits != nil && *req.Credits <= 0 {
+			http.Error(w, "Credits must be positive", http.StatusBadRequest)
+			return
+		}
+		if req.CourseCap.Value != nil && *req.CourseCap.Value < 0 {
+			http.Error(w, "Course cap cannot be negative", http.StatusBadRequest)
+			return
+		}
+
+		tx := db.Begin()
+		defer tx.Rollback()
+
+		// Lock the course so registrations cannot slip in while the new cap is checked
+		var course models.Course
+		if !loadCourse(w, r, tx.Clauses(clause.Locking{Strength: "UPDATE"}), &course) {
+			return
+		}
+		before := course
+
+		updates := map[string]interface{}{}
+		if req.Name != nil {
+			updates["name"] = *req.Name
+		}
+		if req.Description != nil {
+			updates["description"] = *req.Description
+		}
+		if req.InstructorID != nil {
+			updates["instructor_id"] = *req.InstructorID
+		}
+		if req.SemesterOffered != nil {
+			updates["semester_offered"] = *req.SemesterOffered
+		}
+		if req.Credits != nil && *req.Credits != course.Credits {
+			var graded int64
+			if err := tx.Model(&models.Registration{}).Where("course_id = ? AND grade IS NOT NULL", course.ID).Count(&graded).Error; err != nil {
+				log.Printf("ERROR: Failed to count graded registrations: %v", err)
+				http.Error(w, "Failed to update course", http.StatusInternalServerError)
+				return
+			}
+			if graded > 0 {
+				http.Error(w, "Credits cannot change once grades have been given for the course", http.StatusConflict)
+				return
+			}
+			updates["credits"] = *req.Credits
+		}
+		if req.CourseCap.Set {
+			if req.CourseCap.Value != nil {
+				enrolled, err := currentEnrollment(tx, course.ID)
+				if err != nil {
+					log.Printf("ERROR: Failed to count enrollment: %v", err)
+					http.Error(w, "Failed to update course", http.StatusInternalServerError)
+					return
+				}
+				if int64(*req.CourseCap.Value) < enrolled {
+					http.Error(w, fmt.Sprintf("Course cap cannot be lower than the current enrollment of %d", enrolled), http.StatusConflict)
+					return
+				}
+			}
+			updates["course_cap"] = req.CourseCap.Value
+		}
+		if req.Archived != nil && *req.Archived != (course.ArchivedAt != nil) {
+			if *req.Archived {
+				updates["archived_at"] = time.Now()
+			} else {
+				updates["archived_at"] = nil
+			}
+		}
+
+		if len(updates) > 0 {
+			if err := tx.Model(&course).Updates(updates).Error; err != nil {
+				log.Printf("ERROR: Failed to update course: %v", err)
+				http.Error(w, "Failed to update course", http.StatusInternalServerError)
+				return
+			}
+			if err := tx.First(&course, "id = ?", course.ID).Error; err != nil {
+				log.Printf("ERROR: Failed to reload course: %v", err)
+				http.Error(w, "Failed to update course", http.StatusInternalServerError)
+				return
+			}
+			event := audit.FromRequest(r, "course.update", "course", course.ID.String())
+			event.Before = before
+			event.After = course
+			if err := auditLog.Record(tx, event); err != nil {
+				log.Printf("ERROR: %v", err)
+				http.Error(w, "Failed to record course update", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			http.Error(w, "Failed to commit course update", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(course)
+	}
+}
+
+// currentEnrollment returns the largest enrollment of any semester of the course whose
+// grades are not all in yet. Finished semesters do not limit a new cap.
+func currentEnrollment(tx *gorm.DB, courseID uuid.UUID) (int64, error) {
+	var counts []int64
+	err := tx.Model(&models.Registration{}).
+		Where("course_id = ?", courseID).
+		Group("semester").
+		Having("SUM(CASE WHEN grade IS NULL THEN 1 ELSE 0 END) > 0").
+		Pluck("COUNT(*)", &counts).Error
+	var enrolled int64
+	for _, c := range counts {
+		enrolled = max(enrolled, c)
+	}
+	return enrolled, err
+}
+
+// CourseRequisitesRequest replaces a course's prerequisites, anti-requisites or
+// co-requisites.
+type CourseRequisitesRequest struct {
+	CourseIDs []uuid.UUID `json:"courseIds"`
+}
+
+// requisiteConflicts lists, per association, the associations a course cannot share
+// with it: a course cannot be both required and excluded.
+var requisiteConflicts = map[string][]string{
+	"Prerequisites":  {"AntiRequisites"},
+	"AntiRequisites": {"Prerequisites", "CoRequisites"},
+	"CoRequisites":   {"AntiRequisites"},
+}
+
+// errInvalidRequisite is wrapped by every reason a set of requisites is refused.
+var errInvalidRequisite = errors.New("invalid requisites")
+
+// SetPrerequisites replaces the prerequisites of a course with a plain list, all of
+// which must be passed. It removes any prerequisite rule.
+func SetPrerequisites(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return setCourseRequisites(db, auditLog, "Prerequisites", "course.prerequisites")
+}
+
+// SetAntiRequisites replaces the anti-requisites of a course.
+func SetAntiRequisites(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return setCourseRequisites(db, auditLog, "AntiRequisites", "course.anti_requisites")
+}
+
+// SetCoRequisites replaces the co-requisites of a course. Co-requisites cannot form a
+// cycle, mutual pairs included; make one of the courses the co-requisite of the other.
+func SetCoRequisites(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return setCourseRequisites(db, auditLog, "CoRequisites", "course.co_requisites")
+}
+
+// PrerequisiteRuleRequest sets a course's prerequisite rule; see package prereq.
+type PrerequisiteRuleRequest struct {
+	Rule *prereq.Expr `json:"rule"`
+}
+
+// SetPrerequisiteRule replaces the prerequisite rule of a course and makes its
+// prerequisite list the courses the rule mentions. A null rule removes every
+// prerequisite.
+func SetPrerequisiteRule(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PrerequisiteRuleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if req.Rule != nil {
+			if err := req.Rule.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		tx := db.Begin()
+		defer tx.Rollback()
+
+		query := tx.Preload("Prerequisites")
+		for _, conflicting := range requisiteConflicts["Prerequisites"] {
+			query = query.Preload(conflicting)
+		}
+		var course models.Course
+		if !loadCourse(w, r, query, &course) {
+			return
+		}
+		before := course.PrerequisiteRule
+
+		if _, err := replaceRequisites(tx, &course, "Prerequisites", req.Rule.CourseIDs()); err != nil {
+			if errors.Is(err, errInvalidRequisite) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "Failed to update prerequisite rule", http.StatusInternalServerError)
+			return
+		}
+		course.PrerequisiteRule = req.Rule
+		if err := tx.Model(&course).Select("PrerequisiteRule").Updates(&course).Error; err != nil {
+			log.Printf("ERROR: Failed to store prerequisite rule: %v", err)
+			http.Error(w, "Failed to update prerequisite rule", http.StatusInternalServerError)
+			return
+		}
+
+		event := audit.FromRequest(r, "course.prerequisite_rule", "course", course.ID.String())
+		event.Before = before
+		event.After = req.Rule
+		if err := auditLog.Record(tx, event); err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "Failed to record prerequisite rule change", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			http.Error(w, "Failed to commit prerequisite rule change", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(course)
+	}
+}
+
+// setCourseRequisites replaces one requisite association of a course; see
+// replaceRequisites.
+func setCourseRequisites(db *gorm.DB, auditLog *audit.Logger, association, action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CourseRequisitesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		tx := db.Begin()
+		defer tx.Rollback()
+
+		query := tx.Preload(association)
+		for _, conflicting := range requisiteConflicts[association] {
+			query = query.Preload(conflicting)
+		}
+		var course models.Course
+		if !loadCourse(w, r, query, &course) {
+			return
+		}
+
+		before := courseCodes(requisitesOf(&course, association))
+		requisites, err := replaceRequisites(tx, &course, association, req.CourseIDs)
+		if err != nil {
+			if errors.Is(err, errInvalidRequisite) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "Failed to update requisites", http.StatusInternalServerError)
+			return
+		}
+		// A new plain list replaces any prerequisite rule
+		if association == "Prerequisites" && course.PrerequisiteRule != nil {
+			course.PrerequisiteRule = nil
+			if err := tx.Model(&course).Select("PrerequisiteRule").Updates(&course).Error; err != nil {
+				log.Printf("ERROR: Failed to clear prerequisite rule: %v", err)
+				http.Error(w, "Failed to update requisites", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		event := audit.FromRequest(r, action, "course", course.ID.String())
+		event.Before = before
+		event.After = courseCodes(requisites)
+		if err := auditLog.Record(tx, event); err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "Failed to record requisite change", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			http.Error(w, "Failed to commit requisite change", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(requisites)
+	}
+}
+
+func requisitesOf(course *models.Course, association string) []*models.Course {
+	switch association {
+	case "Prerequisites":
+		return course.Prerequisites
+	case "AntiRequisites":
+		return course.AntiRequisites
+	default:
+		return course.CoRequisites
+	}
+}
+
+// replaceRequisites replaces one requisite association of a course, whose conflicting
+// associations must be loaded. A course cannot be its own requisite, the associations
+// in requisiteConflicts are checked too, and neither prerequisites nor co-requisites can
+// form a cycle.
+func replaceRequisites(tx *gorm.DB, course *models.Course, association string, ids []uuid.UUID) ([]*models.Course, error) {
+	requested := map[uuid.UUID]bool{}
+	for _, id := range ids {
+		if id == course.ID {
+			return nil, fmt.Errorf("%w: a course cannot be its own requisite", errInvalidRequisite)
+		}
+		requested[id] = true
+	}
+	for _, conflicting := range requisiteConflicts[association] {
+		for _, c := range requisitesOf(course, conflicting) {
+			if requested[c.ID] {
+				return nil, fmt.Errorf("%w: course %s cannot be both required and an anti-requisite", errInvalidRequisite, c.CourseCode)
+			}
+		}
+	}
+
+	requisites, err := findRequisites(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := acyclicRequisites[association]; ok {
+		if err := checkRequisiteCycle(tx, association, course.ID, ids); err != nil {
+			return nil, err
+		}
+	}
+	assoc := tx.Model(course).Association(association)
+	if len(requisites) == 0 {
+		err = assoc.Clear()
+	} else {
+		err = assoc.Replace(requisites)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to replace %s: %w", association, err)
+	}
+	return requisites, nil
+}
+
+// requisiteTable is the join table behind a requisite association and its column for
+// the required course.
+type requisiteTable struct {
+	name, column string
+	// cycle explains why a cycle is refused, for the error message
+	cycle string
+}
+
+// acyclicRequisites are the associations that cannot form a cycle. A prerequisite cycle
+// makes every course in it require itself. A co-requisite cycle, mutual co-requisites
+// included, means none of its courses can be registered for first, since registration
+// checks one course at a time.
+var acyclicRequisites = map[string]requisiteTable{
+	"Prerequisites": {"course_prerequisites", "prerequisite_id", "prerequisites would form a cycle, each course requiring the next"},
+	"CoRequisites":  {"course_co_requisites", "co_requisite_id", "co-requisites would form a cycle, so no course in it could be registered for first"},
+}
+
+// checkRequisiteCycle fails with errInvalidRequisite if giving the course these
+// requisites would let it, through a chain of them, require itself. The association's
+// table stays locked until the transaction ends, so two concurrent changes cannot close
+// a cycle between them.
+func checkRequisiteCycle(tx *gorm.DB, association string, courseID uuid.UUID, requisiteIDs []uuid.UUID) error {
+	table := acyclicRequisites[association]
+	if err := tx.Exec("LOCK TABLE " + table.name + " IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+		return fmt.Errorf("failed to lock %s: %w", table.name, err)
+	}
+	graph, err := loadRequisiteGraph(tx, table)
+	if err != nil {
+		return err
+	}
+	graph.SetPrerequisites(courseID, requisiteIDs)
+	cycle := graph.CycleThrough(courseID)
+	if cycle == nil {
+		return nil
+	}
+
+	var courses []models.Course
+	if err := tx.Select("id", "course_code").Where("id IN ?", cycle).Find(&courses).Error; err != nil {
+		return fmt.Errorf("failed to name courses in requisite cycle: %w", err)
+	}
+	codes := map[uuid.UUID]string{}
+	for _, c := range courses {
+		codes[c.ID] = c.CourseCode
+	}
+	names := make([]string, len(cycle))
+	for i, id := range cycle {
+		names[i] = codes[id]
+	}
+	return fmt.Errorf("%w: %s: %s", errInvalidRequisite, table.cycle, strings.Join(names, " -> "))
+}
+
+func loadPrerequisiteGraph(tx *gorm.DB) (*prereq.Graph, error) {
+	return loadRequisiteGraph(tx, acyclicRequisites["Prerequisites"])
+}
+
+// loadRequisiteGraph loads a requisite association as a graph; prereq.Graph treats
+// every edge as a prerequisite, whatever the association.
+func loadRequisiteGraph(tx *gorm.DB, table requisiteTable) (*prereq.Graph, error) {
+	var edges []prereq.Edge
+	if err := tx.Table(table.name).Select("course_id", table.column+" AS prerequisite_id").Scan(&edges).Error; err != nil {
+		return nil, fmt.Errorf("failed to load %s graph: %w", table.name, err)
+	}
+	return prereq.NewGraph(edges), nil
+}
+
+// findRequisites loads the courses with the given IDs, failing with errInvalidRequisite
+// if any of them does not exist.
+func findRequisites(tx *gorm.DB, ids []uuid.UUID) ([]*models.Course, error) {
+	unique := map[uuid.UUID]bool{}
+	for _, id := range ids {
+		unique[id] = true
+	}
+	var courses []*models.Course
+	if len(unique) == 0 {
+		return courses, nil
+	}
+	if err := tx.Where("id IN ?", ids).Find(&courses).Error; err != nil {
+		return nil, fmt.Errorf("failed to find requisite courses: %w", err)
+	}
+	if len(courses) != len(unique) {
+		return nil, fmt.Errorf("%w: one or more requisite courses do not exist", errInvalidRequisite)
+	}
+	return courses, nil
+}
+
+func overlap(a, b []uuid.UUID) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func courseCodes(courses []*models.Course) []string {
+	codes := make([]string, 0, len(courses))
+	for _, c := range courses {
+		codes = append(codes, c.CourseCode)
+	}
+	return codes
+}
+
+// DeleteCourse removes a course nothing refers to. A course with registrations, or one
+// that other courses name as a requisite, is archived instead so records stay intact.
+func DeleteCourse(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tx := db.Begin()
+		defer tx.Rollback()
+
+		var course models.Course
+		if !loadCourse(w, r, tx.Clauses(clause.Locking{Strength: "UPDATE"}), &course) {
+			return
+		}
+
+		referenced, err := courseReferenced(tx, course.ID)
+		if err != nil {
+			log.Printf("ERROR: Failed to check course references: %v", err)
+			http.Error(w, "Failed to delete course", http.StatusInternalServerError)
+			return
+		}
+
+		action := "course.delete"
+		if referenced {
+			action = "course.archive"
+			if course.ArchivedAt == nil {
+				err = tx.Model(&course).Update("archived_at", time.Now()).Error
+			}
+		} else {
+			for _, association := range []string{"Prerequisites", "AntiRequisites", "CoRequisites"} {
+				if err == nil {
+					err = tx.Model(&course).Association(association).Clear()
+				}
+			}
+			if err == nil {
+				err = tx.Delete(&course).Error
+			}
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to delete course: %v", err)
+			http.Error(w, "Failed to delete course", http.StatusInternalServerError)
+			return
+		}
+
+		event := audit.FromRequest(r, action, "course", course.ID.String())
+		event.Before = course
+		if err := auditLog.Record(tx, event); err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "Failed to record course deletion", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			http.Error(w, "Failed to commit course deletion", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if referenced {
+			w.Write([]byte(`{"message":"Course is in use and was archived instead of deleted","archived":true}`))
+		} else {
+			w.Write([]byte(`{"message":"Course deleted","archived":false}`))
+		}
+	}
+}
+
+// courseReferenced reports whether any registration, including dropped ones, or any
+// other course's requisites refer to the course.
+func courseReferenced(tx *gorm.DB, courseID uuid.UUID) (bool, error) {
+	var count int64
+	if err := tx.Unscoped().Model(&models.Registration{}).Where("course_id = ?", courseID).Count(&count).Error; err != nil || count > 0 {
+		return count > 0, err
+	}
+	if err := tx.Table("course_prerequisites").Where("prerequisite_id = ?", courseID).Count(&count).Error; err != nil || count > 0 {
+		return count > 0, err
+	}
+	if err := tx.Table("course_anti_requisites").Where("anti_requisite_id = ?", courseID).Count(&count).Error; err != nil || count > 0 {
+		return count > 0, err
+	}
+	err := tx.Table("course_co_requisites").Where("co_requisite_id = ?", courseID).Count(&count).Error
+	return count > 0, err
+}
+
+// loadCourse fetches the course named by the courseId path value into course, writing
+// the error response on failure.
+func loadCourse(w http.ResponseWriter, r *http.Request, db *gorm.DB, course *models.Course) bool {
+	courseID, err := uuid.Parse(r.PathValue("courseId"))
+	if err != nil {
+		http.Error(w, "Invalid course ID format", http.StatusBadRequest)
+		return false
+	}
+	if err := db.First(course, "id = ?", courseID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Course not found", http.StatusNotFound)
+			return false
+		}
+		log.Printf("ERROR: Failed to fetch course %s: %v", courseID, err)
+		http.Error(w, "Failed to retrieve course", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
@@ -3,12 +3,15 @@ package handlers
 import (
 	"encoding/json"
 	"erp/internal/models"
+	"lms/pkg/audit"
 	"lms/pkg/middleware"
+	"lms/pkg/permissions"
 	"log"
 	"net/http"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // GradeSubmission represents a single student's grade to be updated.
@@ -19,8 +22,15 @@ type GradeSubmission struct {
 	Grade    string    `json:"grade"`
 }
 
+// gradeRecord is the part of a registration a grade change touches, as stored in the audit log.
+type gradeRecord struct {
+	Grade          *string `json:"grade"`
+	PassFailStatus *string `json:"passFailStatus"`
+}
+
 // SubmitGrades allows an instructor to submit final grades for multiple students.
-func SubmitGrades(db *gorm.DB) http.HandlerFunc {
+// Every grade that changes is written to the audit log in the same transaction.
+func SubmitGrades(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		instructorIDStr, _ := r.Context().Value(middleware.UserIDContextKey).(string)
 		instructorID, _ := uuid.Parse(instructorIDStr)
@@ -39,15 +49,23 @@ func SubmitGrades(db *gorm.DB) http.HandlerFunc {
 		tx := db.Begin()
 		defer tx.Rollback()
 
-		// BUSINESS LOGIC: Verify the instructor is assigned to the course they are submitting grades for.
-		var course models.Course
-		if err := tx.First(&course, "id = ?", submissions[0].CourseID).Error; err != nil {
-			http.Error(w, "Course not found", http.StatusNotFound)
-			return
-		}
-		if course.InstructorID != instructorID {
-			http.Error(w, "Forbidden: You are not the instructor for this course", http.StatusForbidden)
-			return
+		// BUSINESS LOGIC: Verify the instructor is assigned to every course they are submitting
+		// grades for, before any grade is written.
+		checked := make(map[uuid.UUID]bool)
+		for _, sub := range submissions {
+			if checked[sub.CourseID] {
+				continue
+			}
+			var course models.Course
+			if err := tx.First(&course, "id = ?", sub.CourseID).Error; err != nil {
+				http.Error(w, "Course not found", http.StatusNotFound)
+				return
+			}
+			if course.InstructorID != instructorID {
+				http.Error(w, "Forbidden: You are not the instructor for this course", http.StatusForbidden)
+				return
+			}
+			checked[sub.CourseID] = true
 		}
 
 		// Update grades for each student in the submission.
@@ -57,16 +75,38 @@ func SubmitGrades(db *gorm.DB) http.HandlerFunc {
 				passFailStatus = "Fail"
 			}
 
+			var registration models.Registration
+			if err := tx.Where("user_id = ? AND course_id = ? AND semester = ?", sub.UserID, sub.CourseID, sub.Semester).
+				First(&registration).Error; err != nil {
+				log.Printf("WARN: Failed to update grade for user %s or registration not found", sub.UserID)
+				continue
+			}
+			before := gradeRecord{Grade: registration.Grade, PassFailStatus: registration.PassFailStatus}
+
 			result := tx.Model(&models.Registration{}).
 				Where("user_id = ? AND course_id = ? AND semester = ?", sub.UserID, sub.CourseID, sub.Semester).
 				Updates(map[string]interface{}{"grade": sub.Grade, "pass_fail_status": passFailStatus})
 
 			if result.Error != nil || result.RowsAffected == 0 {
 				log.Printf("WARN: Failed to update grade for user %s or registration not found", sub.UserID)
+				continue
+			}
+
+			event := audit.FromRequest(r, "grades.submit", "registration", sub.UserID.String()+"/"+sub.CourseID.String()+"/"+sub.Semester)
+			event.Before = before
+			event.After = gradeRecord{Grade: &sub.Grade, PassFailStatus: &passFailStatus}
+			if err := auditLog.Record(tx, event); err != nil {
+				log.Printf("ERROR: %v", err)
+				http.Error(w, "Failed to record grade change", http.StatusInternalServerError)
+				return
 			}
 		}
 
-		tx.Commit()
+		if err := tx.Commit().Error; err != nil {
+			log.Printf("ERROR: Failed to commit grades: %v", err)
+			http.Error(w, "Failed to submit grades", http.StatusInternalServerError)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"message":"Grades submitted successfully"}`))
 	}
@@ -98,3 +138,71 @@ func GetCourseRoster(db *gorm.DB) http.HandlerFunc {
 		json.NewEncoder(w).Encode(registrations)
 	}
 }
+
+// GrantConsent records the instructor's consent for a student to register for their
+// course, which satisfies "consent" prerequisite rules. Course editors can grant it for
+// any course.
+func GrantConsent(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return changeConsent(db, auditLog, true)
+}
+
+// RevokeConsent withdraws a consent given with GrantConsent.
+func RevokeConsent(db *gorm.DB, auditLog *audit.Logger) http.HandlerFunc {
+	return changeConsent(db, auditLog, false)
+}
+
+func changeConsent(db *gorm.DB, auditLog *audit.Logger, grant bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		callerIDStr, _ := r.Context().Value(middleware.UserIDContextKey).(string)
+		callerID, _ := uuid.Parse(callerIDStr)
+		studentID, err := uuid.Parse(r.PathValue("userId"))
+		if err != nil {
+			http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+			return
+		}
+
+		tx := db.Begin()
+		defer tx.Rollback()
+
+		var course models.Course
+		if !loadCourse(w, r, tx, &course) {
+			return
+		}
+		if course.InstructorID != callerID && !middleware.HasPermission(r.Context(), permissions.CourseUpdate) {
+			http.Error(w, "Forbidden: You are not the instructor for this course", http.StatusForbidden)
+			return
+		}
+
+		consent := models.PrerequisiteConsent{CourseID: course.ID, UserID: studentID, GrantedBy: callerID}
+		action := "course.consent.grant"
+		if grant {
+			err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&consent).Error
+		} else {
+			action = "course.consent.revoke"
+			err = tx.Delete(&models.PrerequisiteConsent{}, "course_id = ? AND user_id = ?", course.ID, studentID).Error
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to change consent: %v", err)
+			http.Error(w, "Failed to change consent", http.StatusInternalServerError)
+			return
+		}
+
+		event := audit.FromRequest(r, action, "registration", studentID.String()+"/"+course.ID.String())
+		if err := auditLog.Record(tx, event); err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, "Failed to record consent change", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			http.Error(w, "Failed to commit consent change", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		if grant {
+			w.Write([]byte(`{"message":"Consent granted"}`))
+		} else {
+			w.Write([]byte(`{"message":"Consent revoked"}`))
+		}
+	}
+}
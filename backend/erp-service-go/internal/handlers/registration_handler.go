@@ -3,12 +3,16 @@ package handlers
 import (
 	"encoding/json"
 	"erp/internal/models"
+	"erp/internal/prereq"
+	"errors"
 	"lms/pkg/middleware"
 	"log"
 	"net/http"
+	"slices"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type RegisterRequest struct {
@@ -31,40 +35,42 @@ func RegisterForCourse(db *gorm.DB) http.HandlerFunc {
 		tx := db.Begin()
 		defer tx.Rollback()
 
-		// 1. Fetch the course and its prerequisites
+		// 1. Fetch the course and its requisites. The share lock keeps the cap from
+		// changing until the registration commits.
 		var course models.Course
-		if err := tx.Preload("Prerequisites").First(&course, "id = ?", req.CourseID).Error; err != nil {
+		if err := tx.Clauses(clause.Locking{Strength: "SHARE"}).Preload("Prerequisites").Preload("AntiRequisites").Preload("CoRequisites").First(&course, "id = ?", req.CourseID).Error; err != nil {
 			http.Error(w, "Course not found", http.StatusNotFound)
 			return
 		}
+		if course.ArchivedAt != nil {
+			http.Error(w, "Course is archived and no longer open for registration", http.StatusConflict)
+			return
+		}
 
-		// 2. BUSINESS LOGIC: Check Course Cap
-		if course.CourseCap != nil {
-			var currentRegistrations int64
-			tx.Model(&models.Registration{}).Where("course_id = ? AND semester = ?", req.CourseID, req.Semester).Count(&currentRegistrations)
-			if currentRegistrations >= int64(*course.CourseCap) {
-				http.Error(w, "Course registration is full (cap reached)", http.StatusConflict)
-				return
-			}
+		// 2. BUSINESS LOGIC: Check prerequisites, anti-requisites and co-requisites
+		eligibility, err := checkRequisites(tx, userID, &course, req.Semester)
+		if err != nil {
+			log.Printf("ERROR: Failed to check requisites: %v", err)
+			http.Error(w, "Failed to check course requisites", http.StatusInternalServerError)
+			return
+		}
+		if len(eligibility.UnmetRules) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(RequisitesError{Error: "Course requisites not met", Eligibility: eligibility})
+			return
 		}
 
-		// 3. BUSINESS LOGIC: Check Prerequisites
-		if len(course.Prerequisites) > 0 {
-			var completedPrereqs int64
-			prereqIDs := []uuid.UUID{}
-			for _, p := range course.Prerequisites {
-				prereqIDs = append(prereqIDs, p.ID)
-			}
-			// Find how many of the required prerequisite courses the student has passed.
-			// NOTE: This assumes a simple 'Pass' status. A real system might check specific grades.
-			tx.Model(&models.Registration{}).
-				Where("user_id = ? AND course_id IN ? AND pass_fail_status = ?", userID, prereqIDs, "Pass").
-				Count(&completedPrereqs)
-
-			if completedPrereqs < int64(len(course.Prerequisites)) {
-				http.Error(w, "Prerequisite courses not met", http.StatusForbidden)
-				return
-			}
+		// 3. BUSINESS LOGIC: Claim a seat within the course cap
+		claimed, err := claimSeat(tx, &course, req.Semester)
+		if err != nil {
+			log.Printf("ERROR: Failed to claim seat: %v", err)
+			http.Error(w, "Failed to register for course", http.StatusInternalServerError)
+			return
+		}
+		if !claimed {
+			http.Error(w, "Course registration is full (cap reached)", http.StatusConflict)
+			return
 		}
 
 		// 4. If all checks pass, create the registration
@@ -80,12 +86,201 @@ func RegisterForCourse(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		tx.Commit()
+		if err := tx.Commit().Error; err != nil {
+			log.Printf("ERROR: Failed to commit registration: %v", err)
+			http.Error(w, "Failed to register for course", http.StatusInternalServerError)
+			return
+		}
 		w.WriteHeader(http.StatusCreated)
 		w.Write([]byte(`{"message":"Successfully registered for course"}`))
 	}
 }
 
+// CheckEligibility tells the calling student whether they can register for a course
+// and explains the prerequisite rule part by part. Co-requisites are checked against
+// ?semester=, by default the semester the course is offered in.
+func CheckEligibility(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userIDStr, _ := r.Context().Value(middleware.UserIDContextKey).(string)
+		userID, err := uuid.Parse(userIDStr)
+		if err != nil {
+			http.Error(w, "Could not identify user from context", http.StatusUnauthorized)
+			return
+		}
+
+		var course models.Course
+		if !loadCourse(w, r, db.Preload("Prerequisites").Preload("AntiRequisites").Preload("CoRequisites"), &course) {
+			return
+		}
+		semester := r.URL.Query().Get("semester")
+		if semester == "" {
+			semester = course.SemesterOffered
+		}
+
+		eligibility, err := checkRequisites(db, userID, &course, semester)
+		if err != nil {
+			log.Printf("ERROR: Failed to check requisites: %v", err)
+			http.Error(w, "Failed to check course requisites", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(struct {
+			CourseID   uuid.UUID `json:"courseId"`
+			CourseCode string    `json:"courseCode"`
+			Semester   string    `json:"semester"`
+			Archived   bool      `json:"archived"`
+			Eligible   bool      `json:"eligible"`
+			*Eligibility
+		}{course.ID, course.CourseCode, semester, course.ArchivedAt != nil,
+			course.ArchivedAt == nil && len(eligibility.UnmetRules) == 0, eligibility})
+	}
+}
+
+// claimSeat takes a seat in the course for the semester, reporting false when the course
+// is full. The offering's row stays locked until the transaction ends, so concurrent
+// registrations queue on it instead of all seeing the last seat free.
+func claimSeat(tx *gorm.DB, course *models.Course, semester string) (bool, error) {
+	// Registrations made before seats were counted are counted when the offering is first seen
+	err := tx.Exec(`INSERT INTO course_offerings (course_id, semester, seats_taken, updated_at)
+		SELECT ?, ?, COUNT(*), NOW() FROM registrations WHERE course_id = ? AND semester = ? AND deleted_at IS NULL
+		ON CONFLICT DO NOTHING`, course.ID, semester, course.ID, semester).Error
+	if err != nil {
+		return false, err
+	}
+
+	query := tx.Model(&models.CourseOffering{}).Where("course_id = ? AND semester = ?", course.ID, semester)
+	if course.CourseCap != nil {
+		query = query.Where("seats_taken < ?", *course.CourseCap)
+	}
+	result := query.Update("seats_taken", gorm.Expr("seats_taken + 1"))
+	return result.RowsAffected == 1, result.Error
+}
+
+// releaseSeat gives back the seat of a dropped registration.
+func releaseSeat(tx *gorm.DB, courseID uuid.UUID, semester string) error {
+	return tx.Model(&models.CourseOffering{}).
+		Where("course_id = ? AND semester = ? AND seats_taken > 0", courseID, semester).
+		Update("seats_taken", gorm.Expr("seats_taken - 1")).Error
+}
+
+// Kinds of registration rule.
+const (
+	rulePrerequisite  = "prerequisite"
+	ruleAntiRequisite = "anti-requisite"
+	ruleCoRequisite   = "co-requisite"
+)
+
+// UnmetRule names one requisite a student does not satisfy.
+type UnmetRule struct {
+	Rule       string     `json:"rule"`
+	CourseID   *uuid.UUID `json:"courseId,omitempty"`
+	CourseCode string     `json:"courseCode,omitempty"`
+	Reason     string     `json:"reason"`
+}
+
+// Eligibility is the outcome of checking a student against a course's rules.
+// Prerequisites explains the prerequisite rule part by part.
+type Eligibility struct {
+	Prerequisites *prereq.Result `json:"prerequisites"`
+	UnmetRules    []UnmetRule    `json:"unmetRules"`
+}
+
+// RequisitesError is the body of a registration refused for unmet requisites.
+type RequisitesError struct {
+	Error string `json:"error"`
+	*Eligibility
+}
+
+// checkRequisites checks a student's registration history against the course's rules,
+// which must be preloaded:
+//   - the prerequisite rule must be satisfied, or without one every prerequisite passed
+//   - every co-requisite must have been passed, or be taken in the same semester
+//   - no anti-requisite may have been passed or be in progress
+//
+// Anti-requisites work both ways, so courses that name this one as an anti-requisite
+// count too. Dropped registrations are ignored.
+func checkRequisites(tx *gorm.DB, userID uuid.UUID, course *models.Course, semester string) (*Eligibility, error) {
+	var history []models.Registration
+	if err := tx.Where("user_id = ?", userID).Find(&history).Error; err != nil {
+		return nil, err
+	}
+	var consents int64
+	if err := tx.Model(&models.PrerequisiteConsent{}).Where("course_id = ? AND user_id = ?", course.ID, userID).Count(&consents).Error; err != nil {
+		return nil, err
+	}
+
+	facts := prereq.Facts{Consent: consents > 0, CourseCodes: map[uuid.UUID]string{}}
+	for _, reg := range history {
+		facts.Attempts = append(facts.Attempts, prereq.Attempt{CourseID: reg.CourseID, Grade: reg.Grade, PassFailStatus: reg.PassFailStatus})
+	}
+	rule := course.PrerequisiteRule
+	if rule == nil {
+		var ids []uuid.UUID
+		for _, c := range course.Prerequisites {
+			ids = append(ids, c.ID)
+		}
+		rule = prereq.AllOf(ids)
+	}
+	for _, c := range course.Prerequisites {
+		facts.CourseCodes[c.ID] = c.CourseCode
+	}
+	eligibility := &Eligibility{Prerequisites: prereq.Evaluate(rule, facts), UnmetRules: []UnmetRule{}}
+	if !eligibility.Prerequisites.Satisfied {
+		eligibility.UnmetRules = append(eligibility.UnmetRules, UnmetRule{
+			Rule:   rulePrerequisite,
+			Reason: "requires " + eligibility.Prerequisites.Missing(),
+		})
+	}
+
+	passed := map[uuid.UUID]bool{}
+	inProgress := map[uuid.UUID]bool{}
+	thisSemester := map[uuid.UUID]bool{}
+	for _, reg := range history {
+		if reg.PassFailStatus != nil && *reg.PassFailStatus == "Pass" {
+			passed[reg.CourseID] = true
+		}
+		if reg.Grade == nil {
+			inProgress[reg.CourseID] = true
+		}
+		if reg.Semester == semester {
+			thisSemester[reg.CourseID] = true
+		}
+	}
+
+	var excludedBy []*models.Course
+	err := tx.Where("id IN (?)", tx.Table("course_anti_requisites").Select("course_id").Where("anti_requisite_id = ?", course.ID)).
+		Find(&excludedBy).Error
+	if err != nil {
+		return nil, err
+	}
+	antiRequisites := course.AntiRequisites
+	for _, c := range excludedBy {
+		if !slices.ContainsFunc(antiRequisites, func(a *models.Course) bool { return a.ID == c.ID }) {
+			antiRequisites = append(antiRequisites, c)
+		}
+	}
+
+	unmet := func(rule string, c *models.Course, reason string) {
+		eligibility.UnmetRules = append(eligibility.UnmetRules, UnmetRule{rule, &c.ID, c.CourseCode, reason})
+	}
+	for _, c := range course.CoRequisites {
+		if !passed[c.ID] && !thisSemester[c.ID] {
+			unmet(ruleCoRequisite, c, "must be passed or taken in "+semester)
+		}
+	}
+	for _, c := range antiRequisites {
+		switch {
+		case passed[c.ID]:
+			unmet(ruleAntiRequisite, c, "already passed")
+		case inProgress[c.ID]:
+			unmet(ruleAntiRequisite, c, "currently registered")
+		}
+	}
+	return eligibility, nil
+}
+
 // ... (ListMyRegistrations and DropCourse remain the same) ...
 func ListMyRegistrations(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -98,16 +293,36 @@ func ListMyRegistrations(db *gorm.DB) http.HandlerFunc {
 	}
 }
 
+// DropCourse withdraws the caller from a course and frees their seat. It answers 404 if
+// they were not registered for it that semester.
 func DropCourse(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userIDStr, _ := r.Context().Value(middleware.UserIDContextKey).(string)
-		courseIDStr := r.PathValue("courseId")
+		userID, _ := uuid.Parse(userIDStr)
+		courseID, err := uuid.Parse(r.PathValue("courseId"))
+		if err != nil {
+			http.Error(w, "Invalid course ID", http.StatusBadRequest)
+			return
+		}
 		semester := r.PathValue("semester")
-		err := db.Delete(&models.Registration{
-			UserID:   uuid.MustParse(userIDStr),
-			CourseID: uuid.MustParse(courseIDStr),
-			Semester: semester,
-		}).Error
+		err = db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Delete(&models.Registration{
+				UserID:   userID,
+				CourseID: courseID,
+				Semester: semester,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return releaseSeat(tx, courseID, semester)
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Registration not found", http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			log.Printf("ERROR: Failed to drop course: %v", err)
 			http.Error(w, "Failed to drop course", http.StatusInternalServerError)
@@ -0,0 +1,113 @@
+package prereq
+
+import (
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// Graph is the prerequisite graph: an edge runs from a course to each of its
+// prerequisites. For courses to be takeable it must be acyclic.
+type Graph struct {
+	prerequisites map[uuid.UUID][]uuid.UUID
+}
+
+// Edge says Prerequisite is a prerequisite of Course.
+type Edge struct {
+	CourseID       uuid.UUID
+	PrerequisiteID uuid.UUID
+}
+
+// NewGraph builds a graph from its edges.
+func NewGraph(edges []Edge) *Graph {
+	g := &Graph{prerequisites: map[uuid.UUID][]uuid.UUID{}}
+	for _, e := range edges {
+		g.prerequisites[e.CourseID] = append(g.prerequisites[e.CourseID], e.PrerequisiteID)
+	}
+	return g
+}
+
+// SetPrerequisites replaces the prerequisites of one course.
+func (g *Graph) SetPrerequisites(courseID uuid.UUID, prerequisiteIDs []uuid.UUID) {
+	g.prerequisites[courseID] = slices.Clone(prerequisiteIDs)
+}
+
+// Prerequisites returns the direct prerequisites of a course.
+func (g *Graph) Prerequisites(courseID uuid.UUID) []uuid.UUID {
+	return g.prerequisites[courseID]
+}
+
+// CycleThrough returns a cycle of prerequisites that starts and ends at the course, such
+// as [CS101, CS201, CS101], or nil if there is none.
+func (g *Graph) CycleThrough(courseID uuid.UUID) []uuid.UUID {
+	visited := map[uuid.UUID]bool{}
+	var path []uuid.UUID
+	var visit func(uuid.UUID) bool
+	visit = func(id uuid.UUID) bool {
+		path = append(path, id)
+		for _, p := range g.prerequisites[id] {
+			if p == courseID {
+				path = append(path, p)
+				return true
+			}
+			if !visited[p] {
+				visited[p] = true
+				if visit(p) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(courseID) {
+		return path
+	}
+	return nil
+}
+
+// LongestChains returns, for every course in the graph, the longest chain of
+// prerequisites ending in it, from a course with no prerequisites to the course itself.
+// A course's depth is the length of its chain minus one. Ties go to the chain whose
+// courses sort first by less, so the result is stable. Edges that close a cycle are
+// ignored.
+func (g *Graph) LongestChains(less func(a, b uuid.UUID) bool) map[uuid.UUID][]uuid.UUID {
+	chains := map[uuid.UUID][]uuid.UUID{}
+	visiting := map[uuid.UUID]bool{}
+	var chain func(uuid.UUID) []uuid.UUID
+	chain = func(id uuid.UUID) []uuid.UUID {
+		if c, ok := chains[id]; ok {
+			return c
+		}
+		visiting[id] = true
+		var best []uuid.UUID
+		for _, p := range g.prerequisites[id] {
+			if visiting[p] {
+				continue
+			}
+			c := chain(p)
+			if len(c) > len(best) || (len(c) == len(best) && chainLess(c, best, less)) {
+				best = c
+			}
+		}
+		visiting[id] = false
+		chains[id] = append(slices.Clone(best), id)
+		return chains[id]
+	}
+	for id, prerequisites := range g.prerequisites {
+		chain(id)
+		for _, p := range prerequisites {
+			chain(p)
+		}
+	}
+	return chains
+}
+
+func chainLess(a, b []uuid.UUID, less func(a, b uuid.UUID) bool) bool {
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return less(a[i], b[i])
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,279 @@
+// Package prereq models prerequisite rules as expressions and evaluates them against a
+// student's registration history.
+//
+// A rule is a tree. Leaves require a passed course, optionally with a minimum grade, or
+// the instructor's consent; "and" and "or" nodes combine them. For example
+// "(CS101 with B or better) OR (CS102 AND MA101)" is
+//
+//	{"op": "or", "rules": [
+//	  {"op": "course", "courseId": "<CS101>", "minGrade": "B"},
+//	  {"op": "and", "rules": [{"op": "course", "courseId": "<CS102>"}, {"op": "course", "courseId": "<MA101>"}]}
+//	]}
+package prereq
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Expression operators.
+const (
+	OpAnd     = "and"
+	OpOr      = "or"
+	OpCourse  = "course"
+	OpConsent = "consent"
+)
+
+// maxDepth bounds how deeply rules can nest.
+const maxDepth = 10
+
+// ErrInvalidExpr is wrapped by every validation error.
+var ErrInvalidExpr = errors.New("invalid prerequisite rule")
+
+// Expr is one node of a prerequisite rule.
+type Expr struct {
+	Op       string     `json:"op"`
+	Rules    []*Expr    `json:"rules,omitempty"`
+	CourseID *uuid.UUID `json:"courseId,omitempty"`
+	MinGrade string     `json:"minGrade,omitempty"`
+}
+
+// AllOf returns a rule requiring every one of the courses, as the plain prerequisite
+// list does. It returns nil, meaning no prerequisites, for no courses.
+func AllOf(courseIDs []uuid.UUID) *Expr {
+	if len(courseIDs) == 0 {
+		return nil
+	}
+	expr := &Expr{Op: OpAnd}
+	for _, id := range courseIDs {
+		expr.Rules = append(expr.Rules, &Expr{Op: OpCourse, CourseID: &id})
+	}
+	return expr
+}
+
+// Validate checks the shape of the rule; whether the courses exist is up to the caller.
+func (e *Expr) Validate() error {
+	return e.validate(1)
+}
+
+func (e *Expr) validate(depth int) error {
+	if e == nil {
+		return fmt.Errorf("%w: empty rule", ErrInvalidExpr)
+	}
+	if depth > maxDepth {
+		return fmt.Errorf("%w: rules nest more than %d deep", ErrInvalidExpr, maxDepth)
+	}
+	switch e.Op {
+	case OpAnd, OpOr:
+		if len(e.Rules) == 0 {
+			return fmt.Errorf("%w: %q needs at least one rule", ErrInvalidExpr, e.Op)
+		}
+		if e.CourseID != nil || e.MinGrade != "" {
+			return fmt.Errorf("%w: %q takes only rules", ErrInvalidExpr, e.Op)
+		}
+		for _, rule := range e.Rules {
+			if err := rule.validate(depth + 1); err != nil {
+				return err
+			}
+		}
+	case OpCourse:
+		if e.CourseID == nil {
+			return fmt.Errorf("%w: %q needs a courseId", ErrInvalidExpr, e.Op)
+		}
+		if len(e.Rules) > 0 {
+			return fmt.Errorf("%w: %q cannot have rules", ErrInvalidExpr, e.Op)
+		}
+		if _, ok := gradeRank[e.MinGrade]; e.MinGrade != "" && !ok {
+			return fmt.Errorf("%w: unknown grade %q", ErrInvalidExpr, e.MinGrade)
+		}
+	case OpConsent:
+		if len(e.Rules) > 0 || e.CourseID != nil || e.MinGrade != "" {
+			return fmt.Errorf("%w: %q takes no arguments", ErrInvalidExpr, e.Op)
+		}
+	default:
+		return fmt.Errorf("%w: unknown op %q", ErrInvalidExpr, e.Op)
+	}
+	return nil
+}
+
+// CourseIDs returns every course the rule refers to, each once.
+func (e *Expr) CourseIDs() []uuid.UUID {
+	var ids []uuid.UUID
+	seen := map[uuid.UUID]bool{}
+	var walk func(*Expr)
+	walk = func(e *Expr) {
+		if e == nil {
+			return
+		}
+		if e.CourseID != nil && !seen[*e.CourseID] {
+			seen[*e.CourseID] = true
+			ids = append(ids, *e.CourseID)
+		}
+		for _, rule := range e.Rules {
+			walk(rule)
+		}
+	}
+	walk(e)
+	return ids
+}
+
+// gradeRank orders letter grades; a higher rank is a better grade.
+var gradeRank = map[string]int{
+	"A+": 12, "A": 11, "A-": 10,
+	"B+": 9, "B": 8, "B-": 7,
+	"C+": 6, "C": 5, "C-": 4,
+	"D+": 3, "D": 2, "D-": 1,
+	"F": 0,
+}
+
+// MeetsGrade reports whether grade is minGrade or better. Grades outside the letter
+// scale never meet a minimum.
+func MeetsGrade(grade, minGrade string) bool {
+	have, ok := gradeRank[grade]
+	want, wantOK := gradeRank[minGrade]
+	return ok && wantOK && have >= want
+}
+
+// Attempt is one registration of the student in a course.
+type Attempt struct {
+	CourseID       uuid.UUID
+	Grade          *string
+	PassFailStatus *string
+}
+
+// Facts is what a rule is evaluated against.
+type Facts struct {
+	Attempts []Attempt
+	// Consent reports whether the instructor has consented to the student registering.
+	Consent bool
+	// CourseCodes names the courses in the explanation.
+	CourseCodes map[uuid.UUID]string
+}
+
+// Result explains the evaluation of one node of a rule.
+type Result struct {
+	Op         string     `json:"op"`
+	Satisfied  bool       `json:"satisfied"`
+	CourseID   *uuid.UUID `json:"courseId,omitempty"`
+	CourseCode string     `json:"courseCode,omitempty"`
+	MinGrade   string     `json:"minGrade,omitempty"`
+	// Grade is the best grade the student got in the course, if any.
+	Grade   string    `json:"grade,omitempty"`
+	Detail  string    `json:"detail"`
+	Results []*Result `json:"results,omitempty"`
+}
+
+// Evaluate checks a rule against the facts. A nil rule has no requirements.
+func Evaluate(e *Expr, facts Facts) *Result {
+	if e == nil {
+		return &Result{Op: OpAnd, Satisfied: true, Detail: "no prerequisites"}
+	}
+	switch e.Op {
+	case OpAnd, OpOr:
+		result := &Result{Op: e.Op, Satisfied: e.Op == OpAnd}
+		met := 0
+		for _, rule := range e.Rules {
+			child := Evaluate(rule, facts)
+			result.Results = append(result.Results, child)
+			if child.Satisfied {
+				met++
+			}
+		}
+		if e.Op == OpAnd {
+			result.Satisfied = met == len(e.Rules)
+			result.Detail = fmt.Sprintf("all of %d required, %d met", len(e.Rules), met)
+		} else {
+			result.Satisfied = met > 0
+			result.Detail = fmt.Sprintf("any of %d required, %d met", len(e.Rules), met)
+		}
+		return result
+	case OpCourse:
+		return evaluateCourse(e, facts)
+	case OpConsent:
+		if facts.Consent {
+			return &Result{Op: OpConsent, Satisfied: true, Detail: "instructor consent given"}
+		}
+		return &Result{Op: OpConsent, Detail: "instructor consent required"}
+	default:
+		return &Result{Op: e.Op, Detail: "unknown rule"}
+	}
+}
+
+func evaluateCourse(e *Expr, facts Facts) *Result {
+	result := &Result{Op: OpCourse, CourseID: e.CourseID, CourseCode: facts.CourseCodes[*e.CourseID], MinGrade: e.MinGrade}
+	passed := false
+	for _, attempt := range facts.Attempts {
+		if attempt.CourseID != *e.CourseID || attempt.PassFailStatus == nil || *attempt.PassFailStatus != "Pass" {
+			continue
+		}
+		passed = true
+		if attempt.Grade != nil && (result.Grade == "" || MeetsGrade(*attempt.Grade, result.Grade)) {
+			result.Grade = *attempt.Grade
+		}
+	}
+
+	switch {
+	case !passed:
+		result.Detail = "not passed"
+	case e.MinGrade == "":
+		result.Satisfied, result.Detail = true, "passed"
+	case MeetsGrade(result.Grade, e.MinGrade):
+		result.Satisfied, result.Detail = true, "passed with "+result.Grade
+	default:
+		result.Detail = fmt.Sprintf("passed with %s, %s or better required", result.Grade, e.MinGrade)
+	}
+	return result
+}
+
+// Missing describes what is still needed to satisfy the result, e.g.
+// "CS101 with B or better, or (CS102 and MA101)". It is empty when nothing is missing.
+func (r *Result) Missing() string {
+	if r.Satisfied {
+		return ""
+	}
+	return r.describe(false)
+}
+
+func (r *Result) describe(nested bool) string {
+	switch r.Op {
+	case OpCourse:
+		name := r.CourseCode
+		if name == "" {
+			name = r.CourseID.String()
+		}
+		if r.MinGrade != "" {
+			return name + " with " + r.MinGrade + " or better"
+		}
+		return name
+	case OpConsent:
+		return "instructor consent"
+	case OpAnd:
+		// Only the unmet parts of an "and" are still needed
+		var parts []string
+		for _, child := range r.Results {
+			if !child.Satisfied {
+				parts = append(parts, child.describe(true))
+			}
+		}
+		return group(parts, " and ", nested)
+	case OpOr:
+		var parts []string
+		for _, child := range r.Results {
+			parts = append(parts, child.describe(true))
+		}
+		return group(parts, ", or ", nested)
+	default:
+		return r.Detail
+	}
+}
+
+func group(parts []string, sep string, nested bool) string {
+	joined := strings.Join(parts, sep)
+	if nested && len(parts) > 1 {
+		return "(" + joined + ")"
+	}
+	return joined
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"gateway/internal/graph"
+	"gateway/internal/services"
 	"log"
 	"net/http"
 	"os"
@@ -18,7 +19,11 @@ func main() {
 		port = defaultPort
 	}
 
-	srv := handler.NewDefaultServer(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{}}))
+	resolver := &graph.Resolver{
+		// Classroom sync is internal-only, so the gateway calls it as its own service account
+		ServiceTokens: services.ServiceTokenSourceFromEnv("classroom:sync"),
+	}
+	srv := handler.NewDefaultServer(graph.NewExecutableSchema(graph.Config{Resolvers: resolver}))
 
 	// Create a new router
 	router := http.NewServeMux()
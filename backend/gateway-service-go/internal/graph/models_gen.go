@@ -52,14 +52,16 @@ type CreateAssignmentInput struct {
 }
 
 type CreateCourseInput struct {
-	CourseCode      string   `json:"courseCode"`
-	Name            string   `json:"name"`
-	Description     *string  `json:"description,omitempty"`
-	Credits         int      `json:"credits"`
-	InstructorID    string   `json:"instructorId"`
-	SemesterOffered *string  `json:"semesterOffered,omitempty"`
-	CourseCap       *int     `json:"courseCap,omitempty"`
-	PrerequisiteIds []string `json:"prerequisiteIds,omitempty"`
+	CourseCode       string   `json:"courseCode"`
+	Name             string   `json:"name"`
+	Description      *string  `json:"description,omitempty"`
+	Credits          int      `json:"credits"`
+	InstructorID     string   `json:"instructorId"`
+	SemesterOffered  *string  `json:"semesterOffered,omitempty"`
+	CourseCap        *int     `json:"courseCap,omitempty"`
+	PrerequisiteIds  []string `json:"prerequisiteIds,omitempty"`
+	AntiRequisiteIds []string `json:"antiRequisiteIds,omitempty"`
+	CoRequisiteIds   []string `json:"coRequisiteIds,omitempty"`
 }
 
 type CreateUserInput struct {
@@ -90,6 +92,11 @@ type Module struct {
 type Mutation struct {
 }
 
+type PageInfo struct {
+	HasNextPage bool    `json:"hasNextPage"`
+	EndCursor   *string `json:"endCursor,omitempty"`
+}
+
 type Query struct {
 }
 
@@ -125,4 +132,38 @@ type User struct {
 	Email    string  `json:"email"`
 	Role     string  `json:"role"`
 	FullName *string `json:"fullName,omitempty"`
+	Status   *string `json:"status,omitempty"`
+}
+
+type UserConnection struct {
+	Edges      []*UserEdge `json:"edges"`
+	PageInfo   *PageInfo   `json:"pageInfo"`
+	TotalCount int         `json:"totalCount"`
+}
+
+type UserDataExport struct {
+	UserID               string           `json:"userId"`
+	GeneratedAt          string           `json:"generatedAt"`
+	Account              map[string]any   `json:"account"`
+	CourseRegistrations  []map[string]any `json:"courseRegistrations"`
+	ProjectRegistrations []map[string]any `json:"projectRegistrations"`
+	AcademicStandings    []map[string]any `json:"academicStandings"`
+	Submissions          []map[string]any `json:"submissions"`
+}
+
+type UserEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *User  `json:"node"`
+}
+
+type UserFilter struct {
+	Role            *string `json:"role,omitempty"`
+	Status          *string `json:"status,omitempty"`
+	Branch          *string `json:"branch,omitempty"`
+	Department      *string `json:"department,omitempty"`
+	YearOfAdmission *int    `json:"yearOfAdmission,omitempty"`
+	IsTa            *bool   `json:"isTA,omitempty"`
+	CreatedAfter    *string `json:"createdAfter,omitempty"`
+	CreatedBefore   *string `json:"createdBefore,omitempty"`
+	Search          *string `json:"search,omitempty"`
 }
@@ -1,7 +1,12 @@
 package graph
 
+import "gateway/internal/services"
+
 // This file will not be regenerated automatically.
 //
 // It serves as dependency injection for your app, add any dependencies you require here.
 
-type Resolver struct{}
+type Resolver struct {
+	// ServiceTokens authenticates the gateway itself for internal-only calls.
+	ServiceTokens *services.ServiceTokenSource
+}
@@ -8,7 +8,10 @@ import (
 	"context"
 	"fmt"
 	"gateway/internal/services"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Login is the resolver for the login mutation.
@@ -64,6 +67,27 @@ func (r *mutationResolver) CreateUser(ctx context.Context, input CreateUserInput
 	}, nil
 }
 
+// AnonymizeUser is the resolver for the anonymizeUser field.
+func (r *mutationResolver) AnonymizeUser(ctx context.Context, userID string) (*User, error) {
+	authHeader, _ := ctx.Value(authTokenKey).(string)
+	authClient := services.AuthServiceClient{BaseURL: "http://localhost:8081"}
+
+	// Only the auth service holds personal details; ERP and classroom records are keyed
+	// by user ID alone, so they stay as they are and keep the statistics intact.
+	userResp, err := authClient.AnonymizeUser(authHeader, userID)
+	if err != nil {
+		return nil, err
+	}
+	fullName := userResp.DisplayName()
+	return &User{
+		ID:       userResp.ID,
+		Email:    userResp.Email,
+		Role:     userResp.Role,
+		FullName: &fullName,
+		Status:   &userResp.Status,
+	}, nil
+}
+
 // CreateCourse is the resolver for the createCourse mutation.
 func (r *mutationResolver) CreateCourse(ctx context.Context, input CreateCourseInput) (*Course, error) {
 	authHeader, _ := ctx.Value(authTokenKey).(string)
@@ -109,14 +133,14 @@ func (r *mutationResolver) SyncClassroom(ctx context.Context, courseID string, s
 
 	// 3. Get the Course details (for name and instructorId)
 	// This method now exists
-	courseResp, err := erpClient.GetCourseByID(authHeader, courseId)
+	courseResp, err := erpClient.GetCourseByID(authHeader, courseID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get course from ERP: %w", err)
 	}
 
 	// 4. Get the student roster from ERP service
 	// This endpoint already exists!
-	roster, err := erpClient.GetCourseRoster(authHeader, courseId, semester)
+	roster, err := erpClient.GetCourseRoster(authHeader, courseID, semester)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get roster from ERP: %w", err)
 	}
@@ -126,17 +150,33 @@ func (r *mutationResolver) SyncClassroom(ctx context.Context, courseID string, s
 		studentIDs = append(studentIDs, reg.UserID)
 	}
 
+	// TAs are assigned per course offering in the auth service
+	taAssignments, err := authClient.ListTAAssignments(authHeader, courseID, semester)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get TA assignments from auth service: %w", err)
+	}
+	taIDs := []string{}
+	for _, assignment := range taAssignments {
+		taIDs = append(taIDs, assignment.UserID)
+	}
+
 	// 5. Call the new classroom service's /sync endpoint
 	syncPayload := map[string]interface{}{
-		"course_id":     courseId,
+		"course_id":     courseID,
 		"instructor_id": courseResp.InstructorID,
 		"semester":      semester,
 		"name":          fmt.Sprintf("%s (%s)", courseResp.Name, semester),
 		"student_ids":   studentIDs,
+		"ta_ids":        taIDs,
 	}
 
-	// This method now exists
-	classroomResp, err := classroomClient.SyncClassroom(authHeader, syncPayload)
+	// Only the roster call above checks the caller may see this course; the sync itself is
+	// internal-only and made as the gateway's service account.
+	serviceAuth, err := r.ServiceTokens.AuthorizationHeader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate gateway: %w", err)
+	}
+	classroomResp, err := classroomClient.SyncClassroom(serviceAuth, syncPayload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sync classroom: %w", err)
 	}
@@ -147,7 +187,7 @@ func (r *mutationResolver) SyncClassroom(ctx context.Context, courseID string, s
 	// 7. FIX: Remove "graph." prefix
 	return &Classroom{
 		ID:       classroomResp.ID,
-		CourseId: classroomResp.CourseID,
+		CourseID: classroomResp.CourseID,
 		Name:     classroomResp.Name,
 		Semester: classroomResp.Semester,
 		Instructor: &User{ // <-- FIX: Removed "graph."
@@ -261,6 +301,106 @@ func (r *queryResolver) MyRegistrations(ctx context.Context) ([]*Registration, e
 	return gqlRegistrations, nil
 }
 
+// Users is the resolver for the users field.
+func (r *queryResolver) Users(ctx context.Context, first *int, after *string, filter *UserFilter, orderBy *string) (*UserConnection, error) {
+	authHeader, _ := ctx.Value(authTokenKey).(string)
+	authClient := services.AuthServiceClient{BaseURL: "http://localhost:8081"}
+
+	// Translate the GraphQL arguments into the auth service's query parameters.
+	params := url.Values{}
+	setParam := func(key string, value *string) {
+		if value != nil && *value != "" {
+			params.Set(key, *value)
+		}
+	}
+	if first != nil {
+		params.Set("first", strconv.Itoa(*first))
+	}
+	setParam("after", after)
+	setParam("sort", orderBy)
+	if filter != nil {
+		setParam("role", filter.Role)
+		setParam("status", filter.Status)
+		setParam("branch", filter.Branch)
+		setParam("department", filter.Department)
+		setParam("createdAfter", filter.CreatedAfter)
+		setParam("createdBefore", filter.CreatedBefore)
+		setParam("q", filter.Search)
+		if filter.YearOfAdmission != nil {
+			params.Set("yearOfAdmission", strconv.Itoa(*filter.YearOfAdmission))
+		}
+		if filter.IsTa != nil {
+			params.Set("isTA", strconv.FormatBool(*filter.IsTa))
+		}
+	}
+
+	list, err := authClient.ListUsers(authHeader, params)
+	if err != nil {
+		return nil, err
+	}
+
+	connection := &UserConnection{
+		Edges:      make([]*UserEdge, 0, len(list.Edges)),
+		PageInfo:   &PageInfo{HasNextPage: list.PageInfo.HasNextPage},
+		TotalCount: list.TotalCount,
+	}
+	if list.PageInfo.EndCursor != "" {
+		connection.PageInfo.EndCursor = &list.PageInfo.EndCursor
+	}
+	for _, edge := range list.Edges {
+		fullName := edge.User.DisplayName()
+		connection.Edges = append(connection.Edges, &UserEdge{
+			Cursor: edge.Cursor,
+			Node: &User{
+				ID:       edge.User.ID,
+				Email:    edge.User.Email,
+				Role:     edge.User.Role,
+				FullName: &fullName,
+				Status:   &edge.User.Status,
+			},
+		})
+	}
+	return connection, nil
+}
+
+// ExportUserData is the resolver for the exportUserData field.
+func (r *queryResolver) ExportUserData(ctx context.Context, userID string) (*UserDataExport, error) {
+	authHeader, _ := ctx.Value(authTokenKey).(string)
+	authClient := services.AuthServiceClient{BaseURL: "http://localhost:8081"}
+	erpClient := services.ERPServiceClient{BaseURL: "http://localhost:8082"}
+	classroomClient := services.ClassroomServiceClient{BaseURL: "http://localhost:8083"}
+
+	// An incomplete export would misstate what we hold, so any failing service fails it.
+	account, err := authClient.ExportUserData(authHeader, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export auth data: %w", err)
+	}
+	record, err := erpClient.GetStudentAcademicRecord(authHeader, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export ERP records: %w", err)
+	}
+	submissions, err := classroomClient.ListUserSubmissions(authHeader, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export classroom submissions: %w", err)
+	}
+
+	nonNil := func(items []map[string]interface{}) []map[string]interface{} {
+		if items == nil {
+			return []map[string]interface{}{}
+		}
+		return items
+	}
+	return &UserDataExport{
+		UserID:               userID,
+		GeneratedAt:          time.Now().UTC().Format(time.RFC3339),
+		Account:              account,
+		CourseRegistrations:  nonNil(record.CourseRegistrations),
+		ProjectRegistrations: nonNil(record.ProjectRegistrations),
+		AcademicStandings:    nonNil(record.AcademicStandings),
+		Submissions:          nonNil(submissions),
+	}, nil
+}
+
 // MyClassrooms is the resolver for the myClassrooms field.
 func (r *queryResolver) MyClassrooms(ctx context.Context) ([]*Classroom, error) {
 	panic(fmt.Errorf("not implemented: MyClassrooms - myClassrooms"))
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 )
 
 // ... (AuthServiceClient, AuthResponse, UserResponse, Login, GetCurrentUser, GetUserByID remain the same) ...
@@ -59,12 +60,47 @@ type AuthResponse struct {
 }
 
 type UserResponse struct {
-	ID       string `json:"ID"`
-	Email    string `json:"Email"`
-	Role     string `json:"Role"`
+	ID                string           `json:"ID"`
+	Email             string           `json:"Email"`
+	Role              string           `json:"Role"`
+	Status            string           `json:"Status"`
+	FullName          string           `json:"FullName"`
+	StudentProfile    *ProfileResponse `json:"StudentProfile,omitempty"`
+	InstructorProfile *ProfileResponse `json:"InstructorProfile,omitempty"`
+	AdminProfile      *ProfileResponse `json:"AdminProfile,omitempty"`
+}
+
+// ProfileResponse holds the profile fields the gateway reads, whichever role the user has.
+type ProfileResponse struct {
 	FullName string `json:"FullName"`
 }
 
+// DisplayName returns the user's full name from whichever profile they have.
+func (u *UserResponse) DisplayName() string {
+	if u.FullName != "" {
+		return u.FullName
+	}
+	for _, p := range []*ProfileResponse{u.StudentProfile, u.InstructorProfile, u.AdminProfile} {
+		if p != nil && p.FullName != "" {
+			return p.FullName
+		}
+	}
+	return ""
+}
+
+// UserListResponse is one page of the admin user directory.
+type UserListResponse struct {
+	Edges []struct {
+		Cursor string       `json:"cursor"`
+		User   UserResponse `json:"user"`
+	} `json:"edges"`
+	PageInfo struct {
+		EndCursor   string `json:"endCursor"`
+		HasNextPage bool   `json:"hasNextPage"`
+	} `json:"pageInfo"`
+	TotalCount int `json:"totalCount"`
+}
+
 func (c *AuthServiceClient) Login(email, password string) (*AuthResponse, error) {
 	loginURL := fmt.Sprintf("%s/login", c.BaseURL)
 	requestBody, err := json.Marshal(map[string]string{"email": email, "password": password})
@@ -135,3 +171,122 @@ func (c *AuthServiceClient) GetUserByID(adminToken, userID string) (*UserRespons
 	}
 	return &responseData.User, nil
 }
+
+// ListUsers calls the auth service's admin directory endpoint with the given query parameters.
+func (c *AuthServiceClient) ListUsers(adminToken string, params url.Values) (*UserListResponse, error) {
+	listURL := fmt.Sprintf("%s/admin/users?%s", c.BaseURL, params.Encode())
+	req, err := http.NewRequest("GET", listURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for ListUsers: %w", err)
+	}
+	req.Header.Add("Authorization", adminToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call auth service for ListUsers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("auth service returned an error for ListUsers: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var list UserListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode ListUsers response: %w", err)
+	}
+	return &list, nil
+}
+
+// TAAssignmentResponse is one course offering a student is a TA for.
+type TAAssignmentResponse struct {
+	UserID   string `json:"UserID"`
+	CourseID string `json:"CourseID"`
+	Semester string `json:"Semester"`
+}
+
+// ListTAAssignments returns the TA assignments for a course offering.
+func (c *AuthServiceClient) ListTAAssignments(token, courseID, semester string) ([]TAAssignmentResponse, error) {
+	params := url.Values{"courseId": {courseID}, "semester": {semester}}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/ta-assignments?%s", c.BaseURL, params.Encode()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for ListTAAssignments: %w", err)
+	}
+	req.Header.Add("Authorization", token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call auth service for ListTAAssignments: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("auth service returned an error for ListTAAssignments: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var responseData struct {
+		Assignments []TAAssignmentResponse `json:"assignments"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
+		return nil, fmt.Errorf("failed to decode ListTAAssignments response: %w", err)
+	}
+	return responseData.Assignments, nil
+}
+
+// ExportUserData returns everything the auth service holds about a user, as it sent it.
+func (c *AuthServiceClient) ExportUserData(adminToken, userID string) (map[string]interface{}, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/admin/users/%s/export", c.BaseURL, url.PathEscape(userID)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for ExportUserData: %w", err)
+	}
+	req.Header.Add("Authorization", adminToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call auth service for ExportUserData: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("auth service returned an error for ExportUserData: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var responseData struct {
+		Export map[string]interface{} `json:"export"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
+		return nil, fmt.Errorf("failed to decode ExportUserData response: %w", err)
+	}
+	return responseData.Export, nil
+}
+
+// AnonymizeUser scrubs a user's personal data in the auth service and returns what is left.
+func (c *AuthServiceClient) AnonymizeUser(adminToken, userID string) (*UserResponse, error) {
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/admin/users/%s/anonymize", c.BaseURL, url.PathEscape(userID)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for AnonymizeUser: %w", err)
+	}
+	req.Header.Add("Authorization", adminToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call auth service for AnonymizeUser: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("auth service returned an error for AnonymizeUser: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var responseData struct {
+		User UserResponse `json:"user"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
+		return nil, fmt.Errorf("failed to decode AnonymizeUser response: %w", err)
+	}
+	return &responseData.User, nil
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 )
 
 // This is the struct for the response from the Python service's /sync endpoint
@@ -72,3 +74,30 @@ func (c *ClassroomServiceClient) SyncClassroom(token string, payload map[string]
 
 	return &classroomResp, nil
 }
+
+// ListUserSubmissions returns every submission a user has made, for a data export. The
+// caller's token must grant privacy:manage.
+func (c *ClassroomServiceClient) ListUserSubmissions(adminToken, userID string) ([]map[string]interface{}, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/privacy/users/%s/submissions", c.BaseURL, url.PathEscape(userID)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for ListUserSubmissions: %w", err)
+	}
+	req.Header.Set("Authorization", adminToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call classroom service for ListUserSubmissions: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("classroom service returned an error for ListUserSubmissions: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var submissions []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&submissions); err != nil {
+		return nil, fmt.Errorf("failed to decode ListUserSubmissions response: %w", err)
+	}
+	return submissions, nil
+}
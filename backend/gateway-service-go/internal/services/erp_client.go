@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -121,7 +122,7 @@ func (c *ERPServiceClient) RegisterForCourse(token, courseID, semester string) (
 		Semester: semester,
 	}, nil
 }
-func (c *ERPServiceClient) GetCourseByID(token string, courseID string) (*ERPCourseResponse, error) {
+func (c *ERPServiceClient) GetCourseByID(token string, courseID string) (*CourseResponse, error) {
 	req, err := http.NewRequest("GET", c.BaseURL+"/courses/"+courseID, nil)
 	if err != nil {
 		return nil, err
@@ -139,10 +140,71 @@ func (c *ERPServiceClient) GetCourseByID(token string, courseID string) (*ERPCou
 		return nil, fmt.Errorf("ERP service returned non-200 status for get course: %d", resp.StatusCode)
 	}
 
-	var courseResp ERPCourseResponse
+	var courseResp CourseResponse
 	if err := json.NewDecoder(resp.Body).Decode(&courseResp); err != nil {
 		return nil, fmt.Errorf("failed to decode get course response: %w", err)
 	}
 
 	return &courseResp, nil
 }
+
+// GetCourseRoster fetches the registrations for a course in a semester (instructor only).
+func (c *ERPServiceClient) GetCourseRoster(token, courseID, semester string) ([]RegistrationResponse, error) {
+	rosterURL := fmt.Sprintf("%s/courses/%s/roster/%s", c.BaseURL, courseID, url.PathEscape(semester))
+	req, err := http.NewRequest("GET", rosterURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for GetCourseRoster: %w", err)
+	}
+	req.Header.Add("Authorization", token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call erp service for roster: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("erp service returned an error for roster: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var registrations []RegistrationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&registrations); err != nil {
+		return nil, fmt.Errorf("failed to decode roster response: %w", err)
+	}
+	return registrations, nil
+}
+
+// AcademicRecordResponse is a student's full academic history, passed through as the ERP
+// service sends it.
+type AcademicRecordResponse struct {
+	CourseRegistrations  []map[string]interface{} `json:"courseRegistrations"`
+	ProjectRegistrations []map[string]interface{} `json:"projectRegistrations"`
+	AcademicStandings    []map[string]interface{} `json:"academicStandings"`
+}
+
+// GetStudentAcademicRecord returns a student's registrations, projects and standings.
+func (c *ERPServiceClient) GetStudentAcademicRecord(adminToken, studentID string) (*AcademicRecordResponse, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/admin/erp/records/student/%s", c.BaseURL, url.PathEscape(studentID)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for GetStudentAcademicRecord: %w", err)
+	}
+	req.Header.Add("Authorization", adminToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call erp service for GetStudentAcademicRecord: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("erp service returned an error for GetStudentAcademicRecord: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var record AcademicRecordResponse
+	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+		return nil, fmt.Errorf("failed to decode GetStudentAcademicRecord response: %w", err)
+	}
+	return &record, nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serviceTokenRefreshMargin renews a cached token this long before it expires, so a
+// request never goes out with a token that lapses in flight.
+const serviceTokenRefreshMargin = 30 * time.Second
+
+// ServiceTokenSource gets access tokens for the gateway's own service account from the
+// auth service's client-credentials endpoint and caches them until shortly before expiry.
+// It is used for internal-only calls, such as classroom sync, that end-user tokens cannot make.
+type ServiceTokenSource struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// ServiceTokenSourceFromEnv configures the gateway's service account from GATEWAY_CLIENT_ID
+// and GATEWAY_CLIENT_SECRET (an API key issued in the auth service). AUTH_SERVICE_URL
+// overrides where the auth service lives.
+func ServiceTokenSourceFromEnv(scopes ...string) *ServiceTokenSource {
+	baseURL := os.Getenv("AUTH_SERVICE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8081"
+	}
+	return &ServiceTokenSource{
+		TokenURL:     baseURL + "/oauth/token",
+		ClientID:     os.Getenv("GATEWAY_CLIENT_ID"),
+		ClientSecret: os.Getenv("GATEWAY_CLIENT_SECRET"),
+		Scopes:       scopes,
+	}
+}
+
+// AuthorizationHeader returns a "Bearer ..." header value, fetching a new token if needed.
+func (s *ServiceTokenSource) AuthorizationHeader() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Before(s.expiresAt) {
+		return "Bearer " + s.token, nil
+	}
+	if s.ClientSecret == "" {
+		return "", fmt.Errorf("gateway service account is not configured: set GATEWAY_CLIENT_ID and GATEWAY_CLIENT_SECRET")
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.Scopes, " "))
+	}
+	req, err := http.NewRequest("POST", s.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request for service token: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.ClientID, s.ClientSecret)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call auth service for service token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("auth service returned an error for service token: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode service token response: %w", err)
+	}
+	s.token = body.AccessToken
+	s.expiresAt = time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - serviceTokenRefreshMargin)
+	return "Bearer " + s.token, nil
+}
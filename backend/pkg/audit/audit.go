@@ -0,0 +1,202 @@
+// Package audit records security- and grade-relevant changes in an append-only,
+// hash-chained table shared by every service. Each entry stores the SHA-256 of its own
+// content and of the entry before it, so editing or deleting any row breaks the chain
+// from that point on, which Verify detects. Deleting the newest rows leaves a shorter but
+// intact chain; only a Checkpoint kept outside the database reveals that.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"lms/pkg/middleware"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// chainLockKey is the Postgres advisory lock that serialises appends across services.
+const chainLockKey = 0x6c6d7361756474 // "lmsaudt"
+
+// Entry is one row of the audit log. Rows are never updated or deleted.
+type Entry struct {
+	Seq        int64     `gorm:"primaryKey;autoIncrement" json:"seq"`
+	ID         uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"id"`
+	Service    string    `gorm:"type:varchar(50);not null;index" json:"service"`
+	ActorID    string    `gorm:"type:varchar(64);index" json:"actorId,omitempty"`
+	ActorRole  string    `gorm:"type:varchar(50)" json:"actorRole,omitempty"`
+	Action     string    `gorm:"type:varchar(100);not null;index" json:"action"`
+	TargetType string    `gorm:"type:varchar(50);index:idx_audit_target" json:"targetType,omitempty"`
+	TargetID   string    `gorm:"type:varchar(100);index:idx_audit_target" json:"targetId,omitempty"`
+	Diff       string    `gorm:"type:text" json:"diff,omitempty"`
+	RequestID  string    `gorm:"type:varchar(100);index" json:"requestId,omitempty"`
+	IPAddress  string    `gorm:"type:varchar(64)" json:"ipAddress,omitempty"`
+	CreatedAt  time.Time `gorm:"not null;index" json:"createdAt"`
+	PrevHash   string    `gorm:"type:varchar(64);not null" json:"prevHash"`
+	Hash       string    `gorm:"type:varchar(64);not null;uniqueIndex" json:"hash"`
+}
+
+// TableName keeps one audit table for every service.
+func (Entry) TableName() string {
+	return "audit_log"
+}
+
+// Event describes a change to record. Before and After can be any JSON-serialisable
+// values (usually the model before and after the change); only the fields that differ
+// are stored.
+type Event struct {
+	ActorID    string
+	ActorRole  string
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     interface{}
+	After      interface{}
+	RequestID  string
+	IPAddress  string
+}
+
+// FromRequest fills in the actor, request ID and client IP of an authenticated request.
+func FromRequest(r *http.Request, action, targetType, targetID string) Event {
+	actorID, _ := r.Context().Value(middleware.UserIDContextKey).(string)
+	actorRole, _ := r.Context().Value(middleware.UserRoleContextKey).(string)
+	return Event{
+		ActorID:    actorID,
+		ActorRole:  actorRole,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		RequestID:  r.Header.Get("X-Request-ID"),
+		IPAddress:  middleware.ClientIP(r),
+	}
+}
+
+// Logger appends entries on behalf of one service.
+type Logger struct {
+	db      *gorm.DB
+	service string
+}
+
+// New returns a Logger that tags entries with the service name.
+func New(db *gorm.DB, service string) *Logger {
+	return &Logger{db: db, service: service}
+}
+
+// Migrate creates the audit table.
+func (l *Logger) Migrate() error {
+	return l.db.AutoMigrate(&Entry{})
+}
+
+// Record appends an event. Pass the transaction that makes the change, so the change
+// and its audit entry commit or roll back together; nil uses the Logger's own connection.
+func (l *Logger) Record(tx *gorm.DB, event Event) error {
+	diff, err := Diff(event.Before, event.After)
+	if err != nil {
+		return err
+	}
+	entry := Entry{
+		ID:         uuid.New(),
+		Service:    l.service,
+		ActorID:    event.ActorID,
+		ActorRole:  event.ActorRole,
+		Action:     event.Action,
+		TargetType: event.TargetType,
+		TargetID:   event.TargetID,
+		Diff:       diff,
+		RequestID:  event.RequestID,
+		IPAddress:  event.IPAddress,
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	if tx == nil {
+		tx = l.db
+	}
+	return tx.Transaction(func(tx *gorm.DB) error {
+		// Held until the surrounding transaction ends, so no two entries share a predecessor
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", chainLockKey).Error; err != nil {
+			return fmt.Errorf("failed to lock audit chain: %w", err)
+		}
+		var last Entry
+		err := tx.Order("seq DESC").Limit(1).Find(&last).Error
+		if err != nil {
+			return fmt.Errorf("failed to read audit chain head: %w", err)
+		}
+		entry.PrevHash = last.Hash
+		entry.Hash = entry.computeHash()
+		if err := tx.Create(&entry).Error; err != nil {
+			return fmt.Errorf("failed to write audit entry: %w", err)
+		}
+		return nil
+	})
+}
+
+// computeHash covers every field except Seq (assigned by the database) and Hash itself.
+func (e *Entry) computeHash() string {
+	content, _ := json.Marshal([]string{
+		e.PrevHash, e.ID.String(), e.Service, e.ActorID, e.ActorRole, e.Action,
+		e.TargetType, e.TargetID, e.Diff, e.RequestID, e.IPAddress,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Diff returns a JSON object mapping each changed field to {"from": ..., "to": ...}.
+// With no Before it records the full After value as created, and vice versa for deletes.
+func Diff(before, after interface{}) (string, error) {
+	if before == nil && after == nil {
+		return "", nil
+	}
+	from, err := toMap(before)
+	if err != nil {
+		return "", err
+	}
+	to, err := toMap(after)
+	if err != nil {
+		return "", err
+	}
+
+	changes := map[string]map[string]interface{}{}
+	for key, value := range to {
+		if old, ok := from[key]; !ok || !reflect.DeepEqual(old, value) {
+			changes[key] = map[string]interface{}{"from": from[key], "to": value}
+		}
+	}
+	for key, old := range from {
+		if _, ok := to[key]; !ok {
+			changes[key] = map[string]interface{}{"from": old, "to": nil}
+		}
+	}
+	if len(changes) == 0 {
+		return "", nil
+	}
+	out, err := json.Marshal(changes)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit diff: %w", err)
+	}
+	return string(out), nil
+}
+
+func toMap(value interface{}) (map[string]interface{}, error) {
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Pointer && reflect.ValueOf(value).IsNil()) {
+		return map[string]interface{}{}, nil
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit value: %w", err)
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		// Not an object (e.g. a plain string); record it whole
+		var scalar interface{}
+		if err := json.Unmarshal(raw, &scalar); err != nil {
+			return nil, fmt.Errorf("failed to decode audit value: %w", err)
+		}
+		return map[string]interface{}{"value": scalar}, nil
+	}
+	return out, nil
+}
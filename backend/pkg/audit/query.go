@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Filter narrows a Query. Zero values are ignored.
+type Filter struct {
+	Service    string
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	RequestID  string
+	Since      time.Time
+	Until      time.Time
+	// BeforeSeq pages backwards through the log: only entries with a lower seq are returned.
+	BeforeSeq int64
+	Limit     int
+}
+
+// DefaultLimit is the page size Query uses when Filter.Limit is unset, and MaxLimit the
+// largest it returns.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 500
+)
+
+// Query returns matching entries, newest first.
+func Query(db *gorm.DB, f Filter) ([]Entry, error) {
+	query := db.Model(&Entry{})
+	for column, value := range map[string]string{
+		"service":     f.Service,
+		"actor_id":    f.ActorID,
+		"action":      f.Action,
+		"target_type": f.TargetType,
+		"target_id":   f.TargetID,
+		"request_id":  f.RequestID,
+	} {
+		if value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if !f.Since.IsZero() {
+		query = query.Where("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		query = query.Where("created_at < ?", f.Until)
+	}
+	if f.BeforeSeq > 0 {
+		query = query.Where("seq < ?", f.BeforeSeq)
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	limit = min(limit, MaxLimit)
+
+	var entries []Entry
+	if err := query.Order("seq DESC").Limit(limit).Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// Break describes the first place the chain fails to verify.
+type Break struct {
+	Seq    int64  `json:"seq"`
+	Reason string `json:"reason"`
+}
+
+// VerifyResult summarises a chain verification. HeadSeq and Head identify the newest
+// entry and can be kept as the Checkpoint for the next run.
+type VerifyResult struct {
+	Checked int64  `json:"checked"`
+	HeadSeq int64  `json:"headSeq,omitempty"`
+	Head    string `json:"head,omitempty"`
+	Break   *Break `json:"break,omitempty"`
+}
+
+// Checkpoint is a chain position from an earlier verification, stored somewhere the
+// database's writers cannot change it.
+type Checkpoint struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Verify walks the whole chain in order, recomputing each hash and checking that it
+// links to the previous entry. It stops at the first break.
+//
+// The chain only proves its own consistency: with the newest entries deleted, what is
+// left still verifies. Pass the checkpoint from an earlier run to also require that the
+// entry it names is still present and unchanged; with a nil checkpoint, removal from the
+// end of the log cannot be detected.
+func Verify(db *gorm.DB, checkpoint *Checkpoint) (*VerifyResult, error) {
+	result := &VerifyResult{}
+	var prevHash string
+	var lastSeq int64
+	const batchSize = 1000
+
+	for {
+		var batch []Entry
+		if err := db.Where("seq > ?", lastSeq).Order("seq").Limit(batchSize).Find(&batch).Error; err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		for i := range batch {
+			entry := &batch[i]
+			switch {
+			case entry.PrevHash != prevHash:
+				result.Break = &Break{Seq: entry.Seq, Reason: "previous hash does not match the entry before it (an entry was removed or altered)"}
+			case entry.computeHash() != entry.Hash:
+				result.Break = &Break{Seq: entry.Seq, Reason: "content does not match its hash (the entry was altered)"}
+			case checkpoint != nil && entry.Seq >= checkpoint.Seq && lastSeq < checkpoint.Seq &&
+				(entry.Seq != checkpoint.Seq || entry.Hash != checkpoint.Hash):
+				result.Break = &Break{Seq: checkpoint.Seq, Reason: "the checkpoint entry is missing or differs (the log was rewritten)"}
+			}
+			if result.Break != nil {
+				return result, nil
+			}
+			prevHash = entry.Hash
+			lastSeq = entry.Seq
+			result.Checked++
+		}
+		if len(batch) < batchSize {
+			break
+		}
+	}
+	if checkpoint != nil && lastSeq < checkpoint.Seq {
+		result.Break = &Break{Seq: checkpoint.Seq, Reason: "the log ends before the checkpoint (the newest entries were removed)"}
+		return result, nil
+	}
+	result.HeadSeq = lastSeq
+	result.Head = prevHash
+	return result, nil
+}
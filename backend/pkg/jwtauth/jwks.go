@@ -0,0 +1,176 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeyProvider returns the public key for a "kid" token header.
+type KeyProvider func(kid string) (crypto.PublicKey, error)
+
+const (
+	// jwksMaxAge is how long a fetched key set is used before it is refreshed.
+	jwksMaxAge = 10 * time.Minute
+	// jwksMinRefreshInterval stops unknown kids from making us hammer the auth service.
+	jwksMinRefreshInterval = 30 * time.Second
+)
+
+var (
+	providerMu  sync.RWMutex
+	keyProvider KeyProvider
+)
+
+// SetKeyProvider replaces where ValidateToken looks up verification keys. The auth service
+// installs its own key set; every other service fetches the auth service's JWKS.
+func SetKeyProvider(p KeyProvider) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	keyProvider = p
+}
+
+func getKeyProvider() KeyProvider {
+	providerMu.RLock()
+	p := keyProvider
+	providerMu.RUnlock()
+	if p != nil {
+		return p
+	}
+
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if keyProvider == nil {
+		jwksURL := os.Getenv("JWKS_URL")
+		if jwksURL == "" {
+			jwksURL = authServiceURL() + "/.well-known/jwks.json"
+		}
+		keyProvider = NewJWKSCache(jwksURL).Key
+	}
+	return keyProvider
+}
+
+// authServiceURL is the base URL other services use to reach the auth service.
+func authServiceURL() string {
+	if baseURL := os.Getenv("AUTH_SERVICE_URL"); baseURL != "" {
+		return baseURL
+	}
+	return "http://localhost:8081"
+}
+
+// JWKSCache fetches a JSON Web Key Set and keeps it in memory. It refetches when the
+// set gets old or when a token names a kid it has not seen, which is how newly rotated
+// keys are picked up without a restart.
+type JWKSCache struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewJWKSCache creates a cache for the key set published at url.
+func NewJWKSCache(url string) *JWKSCache {
+	return &JWKSCache{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Key returns the public key with the given kid, fetching the key set if needed.
+func (c *JWKSCache) Key(kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[kid]; ok && time.Since(c.fetchedAt) < jwksMaxAge {
+		return key, nil
+	}
+	if c.keys == nil || time.Since(c.fetchedAt) >= jwksMinRefreshInterval {
+		if err := c.refresh(); err != nil {
+			// Fall back to what we already have rather than failing every request.
+			if key, ok := c.keys[kid]; ok {
+				return key, nil
+			}
+			return nil, err
+		}
+	}
+
+	key, ok := c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+func (c *JWKSCache) refresh() error {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS endpoint returned an error: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue // skip key types we do not understand
+		}
+		keys[jwk.Kid] = key
+	}
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	return nil
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key length")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,110 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// SessionValidator reports whether the session with the given ID is still active. token
+// is the access token naming the session, which the auth service requires as proof that
+// the caller may ask about it.
+type SessionValidator func(sessionID, token string) (bool, error)
+
+// sessionCacheTTL bounds how long an "active" answer is trusted before asking again.
+// Revoked answers are cached for good, since a session can never be reinstated.
+const sessionCacheTTL = 15 * time.Second
+
+// maxCachedSessions caps the cache; when full it is simply cleared.
+const maxCachedSessions = 10000
+
+var (
+	validatorMu      sync.RWMutex
+	sessionValidator SessionValidator
+)
+
+// SetSessionValidator replaces the check ValidateToken runs against the "sid" claim.
+// The auth service installs a database-backed validator; every other service falls
+// back to asking the auth service over HTTP.
+func SetSessionValidator(v SessionValidator) {
+	validatorMu.Lock()
+	defer validatorMu.Unlock()
+	sessionValidator = v
+}
+
+func getSessionValidator() SessionValidator {
+	validatorMu.RLock()
+	v := sessionValidator
+	validatorMu.RUnlock()
+	if v != nil {
+		return v
+	}
+
+	validatorMu.Lock()
+	defer validatorMu.Unlock()
+	if sessionValidator == nil {
+		sessionValidator = NewRemoteSessionValidator(authServiceURL())
+	}
+	return sessionValidator
+}
+
+type sessionCacheEntry struct {
+	active    bool
+	expiresAt time.Time
+}
+
+// NewRemoteSessionValidator returns a validator that asks the auth service's
+// GET /sessions/{id} endpoint, presenting the token being validated, and caches the
+// answers briefly.
+func NewRemoteSessionValidator(baseURL string) SessionValidator {
+	client := &http.Client{Timeout: 5 * time.Second}
+	var mu sync.Mutex
+	cache := make(map[string]sessionCacheEntry)
+
+	return func(sessionID, token string) (bool, error) {
+		mu.Lock()
+		entry, ok := cache[sessionID]
+		mu.Unlock()
+		if ok && (!entry.active || time.Now().Before(entry.expiresAt)) {
+			return entry.active, nil
+		}
+
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/sessions/%s", baseURL, url.PathEscape(sessionID)), nil)
+		if err != nil {
+			return false, fmt.Errorf("failed to create session status request: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := client.Do(req)
+		if err != nil {
+			return false, fmt.Errorf("failed to call auth service for session status: %w", err)
+		}
+		defer resp.Body.Close()
+
+		var active bool
+		switch resp.StatusCode {
+		case http.StatusOK:
+			var body struct {
+				Active bool `json:"active"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				return false, fmt.Errorf("failed to decode session status: %w", err)
+			}
+			active = body.Active
+		case http.StatusNotFound:
+			active = false
+		default:
+			return false, fmt.Errorf("auth service returned an error for session status: %s", resp.Status)
+		}
+
+		mu.Lock()
+		if len(cache) >= maxCachedSessions {
+			cache = make(map[string]sessionCacheEntry)
+		}
+		cache[sessionID] = sessionCacheEntry{active: active, expiresAt: time.Now().Add(sessionCacheTTL)}
+		mu.Unlock()
+		return active, nil
+	}
+}
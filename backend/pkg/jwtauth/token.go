@@ -1,40 +1,134 @@
 package jwtauth
 
 import (
+	"crypto/ed25519"
+	"crypto/rsa"
 	"errors"
 	"fmt"
-	"os"
+	"strings"
 
 	jwt "github.com/golang-jwt/jwt/v5"
 )
 
 // CustomClaims defines our custom JWT claims structure.
 type CustomClaims struct {
-	Role string `json:"role"`
+	Role        string   `json:"role"`
+	SessionID   string   `json:"sid,omitempty"`
+	Permissions []string `json:"perms,omitempty"`
+	Actor       *Actor   `json:"act,omitempty"`
+	ClientID    string   `json:"client_id,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// IsService reports whether the token was issued to a service account through the
+// client-credentials grant rather than to a logged-in user. Such a token is its own
+// client: its subject and client_id are both the service account ID. Tokens issued to
+// an OpenID Connect client on a user's behalf name the user as subject instead.
+func (c *CustomClaims) IsService() bool {
+	return c.ClientID != "" && c.ClientID == c.Subject
+}
+
+// IsClient reports whether the token was issued to an OpenID Connect client on a user's
+// behalf. Such a token names the client as its audience and carries only the granted
+// scopes; it is meant for the userinfo endpoint, not for acting as the user elsewhere.
+func (c *CustomClaims) IsClient() bool {
+	return c.ClientID != "" && !c.IsService()
+}
+
+// Scopes returns the space-separated "scope" claim (RFC 9068) as a list.
+func (c *CustomClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Actor is the RFC 8693 "act" claim. It is set when someone other than the subject is
+// using the token, e.g. an admin viewing the system as a student.
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+// ActorID returns the ID of the admin acting as the subject, or "" for a normal token.
+func (c *CustomClaims) ActorID() string {
+	if c.Actor == nil {
+		return ""
+	}
+	return c.Actor.Subject
+}
+
+// AccessTokenType is the "typ" header of access tokens (RFC 9068). Every other JWT the
+// auth service issues, such as MFA challenges, uses a different type and is rejected here.
+const AccessTokenType = "at+jwt"
+
+// ErrSessionRevoked is returned when a token belongs to a session that has been ended.
+var ErrSessionRevoked = errors.New("session has been revoked")
+
 // ValidateToken parses and validates a token string. It is shared across services.
+// Tokens must be signed by the auth service with RS256 or EdDSA and name their key in
+// the "kid" header; services only ever hold the public keys.
 func ValidateToken(tokenString string) (*jwt.Token, *CustomClaims, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return nil, nil, errors.New("JWT_SECRET environment variable is not set or empty")
+	token, claims, err := ParseToken(tokenString)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	// Every user token is bound to a session; only service account tokens have none and
+	// are bounded by their expiry alone.
+	if claims.SessionID == "" && !claims.IsService() {
+		return nil, nil, errors.New("token has no session")
+	}
+	if claims.SessionID != "" {
+		active, err := getSessionValidator()(claims.SessionID, tokenString)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to check session: %w", err)
 		}
-		return []byte(jwtSecret), nil
-	})
+		if !active {
+			return nil, nil, ErrSessionRevoked
+		}
+	}
+
+	return token, claims, nil
+}
+
+// ParseToken checks a token's signature, type and expiry like ValidateToken, but not
+// whether its session is still active. It is for the session status endpoint itself.
+func ParseToken(tokenString string) (*jwt.Token, *CustomClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, verificationKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
 
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
-	if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
-		return token, claims, nil
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || !token.Valid {
+		return nil, nil, errors.New("invalid token")
+	}
+	return token, claims, nil
+}
+
+// verificationKey resolves the public key for a token and checks it matches the signing method.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if typ, _ := token.Header["typ"].(string); typ != AccessTokenType {
+		return nil, fmt.Errorf("token is not an access token")
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+	key, err := getKeyProvider()(kid)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, nil, errors.New("invalid token")
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA:
+		if _, ok := key.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	case *jwt.SigningMethodEd25519:
+		if _, ok := key.(ed25519.PublicKey); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("key %q does not match signing method %v", kid, token.Header["alg"])
 }
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is an in-memory token-bucket limiter keyed by an arbitrary string,
+// usually the client IP. Each key gets a bucket of Burst tokens that refills at
+// the configured rate; a request spends one token.
+type RateLimiter struct {
+	ratePerSecond float64
+	burst         float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// maxBuckets bounds memory; idle buckets are swept once this many keys are tracked.
+const maxBuckets = 10000
+
+// NewRateLimiter creates a limiter allowing perMinute requests per key on average,
+// with bursts of up to burst requests.
+func NewRateLimiter(perMinute float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		ratePerSecond: perMinute / 60,
+		burst:         float64(burst),
+		buckets:       make(map[string]*tokenBucket),
+	}
+}
+
+// Allow spends a token for key. When the bucket is empty it returns false and how long
+// the caller should wait before the next token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.sweep(now)
+		}
+		b = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*l.ratePerSecond)
+	b.lastSeen = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	if l.ratePerSecond <= 0 {
+		return false, time.Hour
+	}
+	wait := time.Duration((1 - b.tokens) / l.ratePerSecond * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have been idle long enough to have refilled completely.
+func (l *RateLimiter) sweep(now time.Time) {
+	refill := time.Hour
+	if l.ratePerSecond > 0 {
+		refill = time.Duration(l.burst / l.ratePerSecond * float64(time.Second))
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware limits requests per client IP and answers 429 with Retry-After when exceeded.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(ClientIP(r)); !ok {
+			WriteTooManyRequests(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WriteTooManyRequests writes a 429 response with a Retry-After header in whole seconds.
+func WriteTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, `{"error":"Too many requests","retryAfter":%d}`+"\n", seconds)
+}
+
+// ClientIP returns the caller's IP address. X-Forwarded-For can be set to anything by
+// the client, so it is read from the right: each trusted proxy appends the address it
+// received the request from, and the client is the first hop that is not a trusted
+// proxy. Proxies are trusted either by address, with TRUSTED_PROXIES (a comma-separated
+// list of IPs and CIDRs), or by count, with TRUSTED_PROXY_COUNT hops in front of the
+// service. TRUST_PROXY_HEADERS=true is shorthand for a count of one. Without either,
+// X-Forwarded-For is ignored.
+func ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	config := proxyConfig()
+	if len(config.networks) == 0 && config.count == 0 {
+		return remote
+	}
+	// The nearest hop first: the peer, then X-Forwarded-For from right to left
+	hops := []string{remote}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if hop := strings.TrimSpace(forwarded[i]); hop != "" {
+			hops = append(hops, hop)
+		}
+	}
+
+	if len(config.networks) == 0 {
+		return hops[min(config.count, len(hops)-1)]
+	}
+	for _, hop := range hops {
+		if !config.trusts(hop) {
+			return hop
+		}
+	}
+	return hops[len(hops)-1]
+}
+
+// trustedProxies says which hops in front of the service may add to X-Forwarded-For.
+type trustedProxies struct {
+	networks []*net.IPNet
+	count    int
+}
+
+func (t trustedProxies) trusts(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range t.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConfig reads the trusted proxies from the environment once.
+var proxyConfig = sync.OnceValue(func() trustedProxies {
+	var config trustedProxies
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Warning: ignoring invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+			continue
+		}
+		config.networks = append(config.networks, network)
+	}
+	if n, err := strconv.Atoi(os.Getenv("TRUSTED_PROXY_COUNT")); err == nil && n > 0 {
+		config.count = n
+	} else if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		config.count = 1
+	}
+	return config
+})
@@ -0,0 +1,122 @@
+// Package permissions is the registry of permission names shared by every service.
+// Roles and their permissions live in the auth database and can be changed by admins;
+// the defaults here are only used to seed the built-in roles.
+package permissions
+
+import "strings"
+
+// Permission names follow "resource:action". A trailing "*" grants every permission
+// with that prefix, so "course:*" covers "course:create", and "*" covers everything.
+const (
+	All = "*"
+
+	UsersRead     = "users:read"
+	UsersManage   = "users:manage"
+	Impersonate   = "users:impersonate"
+	RolesManage   = "roles:manage"
+	TAManage      = "ta:manage"
+	AuditRead     = "audit:read"
+	PrivacyManage = "privacy:manage"
+
+	ServiceAccountsManage = "service_accounts:manage"
+	OIDCClientsManage     = "oidc_clients:manage"
+
+	CourseCreate    = "course:create"
+	CourseUpdate    = "course:update"
+	RosterRead      = "roster:read"
+	RosterReadAny   = "roster:read:any"
+	GradesSubmit    = "grades:submit"
+	RecordsRead     = "records:read"
+	RegistrationOwn = "registration:self"
+
+	ClassroomManage = "classroom:manage"
+	ClassroomSubmit = "classroom:submit"
+)
+
+// Scopes that only service accounts can hold. A service account can also be given any
+// registered permission except "*" as a scope, and its tokens then carry it as one.
+const (
+	ClassroomSync = "classroom:sync"
+)
+
+// Definition describes a registered permission.
+type Definition struct {
+	Name        string
+	Description string
+}
+
+// Registry lists every permission the services check.
+var Registry = []Definition{
+	{All, "Every permission, including ones added later"},
+	{UsersRead, "View the user directory and user profiles"},
+	{UsersManage, "Create, import, update, deactivate and unlock users"},
+	{Impersonate, "View the system as another user, read-only"},
+	{RolesManage, "Define roles and the permissions they grant"},
+	{TAManage, "Grant and revoke teaching assistant assignments"},
+	{AuditRead, "Read the audit log"},
+	{PrivacyManage, "Export and anonymize a user's personal data for privacy requests"},
+	{ServiceAccountsManage, "Create service accounts and issue or revoke their API keys"},
+	{OIDCClientsManage, "Register applications that sign users in through single sign-on"},
+	{CourseCreate, "Create courses"},
+	{CourseUpdate, "Edit and archive courses"},
+	{RosterRead, "View rosters of courses you teach"},
+	{RosterReadAny, "View the roster of any course"},
+	{GradesSubmit, "Submit grades for courses you teach"},
+	{RecordsRead, "View any student's academic record"},
+	{RegistrationOwn, "Register for and drop courses as a student"},
+	{ClassroomManage, "Post modules, announcements and assignments in classrooms you teach or assist"},
+	{ClassroomSubmit, "Submit work in classrooms you are enrolled in"},
+}
+
+// ServiceScopes lists the service-only scopes.
+var ServiceScopes = []Definition{
+	{ClassroomSync, "Sync ERP rosters, instructors and TAs into classrooms"},
+}
+
+// BuiltinRoles are seeded on first start. Admins can change their permissions later,
+// and can add further roles (e.g. registrar, department head, auditor) at runtime.
+var BuiltinRoles = map[string][]string{
+	"admin":      {All},
+	"instructor": {RosterRead, GradesSubmit, ClassroomManage},
+	"ta":         {RegistrationOwn, ClassroomManage, ClassroomSubmit},
+	"student":    {RegistrationOwn, ClassroomSubmit},
+}
+
+// Grants reports whether any of the granted permissions covers the required one.
+func Grants(granted []string, required string) bool {
+	for _, p := range granted {
+		if p == required {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p, "*"); ok && strings.HasPrefix(required, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Known reports whether a permission name is in the registry.
+func Known(name string) bool {
+	for _, d := range Registry {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// KnownScope reports whether a service account may be given a scope: a service-only
+// scope or any registered permission other than "*".
+func KnownScope(name string) bool {
+	return IsServiceScope(name) || (name != All && Known(name))
+}
+
+// IsServiceScope reports whether a scope is service-only rather than a permission.
+func IsServiceScope(name string) bool {
+	for _, d := range ServiceScopes {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}